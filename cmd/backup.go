@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// Flags of 'backup vm' and 'restore'.
+var (
+	backupDir      string
+	restoreOptions vm.RestoreOptions
+)
+
+// backupCmd groups the backup commands.
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up resources like VMs",
+}
+
+// 'backup vm' subcommand: writes the disks and state of a VM to a directory.
+var backupVMCmd = &cobra.Command{
+	Use:   "vm <name>",
+	Short: "Back up the disks and state of a VM",
+	Long: "Write a compressed, standalone qcow2 file per disk of a VM and a JSON sidecar\n" +
+		"holding the VM as stored in state to a directory. A running VM is backed up\n" +
+		"with a libvirt backup job, its disks are then crash consistent: the directory\n" +
+		"must be writable by libvirt's QEMU processes.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sidecar, err := operations.BackupVM(args[0], Namespace, backupDir)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s backed up to %s\n", args[0], sidecar)
+	},
+}
+
+// restoreCmd creates a VM from a backup.
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup.json>",
+	Short: "Create a VM from a backup",
+	Long: "Create a VM from the backup whose JSON sidecar is given. The disks are copied\n" +
+		"into the images path of the store, and the VM keeps its addresses, with their\n" +
+		"DHCP reservations registered again, unless --new-addresses is set.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		restoreOptions.Namespace = Namespace
+		restored, err := operations.RestoreVM(args[0], restoreOptions)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm %s restored\n", restored)
+	},
+}
+
+func init() {
+	backupVMCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace of the VM")
+	backupVMCmd.Flags().
+		StringVar(&backupDir, "to", "", "Directory to write the backup to")
+	_ = backupVMCmd.MarkFlagRequired("to")
+	backupCmd.AddCommand(backupVMCmd)
+
+	restoreCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace of the VM (default: the backup's)")
+	restoreCmd.Flags().
+		StringVar(&restoreOptions.Name, "name", "", "Name of the VM (default: the backup's)")
+	restoreCmd.Flags().
+		StringVar(&restoreOptions.Store, "store", "", "Store to put the disks in (default: the backup's)")
+	restoreCmd.Flags().
+		BoolVar(&restoreOptions.NewAddresses, "new-addresses", false, "Give the VM new addresses and MACs")
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+)
+
+// Flags of 'clone vm'.
+var (
+	cloneIP              string
+	cloneSourceNamespace string
+	cloneLinked          bool
+	cloneFull            bool
+)
+
+// cloneCmd groups the clone commands.
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Clone resources like VMs",
+}
+
+// 'clone vm' subcommand: creates a VM from another one.
+var cloneVMCmd = &cobra.Command{
+	Use:   "vm <source-vm> <new-vm>",
+	Short: "Create a new VM from a stopped VM",
+	Long: "Create a new VM from a stopped VM, with new addresses and MACs.\n" +
+		"A full clone (default) copies the disk. A linked clone shares the current\n" +
+		"disk of the source as a read-only base, which needs a source without snapshots.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := operations.CloneVM(
+			args[0], cloneSourceNamespace, args[1], Namespace, cloneIP, cloneLinked,
+		)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s cloned from vm/%s\n", args[1], args[0])
+	},
+}
+
+func init() {
+	cloneVMCmd.Flags().
+		StringVar(&cloneIP, "ip", "", "IP of the clone (allocated from the network if empty)")
+	cloneVMCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace of the clone (default: the source's)")
+	cloneVMCmd.Flags().
+		StringVar(&cloneSourceNamespace, "source-namespace", "", "Namespace of the source VM")
+	cloneVMCmd.Flags().
+		BoolVar(&cloneLinked, "linked", false, "Share the source disk as a base instead of copying it")
+	cloneVMCmd.Flags().
+		BoolVar(&cloneFull, "full", false, "Copy the source disk (default)")
+	cloneVMCmd.MarkFlagsMutuallyExclusive("linked", "full")
+	cloneCmd.AddCommand(cloneVMCmd)
+}
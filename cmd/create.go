@@ -16,7 +16,7 @@ var CreateCmd = &cobra.Command{
 		}
 
 		// Use the provided configuration file to create resources.
-		if err := operations.CreateFromManifest(ManifestPath); err != nil {
+		if err := operations.CreateFromManifest(ManifestPath, Force); err != nil {
 			log.Errorf("%v", err)
 		}
 	},
@@ -26,4 +26,6 @@ func init() {
 	// Bind the manifest file flag to the global variable.
 	CreateCmd.Flags().
 		StringVarP(&ManifestPath, "file", "f", "", "Configuration file for the resource(s)")
+	CreateCmd.Flags().
+		BoolVar(&Force, "force", false, "Drop images from stores even if VM disks are backed by them")
 }
@@ -17,12 +17,14 @@ var DeleteCmd = &cobra.Command{
 			log.Errorf("Manifest file is required (-f flag)")
 		}
 		// Call your delete operation with the provided file.
-		operations.DeleteFromManifest(ManifestPath)
+		operations.DeleteFromManifest(ManifestPath, Force)
 	},
 }
 
 func init() {
 	DeleteCmd.Flags().
 		StringVarP(&ManifestPath, "file", "f", "", "Manifest file for the resource(s) to delete")
+	DeleteCmd.Flags().
+		BoolVar(&Force, "force", false, "Delete stores even if VM disks are backed by their images")
 	// DeleteCmd.Flags().BoolVar(&DeleteAll, "all", false, "Delete all VMs")
 }
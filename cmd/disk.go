@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+)
+
+// Flags of 'disk rebase vm'.
+var diskRebaseImage string
+
+// diskCmd groups the commands on VM disks.
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Manage the disks of resources like VMs",
+}
+
+// diskFlattenCmd groups the flatten commands.
+var diskFlattenCmd = &cobra.Command{
+	Use:   "flatten",
+	Short: "Make disks standalone",
+}
+
+// 'disk flatten vm' subcommand: merges the backing chains into the disks.
+var diskFlattenVMCmd = &cobra.Command{
+	Use:   "vm <name>",
+	Short: "Merge the backing images of the disks of a VM into them",
+	Long: "Merge the backing chains of the disks of a VM into the disks, which no longer\n" +
+		"depend on an image of the store or on the base of a linked clone. A running VM\n" +
+		"is flattened with a libvirt block pull, a stopped one with qemu-img convert.\n" +
+		"VMs with snapshots can't be flattened.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.FlattenDisk(args[0], Namespace); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s disks flattened\n", args[0])
+	},
+}
+
+// diskRebaseCmd groups the rebase commands.
+var diskRebaseCmd = &cobra.Command{
+	Use:   "rebase",
+	Short: "Move disks onto another backing image",
+}
+
+// 'disk rebase vm' subcommand: moves the root disk onto another image.
+var diskRebaseVMCmd = &cobra.Command{
+	Use:   "vm <name>",
+	Short: "Move the root disk of a stopped VM onto another image of its store",
+	Long: "Move the root disk of a stopped VM onto another image of its store. The data\n" +
+		"that differs between the old and new images is copied into the disk, so the\n" +
+		"guest sees the same disk. VMs with snapshots can't be rebased.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.RebaseDisk(args[0], Namespace, diskRebaseImage); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s disk rebased onto image %q\n", args[0], diskRebaseImage)
+	},
+}
+
+func init() {
+	diskFlattenVMCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace of the VM")
+	diskRebaseVMCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace of the VM")
+	diskRebaseVMCmd.Flags().
+		StringVar(&diskRebaseImage, "image", "", "Image of the store to move the disk onto")
+	_ = diskRebaseVMCmd.MarkFlagRequired("image")
+
+	diskFlattenCmd.AddCommand(diskFlattenVMCmd)
+	diskRebaseCmd.AddCommand(diskRebaseVMCmd)
+	diskCmd.AddCommand(diskFlattenCmd, diskRebaseCmd)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
 )
 
 // Create the "get" parent command.
@@ -18,14 +19,9 @@ var GetVMCmd = &cobra.Command{
 	Use:   "vm",
 	Short: "Display information about virtual machines",
 	Run: func(cmd *cobra.Command, args []string) {
-		if Namespace != "" {
-			// operations.ListByNamespace(Namespace)
-			fmt.Println("List by namespace here for vms")
-			// operations.ListAll()
-			return
+		if err := operations.ListObjects("vm", Namespace, Output == "wide"); err != nil {
+			log.Errorf("%v", err)
 		}
-		// operations.ListAll()
-		fmt.Println("I'm working on it")
 	},
 }
 
@@ -35,11 +31,9 @@ var GetSnapshotsCmd = &cobra.Command{
 	Aliases: []string{"snap"},
 	Short:   "Display snapshots for virtual machines",
 	Run: func(cmd *cobra.Command, args []string) {
-		if ConfigFile == "" {
-			log.Errorf("Configuration file is required (-f flag)")
+		if err := operations.ListSnapshots("", Namespace); err != nil {
+			log.Errorf("%v", err)
 		}
-		fmt.Println("You snapshosts are here")
-		// op.ListSnapshost()
 	},
 }
 
@@ -75,10 +69,24 @@ var GetStoreCmd = &cobra.Command{
 	},
 }
 
+// 'get portforwards' subcommand: shows port forwards of VMs.
+var GetPortForwardsCmd = &cobra.Command{
+	Use:     "portforwards",
+	Aliases: []string{"portforward", "pf"},
+	Short:   "Display host to VM port forwards",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.ListPortForwards(Namespace); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
 func init() {
 	// Flags for virtual machines
 	GetVMCmd.Flags().
 		StringVarP(&Namespace, "namespace", "n", "", "Namespace")
+	GetVMCmd.Flags().
+		StringVarP(&Output, "output", "o", "", "Output format (wide shows the addresses guests use)")
 		// Flags for Networks
 	GetNetworkCmd.Flags().
 		StringVarP(&Namespace, "namespace", "n", "", "Namespace")
@@ -88,5 +96,8 @@ func init() {
 		// Flags for stores
 	GetStoreCmd.Flags().
 		StringVarP(&Namespace, "namespace", "n", "", "Namespace")
-	GetCmd.AddCommand(GetVMCmd, GetSnapshotsCmd, GetNetworkCmd, GetStoreCmd)
+		// Flags for port forwards
+	GetPortForwardsCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace")
+	GetCmd.AddCommand(GetVMCmd, GetSnapshotsCmd, GetNetworkCmd, GetStoreCmd, GetPortForwardsCmd)
 }
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+)
+
+// Flags of 'image pull'.
+var imagePullForce bool
+
+// Flags of 'image add'.
+var imageAddSpec store.Image
+
+// imageCmd groups the commands on the images of a store.
+var imageCmd = &cobra.Command{
+	Use:     "image",
+	Aliases: []string{"images"},
+	Short:   "Manage the images of a store",
+}
+
+// 'image pull' subcommand: downloads an image from its source.
+var imagePullCmd = &cobra.Command{
+	Use:   "pull <store> <image>",
+	Short: "Download an image from its source into the store",
+	Long: "Download an image from the source of its image block into the artifacts\n" +
+		"path of the store. An interrupted download resumes, the checksum is verified\n" +
+		"and raw, vmdk or vhdx images are converted to qcow2.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.PullImage(args[0], Namespace, args[1], imagePullForce); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("image %s pulled into store/%s\n", args[1], args[0])
+	},
+}
+
+// 'image verify' subcommand: audits the image files of stores.
+var imageVerifyCmd = &cobra.Command{
+	Use:   "verify [store]",
+	Short: "Check that the images of a store are usable base images",
+	Long: "Check that the image files of a store, or of every store, exist, are qcow2\n" +
+		"and match their declared checksum. Checksums are cached while a file is unchanged.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		storeName := ""
+		if len(args) == 1 {
+			storeName = args[0]
+		}
+		if err := operations.VerifyImages(storeName, Namespace); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+// 'image list' subcommand: prints the image catalog of stores.
+var imageListCmd = &cobra.Command{
+	Use:     "list [store]",
+	Aliases: []string{"ls"},
+	Short:   "List the images of a store, or of every store",
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		storeName := ""
+		if len(args) == 1 {
+			storeName = args[0]
+		}
+		if err := operations.ListImages(storeName, Namespace); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+// 'image describe' subcommand: prints the details of one image.
+var imageDescribeCmd = &cobra.Command{
+	Use:   "describe <store> <image>",
+	Short: "Show the details of an image and the VMs using it",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.DescribeImage(args[0], Namespace, args[1]); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+// 'image add' subcommand: adds an image to the catalog of a store.
+var imageAddCmd = &cobra.Command{
+	Use:   "add <store> <image>",
+	Short: "Add an image to the catalog of a store",
+	Long: "Add an image to the catalog of a store. The image needs a --file in the\n" +
+		"artifacts path of the store, or a --source to pull it from.\n" +
+		"Applying the store from a file again replaces its catalog with the file's image blocks.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		image := imageAddSpec
+		image.Name = args[1]
+		if err := operations.AddImage(args[0], Namespace, image); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("image %s added to store/%s\n", args[1], args[0])
+	},
+}
+
+// 'image remove' subcommand: removes an image from the catalog of a store.
+var imageRemoveCmd = &cobra.Command{
+	Use:     "remove <store> <image>",
+	Aliases: []string{"rm"},
+	Short:   "Remove an image from the catalog of a store, keeping its file",
+	Long: "Remove an image from the catalog of a store, keeping its file. It refuses\n" +
+		"while VM disks are backed by the image, unless --force is set.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.RemoveImage(args[0], Namespace, args[1], Force); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("image %s removed from store/%s\n", args[1], args[0])
+	},
+}
+
+func init() {
+	imagePullCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the store")
+	imagePullCmd.Flags().
+		BoolVar(&imagePullForce, "force", false, "Pull the image again even if it is in the store")
+	imageCmd.AddCommand(imagePullCmd)
+
+	imageVerifyCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the stores")
+	imageCmd.AddCommand(imageVerifyCmd)
+
+	imageListCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the stores")
+	imageCmd.AddCommand(imageListCmd)
+
+	imageDescribeCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the store")
+	imageCmd.AddCommand(imageDescribeCmd)
+
+	imageAddCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the store")
+	imageAddCmd.Flags().
+		StringVar(&imageAddSpec.File, "file", "", "Image file, relative to the artifacts path of the store")
+	imageAddCmd.Flags().
+		StringVar(&imageAddSpec.Source, "source", "", "http(s):// or file:// URL to pull the image from")
+	imageAddCmd.Flags().
+		StringVar(&imageAddSpec.Checksum, "checksum", "", "Checksum of the file, sha256:<hex> or sha512:<hex>")
+	imageAddCmd.Flags().StringVar(&imageAddSpec.Display, "display", "", "Display name")
+	imageAddCmd.Flags().StringVar(&imageAddSpec.Version, "version", "", "Version of the image")
+	imageAddCmd.Flags().StringVar(&imageAddSpec.OSProfile, "os-profile", "", "OS profile (libosinfo URL)")
+	imageAddCmd.Flags().StringVar(&imageAddSpec.Size, "size", "", "Declared size, e.g. 2.6G")
+	imageCmd.AddCommand(imageAddCmd)
+
+	imageRemoveCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace of the store")
+	imageRemoveCmd.Flags().
+		BoolVar(&Force, "force", false, "Remove the image even if VM disks are backed by it")
+	imageCmd.AddCommand(imageRemoveCmd)
+}
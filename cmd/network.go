@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+)
+
+// networkCmd groups network maintenance commands.
+var networkCmd = &cobra.Command{
+	Use:     "network",
+	Aliases: []string{"net"},
+	Short:   "Manage libvirt networks",
+}
+
+// 'network prune-reservations' subcommand: removes stale DHCP reservations.
+var networkPruneReservationsCmd = &cobra.Command{
+	Use:   "prune-reservations [network-name]",
+	Short: "Remove DHCP reservations that no VM in state owns",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var networkName string
+		if len(args) == 1 {
+			networkName = args[0]
+		}
+		if err := operations.PruneReservations(networkName); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+// 'network ips' subcommand: lists addresses used on a network.
+var networkIPsCmd = &cobra.Command{
+	Use:   "ips <network-name>",
+	Short: "List IP addresses used and allocated on a network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.ListNetworkIPs(args[0]); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+// Flag of 'network leases'.
+var unknownLeasesOnly bool
+
+// 'network leases' subcommand: lists DHCP leases of a network.
+var networkLeasesCmd = &cobra.Command{
+	Use:   "leases <network-name>",
+	Short: "List DHCP leases of a network and the VMs that hold them",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.ListNetworkLeases(args[0], unknownLeasesOnly); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+func init() {
+	networkLeasesCmd.Flags().
+		BoolVar(&unknownLeasesOnly, "unknown", false, "Only show leases of MACs no VM in state owns")
+	networkCmd.AddCommand(networkPruneReservationsCmd, networkIPsCmd, networkLeasesCmd)
+}
@@ -13,7 +13,9 @@ var (
 	ClusterFile  string // Path of the cluster file.
 	Provision    bool   // Flag to start provisioning.
 	DeleteAll    bool   // Flag to delete all VMs.
+	Force        bool   // Flag to remove images VM disks are backed by.
 	Verbose      bool   // Flag for verbose output.
+	Output       string // Output format of get commands.
 )
 
 // rootCmd is the base command for kvmcli.
@@ -38,4 +40,13 @@ func init() {
 	rootCmd.AddCommand(GetCmd)
 	rootCmd.AddCommand(ShowVersion)
 	rootCmd.AddCommand(InitVMCmd)
+	rootCmd.AddCommand(networkCmd)
+	rootCmd.AddCommand(waitCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(imageCmd)
+	rootCmd.AddCommand(storeCmd)
+	rootCmd.AddCommand(diskCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
 }
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+)
+
+// Flags of 'snapshot create vm'.
+var (
+	snapshotDescription string
+	snapshotQuiesce     bool
+)
+
+// snapshotCmd groups the VM snapshot commands.
+var snapshotCmd = &cobra.Command{
+	Use:     "snapshot",
+	Aliases: []string{"snapshots", "snap"},
+	Short:   "Create, list, revert and delete VM snapshots",
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Take a snapshot",
+}
+
+// 'snapshot create vm' subcommand: snapshots a VM.
+var snapshotCreateVMCmd = &cobra.Command{
+	Use:   "vm <vm-name> <snapshot-name>",
+	Short: "Take a snapshot of a virtual machine",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := operations.CreateSnapshot(
+			args[0], Namespace, args[1], snapshotDescription, snapshotQuiesce,
+		)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s snapshot %s created\n", args[0], args[1])
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List snapshots",
+}
+
+// 'snapshot list vm' subcommand: lists the snapshots of a VM.
+var snapshotListVMCmd = &cobra.Command{
+	Use:   "vm <vm-name>",
+	Short: "List the snapshots of a virtual machine",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.ListSnapshots(args[0], Namespace); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+var snapshotRevertCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Revert to a snapshot",
+}
+
+// 'snapshot revert vm' subcommand: reverts a VM to a snapshot.
+var snapshotRevertVMCmd = &cobra.Command{
+	Use:   "vm <vm-name> <snapshot-name>",
+	Short: "Revert a virtual machine to a snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.RevertSnapshot(args[0], Namespace, args[1]); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s reverted to snapshot %s\n", args[0], args[1])
+	},
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a snapshot",
+}
+
+// 'snapshot delete vm' subcommand: deletes a snapshot of a VM.
+var snapshotDeleteVMCmd = &cobra.Command{
+	Use:   "vm <vm-name> <snapshot-name>",
+	Short: "Delete a snapshot of a virtual machine",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.DeleteSnapshot(args[0], Namespace, args[1]); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s snapshot %s deleted\n", args[0], args[1])
+	},
+}
+
+// 'snapshots run-due' subcommand: runs the due snapshot policies.
+var snapshotRunDueCmd = &cobra.Command{
+	Use:   "run-due",
+	Short: "Take the snapshots whose snapshot_policy is due and prune old ones",
+	Long: "Take the snapshots whose snapshot_policy is due and prune old ones.\n" +
+		"Meant to run periodically, e.g. from a systemd timer every few minutes.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.RunDueSnapshots(Namespace); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+func init() {
+	snapshotCreateVMCmd.Flags().
+		StringVarP(&snapshotDescription, "description", "d", "", "Description of the snapshot")
+	snapshotCreateVMCmd.Flags().
+		BoolVar(&snapshotQuiesce, "quiesce", false, "Freeze guest file systems during the snapshot (needs the guest agent)")
+	for _, command := range []*cobra.Command{
+		snapshotCreateVMCmd,
+		snapshotListVMCmd,
+		snapshotRevertVMCmd,
+		snapshotDeleteVMCmd,
+		snapshotRunDueCmd,
+	} {
+		command.Flags().StringVarP(&Namespace, "namespace", "n", "", "Namespace")
+	}
+	snapshotCreateCmd.AddCommand(snapshotCreateVMCmd)
+	snapshotListCmd.AddCommand(snapshotListVMCmd)
+	snapshotRevertCmd.AddCommand(snapshotRevertVMCmd)
+	snapshotDeleteCmd.AddCommand(snapshotDeleteVMCmd)
+	snapshotCmd.AddCommand(
+		snapshotCreateCmd,
+		snapshotListCmd,
+		snapshotRevertCmd,
+		snapshotDeleteCmd,
+		snapshotRunDueCmd,
+	)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+)
+
+// Flags of 'store gc'.
+var storeGCDryRun bool
+
+// storeCmd groups the commands on stores.
+var storeCmd = &cobra.Command{
+	Use:     "store",
+	Aliases: []string{"stores"},
+	Short:   "Manage stores",
+}
+
+// 'store gc' subcommand: removes the files of stores nothing uses.
+var storeGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove orphaned disks, ISOs and partial downloads from stores",
+	Long: "Remove the disks and ISOs in the images path of stores that no VM in state\n" +
+		"and no libvirt domain uses, and the partial downloads left in artifacts paths.\n" +
+		"Image files of the store catalogs are never removed. Do not run it during an\n" +
+		"apply or an image pull.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := operations.GCStores(storeGCDryRun); err != nil {
+			log.Errorf("%v", err)
+		}
+	},
+}
+
+func init() {
+	storeGCCmd.Flags().
+		BoolVar(&storeGCDryRun, "dry-run", false, "Only list the files that would be removed")
+	storeCmd.AddCommand(storeGCCmd)
+}
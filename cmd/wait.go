@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	log "github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/operations"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// Flags of 'wait vm'.
+var (
+	waitCondition string
+	waitTimeout   time.Duration
+)
+
+// waitCmd groups the commands waiting for resources to reach a state.
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait for resources to reach a state",
+}
+
+// 'wait vm' subcommand: blocks until a VM reaches a condition.
+var waitVMCmd = &cobra.Command{
+	Use:   "vm <vm-name>",
+	Short: "Wait until a VM has an IP, answers on SSH, runs its guest agent or shuts down",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !slices.Contains(vm.WaitConditions, waitCondition) {
+			log.Errorf(
+				"invalid --for %q, expected one of %s",
+				waitCondition,
+				strings.Join(vm.WaitConditions, ", "),
+			)
+			return
+		}
+		if err := operations.WaitVM(args[0], Namespace, waitCondition, waitTimeout); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		fmt.Printf("vm/%s condition met: %s\n", args[0], waitCondition)
+	},
+}
+
+func init() {
+	waitVMCmd.Flags().
+		StringVar(&waitCondition, "for", vm.WaitIP, "Condition: "+strings.Join(vm.WaitConditions, ", "))
+	waitVMCmd.Flags().
+		DurationVar(&waitTimeout, "timeout", 5*time.Minute, "Give up after this duration")
+	waitVMCmd.Flags().
+		StringVarP(&Namespace, "namespace", "n", "", "Namespace")
+	waitCmd.AddCommand(waitVMCmd)
+}
@@ -3,7 +3,8 @@ package config
 import "github.com/zakariakebairia/kvmcli/internal/registry"
 
 // buildObjects converts all HCL resource configs into registry Objects.
-// Order: networks and stores first (no deps), then VMs (depend on both).
+// Order: networks, stores and firewalls first (no deps), then VMs
+// (depend on them).
 func buildObjects(cfg *hclConfig) []registry.Object {
 	var objects []registry.Object
 
@@ -11,10 +12,57 @@ func buildObjects(cfg *hclConfig) []registry.Object {
 		attrs := map[string]any{
 			"bridge":      n.Bridge,
 			"mode":        n.Mode,
+			"cidr":        n.CIDR,
 			"net_address": n.NetAddress,
 			"netmask":     n.NetMask,
 			"autostart":   n.Autostart,
 		}
+		if n.Dev != "" {
+			attrs["dev"] = n.Dev
+		}
+		if n.NAT != nil {
+			attrs["nat"] = map[string]any{
+				"port_start": n.NAT.PortStart,
+				"port_end":   n.NAT.PortEnd,
+			}
+		}
+		if len(n.Routes) > 0 {
+			routes := make([]map[string]any, 0, len(n.Routes))
+			for _, route := range n.Routes {
+				routes = append(routes, map[string]any{
+					"cidr":    route.CIDR,
+					"gateway": route.Gateway,
+				})
+			}
+			attrs["routes"] = routes
+		}
+		if len(n.IPs) > 0 {
+			ips := make([]map[string]any, 0, len(n.IPs))
+			for _, block := range n.IPs {
+				ip := map[string]any{
+					"cidr":    block.CIDR,
+					"gateway": block.Gateway,
+				}
+				if block.DHCP != nil {
+					ip["dhcp"] = map[string]any{
+						"start": block.DHCP.Start,
+						"end":   block.DHCP.End,
+					}
+				}
+				ips = append(ips, ip)
+			}
+			attrs["ips"] = ips
+		}
+		if n.MTU != 0 {
+			attrs["mtu"] = n.MTU
+		}
+		if n.Bandwidth != nil {
+			attrs["bandwidth"] = bandwidthAttrs(n.Bandwidth)
+		}
+		if n.DNS != nil {
+			attrs["domain"] = n.DNS.Domain
+			attrs["dns"] = dnsAttrs(n.DNS)
+		}
 		if n.DHCP != nil {
 			attrs["dhcp"] = map[string]any{
 				"start": n.DHCP.Start,
@@ -41,6 +89,7 @@ func buildObjects(cfg *hclConfig) []registry.Object {
 				"file":       image.File,
 				"checksum":   image.Checksum,
 				"size":       image.Size,
+				"source":     image.Source,
 			})
 		}
 		objects = append(objects, registry.Object{
@@ -57,24 +106,197 @@ func buildObjects(cfg *hclConfig) []registry.Object {
 		})
 	}
 
-	for _, v := range cfg.VMs {
+	for _, f := range cfg.Firewalls {
+		rules := make([]map[string]any, 0, len(f.Rules))
+		for _, rule := range f.Rules {
+			rules = append(rules, map[string]any{
+				"action":    rule.Action,
+				"direction": rule.Direction,
+				"protocol":  rule.Protocol,
+				"ports":     rule.Ports,
+				"cidrs":     rule.CIDRs,
+				"priority":  rule.Priority,
+			})
+		}
 		objects = append(objects, registry.Object{
+			TypeName:  "firewall",
+			Name:      f.Name,
+			Namespace: f.Namespace,
+			Labels:    f.Labels,
+			Attrs: map[string]any{
+				"chain":          f.Chain,
+				"priority":       f.Priority,
+				"include":        f.Include,
+				"default_action": f.DefaultAction,
+				"rules":          rules,
+			},
+		})
+	}
+
+	for _, v := range cfg.VMs {
+		disks := make([]map[string]any, 0, len(v.Disks))
+		for _, disk := range v.Disks {
+			disks = append(disks, map[string]any{
+				"name":   disk.Name,
+				"size":   disk.Size,
+				"bus":    disk.Bus,
+				"format": disk.Format,
+				"cache":  disk.Cache,
+				"image":  disk.Image,
+				"keep":   disk.Keep,
+			})
+		}
+		// The VM-level network/ip/mac is the primary interface,
+		// interface blocks are appended after it.
+		interfaces := make([]map[string]any, 0, len(v.Interfaces)+1)
+		if v.NetName != "" {
+			interfaces = append(interfaces, map[string]any{
+				"network": v.NetName,
+				"ip":      v.IP,
+				"ipv6":    v.IPv6,
+				"mac":     v.MAC,
+				"model":   "",
+			})
+			addQoSAttrs(interfaces[0], v.MTU, v.Bandwidth)
+			addFilterAttrs(interfaces[0], v.Filter, v.FilterArgs)
+		}
+		for _, iface := range v.Interfaces {
+			interfaces = append(interfaces, map[string]any{
+				"network": iface.NetName,
+				"ip":      iface.IP,
+				"ipv6":    iface.IPv6,
+				"mac":     iface.MAC,
+				"model":   iface.Model,
+			})
+			addQoSAttrs(interfaces[len(interfaces)-1], iface.MTU, iface.Bandwidth)
+			addFilterAttrs(interfaces[len(interfaces)-1], iface.Filter, iface.FilterArgs)
+		}
+		primary := interfaces[0]
+
+		forwards := make([]map[string]any, 0, len(v.Forwards))
+		for _, forward := range v.Forwards {
+			forwards = append(forwards, map[string]any{
+				"host_port":    forward.HostPort,
+				"guest_port":   forward.GuestPort,
+				"protocol":     forward.Protocol,
+				"host_address": forward.HostAddress,
+			})
+		}
+
+		object := registry.Object{
 			TypeName:  "vm",
 			Name:      v.Name,
 			Namespace: v.Namespace,
 			Labels:    v.Labels,
 			Attrs: map[string]any{
-				"cpu":         v.CPU,
-				"memory":      v.Memory,
-				"disk":        v.Disk,
-				"image":       v.Image,
-				"network":     v.NetName,
-				"store":       v.Store,
-				"ip":          v.IP,
-				"mac_address": v.MAC,
+				"cpu":           v.CPU,
+				"memory":        v.Memory,
+				"disk":          v.Disk,
+				"image":         v.Image,
+				"network":       primary["network"],
+				"store":         v.Store,
+				"ip":            primary["ip"],
+				"ipv6":          primary["ipv6"],
+				"mac_address":   primary["mac"],
+				"disks":         disks,
+				"port_forwards": forwards,
+				"interfaces":    interfaces,
 			},
-		})
+		}
+		if v.WaitFor != nil {
+			object.Attrs["wait_for"] = map[string]any{
+				"ip":          v.WaitFor.IP,
+				"ssh":         v.WaitFor.SSH,
+				"guest_agent": v.WaitFor.GuestAgent,
+				"timeout":     v.WaitFor.Timeout,
+			}
+		}
+		if p := v.Snapshots; p != nil {
+			object.Attrs["snapshot_policy"] = map[string]any{
+				"schedule":    p.Schedule,
+				"keep_last":   p.KeepLast,
+				"keep_daily":  p.KeepDaily,
+				"keep_weekly": p.KeepWeekly,
+				"quiesce":     p.Quiesce,
+			}
+		}
+		objects = append(objects, object)
 	}
 
 	return objects
 }
+
+// dnsAttrs converts a dns block to its stored form.
+func dnsAttrs(dns *dnsDef) map[string]any {
+	hosts := make([]map[string]any, 0, len(dns.Hosts))
+	for _, host := range dns.Hosts {
+		hosts = append(hosts, map[string]any{
+			"ip":        host.IP,
+			"hostnames": host.Hostnames,
+		})
+	}
+	srvs := make([]map[string]any, 0, len(dns.SRVs))
+	for _, srv := range dns.SRVs {
+		srvs = append(srvs, map[string]any{
+			"service":  srv.Service,
+			"protocol": srv.Protocol,
+			"domain":   srv.Domain,
+			"target":   srv.Target,
+			"port":     srv.Port,
+			"priority": srv.Priority,
+			"weight":   srv.Weight,
+		})
+	}
+	txts := make([]map[string]any, 0, len(dns.TXTs))
+	for _, txt := range dns.TXTs {
+		txts = append(txts, map[string]any{
+			"name":  txt.Name,
+			"value": txt.Value,
+		})
+	}
+	return map[string]any{
+		"forwarders": dns.Forwarders,
+		"hosts":      hosts,
+		"srv":        srvs,
+		"txt":        txts,
+	}
+}
+
+// bandwidthAttrs converts a bandwidth block to its stored form.
+func bandwidthAttrs(bandwidth *bandwidthDef) map[string]any {
+	attrs := make(map[string]any)
+	for direction, rate := range map[string]*rateDef{
+		"inbound":  bandwidth.Inbound,
+		"outbound": bandwidth.Outbound,
+	} {
+		if rate != nil {
+			attrs[direction] = map[string]any{
+				"average": rate.Average,
+				"peak":    rate.Peak,
+				"burst":   rate.Burst,
+			}
+		}
+	}
+	return attrs
+}
+
+// addQoSAttrs stores the mtu and bandwidth of an interface, when set.
+func addQoSAttrs(iface map[string]any, mtu int, bandwidth *bandwidthDef) {
+	if mtu != 0 {
+		iface["mtu"] = mtu
+	}
+	if bandwidth != nil {
+		iface["bandwidth"] = bandwidthAttrs(bandwidth)
+	}
+}
+
+// addFilterAttrs stores the firewall of an interface, when set.
+func addFilterAttrs(iface map[string]any, filter string, params map[string]string) {
+	if filter == "" {
+		return
+	}
+	iface["filter"] = filter
+	if len(params) > 0 {
+		iface["filter_params"] = params
+	}
+}
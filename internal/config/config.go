@@ -16,17 +16,42 @@ import (
 
 // hclConfig represents a complete kvmcli HCL file.
 type hclConfig struct {
-	Locals   *hclLocals   `hcl:"locals,block"`
-	Networks []networkDef `hcl:"network,block"`
-	VMs      []vmDef      `hcl:"vm,block"`
-	Stores   []storeDef   `hcl:"store,block"`
-	Data     []dataRef    `hcl:"data,block"`
+	Locals    *hclLocals    `hcl:"locals,block"`
+	Networks  []networkDef  `hcl:"network,block"`
+	VMs       []vmDef       `hcl:"vm,block"`
+	Stores    []storeDef    `hcl:"store,block"`
+	Firewalls []firewallDef `hcl:"firewall,block"`
+	Data      []dataRef     `hcl:"data,block"`
 }
 
 type hclLocals struct {
 	Values map[string]hcl.Expression `hcl:",remain"`
 }
 
+// firewallDef describes a firewall block in HCL, a libvirt network filter
+// that VM interfaces reference with filter = firewall.<name>.
+type firewallDef struct {
+	Name          string            `hcl:"name,label"`
+	Namespace     string            `hcl:"namespace"`
+	Chain         string            `hcl:"chain,optional"`
+	Priority      int               `hcl:"priority,optional"`
+	Include       []string          `hcl:"include,optional"`
+	DefaultAction string            `hcl:"default_action,optional"`
+	Rules         []firewallRuleDef `hcl:"rule,block"`
+	Labels        map[string]string `hcl:"labels,optional"`
+}
+
+// firewallRuleDef is one rule of a firewall.
+// Example: rule { direction = "in", protocol = "tcp", ports = ["22", "8000-8100"] }
+type firewallRuleDef struct {
+	Action    string   `hcl:"action,optional"`
+	Direction string   `hcl:"direction"`
+	Protocol  string   `hcl:"protocol,optional"`
+	Ports     []string `hcl:"ports,optional"`
+	CIDRs     []string `hcl:"cidrs,optional"`
+	Priority  int      `hcl:"priority,optional"`
+}
+
 // dataRef is a reference to a resource that already exists in the DB.
 // Example: data "store" "homelab" {}
 type dataRef struct {
@@ -36,19 +61,101 @@ type dataRef struct {
 
 // vmDef describes a virtual machine block in HCL.
 type vmDef struct {
-	Name      string         `hcl:"name,label"`
-	Namespace string         `hcl:"namespace"`
-	Image     string         `hcl:"image"`
-	CPU       int            `hcl:"cpu"`
-	Memory    int            `hcl:"memory"`
-	Disk      string         `hcl:"disk,optional"`
-	NetExpr   hcl.Expression `hcl:"network,attr"`
-	NetName   string
-	StoreExpr hcl.Expression `hcl:"store,attr"`
-	Store     string
-	MAC       string            `hcl:"mac,optional"`
-	IP        string            `hcl:"ip,optional"`
-	Labels    map[string]string `hcl:"labels,optional"`
+	Name       string         `hcl:"name,label"`
+	Namespace  string         `hcl:"namespace"`
+	Image      string         `hcl:"image"`
+	CPU        int            `hcl:"cpu"`
+	Memory     int            `hcl:"memory"`
+	Disk       string         `hcl:"disk,optional"`
+	NetExpr    hcl.Expression `hcl:"network,optional"`
+	NetName    string
+	StoreExpr  hcl.Expression `hcl:"store,attr"`
+	Store      string
+	MAC        string         `hcl:"mac,optional"`
+	IP         string         `hcl:"ip,optional"`
+	IPv6       string         `hcl:"ipv6,optional"`
+	FilterExpr hcl.Expression `hcl:"filter,optional"`
+	Filter     string
+	FilterArgs map[string]string  `hcl:"filter_params,optional"`
+	MTU        int                `hcl:"mtu,optional"`
+	Bandwidth  *bandwidthDef      `hcl:"bandwidth,block"`
+	Labels     map[string]string  `hcl:"labels,optional"`
+	Disks      []diskDef          `hcl:"disk,block"`
+	Interfaces []interfaceDef     `hcl:"interface,block"`
+	Forwards   []portForwardDef   `hcl:"port_forward,block"`
+	WaitFor    *waitForDef        `hcl:"wait_for,block"`
+	Snapshots  *snapshotPolicyDef `hcl:"snapshot_policy,block"`
+}
+
+// waitForDef makes apply wait until the VM is ready.
+// Example: wait_for { ip = true, ssh = true, timeout = "5m" }
+type waitForDef struct {
+	IP         bool   `hcl:"ip,optional"`
+	SSH        bool   `hcl:"ssh,optional"`
+	GuestAgent bool   `hcl:"guest_agent,optional"`
+	Timeout    string `hcl:"timeout,optional"`
+}
+
+// snapshotPolicyDef takes snapshots of a VM on a cron schedule, when
+// `kvmcli snapshots run-due` runs, and prunes the old ones.
+// Example: snapshot_policy { schedule = "0 2 * * *", keep_daily = 7 }
+type snapshotPolicyDef struct {
+	Schedule   string `hcl:"schedule"`
+	KeepLast   int    `hcl:"keep_last,optional"`
+	KeepDaily  int    `hcl:"keep_daily,optional"`
+	KeepWeekly int    `hcl:"keep_weekly,optional"`
+	Quiesce    bool   `hcl:"quiesce,optional"`
+}
+
+// portForwardDef exposes a guest port on the host.
+// Example: port_forward { host_port = 8080, guest_port = 80 }
+type portForwardDef struct {
+	HostPort    int    `hcl:"host_port"`
+	GuestPort   int    `hcl:"guest_port,optional"`
+	Protocol    string `hcl:"protocol,optional"`
+	HostAddress string `hcl:"host_address,optional"`
+}
+
+// interfaceDef describes an extra network interface of a VM.
+// Example: interface { network = network.backend, ip = "10.0.1.5" }
+type interfaceDef struct {
+	NetExpr    hcl.Expression `hcl:"network,attr"`
+	NetName    string
+	IP         string         `hcl:"ip,optional"`
+	IPv6       string         `hcl:"ipv6,optional"`
+	MAC        string         `hcl:"mac,optional"`
+	Model      string         `hcl:"model,optional"`
+	FilterExpr hcl.Expression `hcl:"filter,optional"`
+	Filter     string
+	FilterArgs map[string]string `hcl:"filter_params,optional"`
+	MTU        int               `hcl:"mtu,optional"`
+	Bandwidth  *bandwidthDef     `hcl:"bandwidth,block"`
+}
+
+// bandwidthDef limits the traffic of a network or an interface.
+// Rates are in kilobytes per second, burst in kilobytes.
+// Example: bandwidth { inbound { average = 1000 } outbound { average = 500 } }
+type bandwidthDef struct {
+	Inbound  *rateDef `hcl:"inbound,block"`
+	Outbound *rateDef `hcl:"outbound,block"`
+}
+
+type rateDef struct {
+	Average int `hcl:"average"`
+	Peak    int `hcl:"peak,optional"`
+	Burst   int `hcl:"burst,optional"`
+}
+
+// diskDef describes an extra disk attached to a VM.
+// Example: disk "data" { size = "20G" }
+type diskDef struct {
+	Name   string `hcl:"name,label"`
+	Size   string `hcl:"size,optional"`
+	Bus    string `hcl:"bus,optional"`
+	Format string `hcl:"format,optional"`
+	Cache  string `hcl:"cache,optional"`
+	Image  string `hcl:"image,optional"`
+	Keep   bool   `hcl:"keep,optional"`
 }
 
 // networkDef describes a network block in HCL.
@@ -56,15 +163,79 @@ type networkDef struct {
 	Name       string            `hcl:"name,label"`
 	Namespace  string            `hcl:"namespace"`
 	CIDR       string            `hcl:"cidr,optional"`
+	Gateway    string            `hcl:"gateway,optional"`
 	NetAddress string            `hcl:"netaddress,optional"`
 	NetMask    string            `hcl:"netmask,optional"`
 	Bridge     string            `hcl:"bridge,optional"`
 	Mode       string            `hcl:"mode,optional"`
+	Dev        string            `hcl:"dev,optional"`
+	NAT        *natDef           `hcl:"nat,block"`
+	Routes     []routeDef        `hcl:"route,block"`
 	DHCP       *dhcpDef          `hcl:"dhcp,block"`
+	IPs        []ipDef           `hcl:"ip,block"`
+	DNS        *dnsDef           `hcl:"dns,block"`
+	MTU        int               `hcl:"mtu,optional"`
+	Bandwidth  *bandwidthDef     `hcl:"bandwidth,block"`
 	Autostart  bool              `hcl:"autostart,optional"`
 	Labels     map[string]string `hcl:"labels,optional"`
 }
 
+// natDef restricts the source ports used by a NAT network.
+type natDef struct {
+	PortStart int `hcl:"port_start"`
+	PortEnd   int `hcl:"port_end"`
+}
+
+// routeDef is a static route of a network.
+// Example: route { cidr = "192.168.222.0/24", gateway = "192.168.100.2" }
+type routeDef struct {
+	CIDR    string `hcl:"cidr"`
+	Gateway string `hcl:"gateway"`
+}
+
+// ipDef is an extra subnet of a network, typically an IPv6 prefix.
+// Example: ip { cidr = "fd00:100::/64" }
+type ipDef struct {
+	CIDR    string   `hcl:"cidr"`
+	Gateway string   `hcl:"gateway,optional"`
+	DHCP    *dhcpDef `hcl:"dhcp,block"`
+}
+
+// dnsDef configures the DNS server of a network. With a domain, every VM
+// on the network is registered as <vm>.<domain>.
+type dnsDef struct {
+	Domain     string       `hcl:"domain,optional"`
+	Forwarders []string     `hcl:"forwarders,optional"`
+	Hosts      []dnsHostDef `hcl:"host,block"`
+	SRVs       []dnsSRVDef  `hcl:"srv,block"`
+	TXTs       []dnsTXTDef  `hcl:"txt,block"`
+}
+
+// dnsHostDef is a static A/AAAA record.
+// Example: host { ip = "192.168.100.10", hostnames = ["nas"] }
+type dnsHostDef struct {
+	IP        string   `hcl:"ip"`
+	Hostnames []string `hcl:"hostnames"`
+}
+
+// dnsSRVDef is a SRV record.
+// Example: srv { service = "ldap", protocol = "tcp", target = "dc01", port = 389 }
+type dnsSRVDef struct {
+	Service  string `hcl:"service"`
+	Protocol string `hcl:"protocol"`
+	Domain   string `hcl:"domain,optional"`
+	Target   string `hcl:"target,optional"`
+	Port     int    `hcl:"port,optional"`
+	Priority int    `hcl:"priority,optional"`
+	Weight   int    `hcl:"weight,optional"`
+}
+
+// dnsTXTDef is a TXT record.
+type dnsTXTDef struct {
+	Name  string `hcl:"name"`
+	Value string `hcl:"value"`
+}
+
 type dhcpDef struct {
 	Start string `hcl:"start"`
 	End   string `hcl:"end"`
@@ -93,6 +264,9 @@ type imageDef struct {
 	File      string `hcl:"file,optional"`
 	Size      string `hcl:"size,optional"`
 	Checksum  string `hcl:"checksum,optional"`
+	// Source is where `kvmcli image pull` downloads the image from:
+	// an http(s):// or file:// URL.
+	Source string `hcl:"source,optional"`
 }
 
 // Load parses an HCL file, resolves all expressions, and returns
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Values accepted in firewall rules.
+var (
+	firewallActions    = []string{"accept", "drop", "reject", "return", "continue"}
+	firewallDirections = []string{"in", "out", "inout"}
+	firewallProtocols  = []string{
+		"all", "tcp", "udp", "sctp", "icmp", "igmp", "esp", "ah", "udplite",
+		"all-ipv6", "tcp-ipv6", "udp-ipv6", "sctp-ipv6", "icmpv6", "esp-ipv6", "ah-ipv6", "udplite-ipv6",
+	}
+	// Protocols that have ports
+	portProtocols = []string{"tcp", "udp", "sctp", "tcp-ipv6", "udp-ipv6", "sctp-ipv6"}
+)
+
+// normalizeFirewall fills in the defaults of a firewall (action accept,
+// protocol all) and validates its rules. Ports are rewritten as "start-end".
+func normalizeFirewall(f *firewallDef) error {
+	if f.DefaultAction != "" && !slices.Contains(firewallActions, f.DefaultAction) {
+		return fmt.Errorf(
+			"firewall %q: unknown default_action %q (supported: %v)",
+			f.Name,
+			f.DefaultAction,
+			firewallActions,
+		)
+	}
+	if f.Priority < -1000 || f.Priority > 1000 {
+		return fmt.Errorf("firewall %q: priority must be between -1000 and 1000", f.Name)
+	}
+
+	for index := range f.Rules {
+		if err := normalizeRule(&f.Rules[index]); err != nil {
+			return fmt.Errorf("firewall %q: rule %d: %w", f.Name, index, err)
+		}
+	}
+	return nil
+}
+
+func normalizeRule(rule *firewallRuleDef) error {
+	if rule.Action == "" {
+		rule.Action = "accept"
+	}
+	if rule.Protocol == "" {
+		rule.Protocol = "all"
+	}
+
+	switch {
+	case !slices.Contains(firewallActions, rule.Action):
+		return fmt.Errorf("unknown action %q (supported: %v)", rule.Action, firewallActions)
+	case !slices.Contains(firewallDirections, rule.Direction):
+		return fmt.Errorf("unknown direction %q (supported: %v)", rule.Direction, firewallDirections)
+	case !slices.Contains(firewallProtocols, rule.Protocol):
+		return fmt.Errorf("unknown protocol %q (supported: %v)", rule.Protocol, firewallProtocols)
+	case rule.Priority < -1000 || rule.Priority > 1000:
+		return fmt.Errorf("priority must be between -1000 and 1000")
+	case len(rule.Ports) > 0 && !slices.Contains(portProtocols, rule.Protocol):
+		return fmt.Errorf("protocol %q has no ports", rule.Protocol)
+	case len(rule.CIDRs) > 0 && rule.Direction == "inout":
+		return fmt.Errorf("cidrs need direction in (source) or out (destination)")
+	}
+
+	for index, port := range rule.Ports {
+		start, end, err := parsePortRange(port)
+		if err != nil {
+			return err
+		}
+		rule.Ports[index] = fmt.Sprintf("%d-%d", start, end)
+	}
+
+	ipv6 := rule.Protocol == "icmpv6" || strings.HasSuffix(rule.Protocol, "-ipv6")
+	for index, cidr := range rule.CIDRs {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid cidr: %w", err)
+		}
+		if (subnet.IP.To4() == nil) != ipv6 {
+			return fmt.Errorf("cidr %s does not match protocol %q", subnet, rule.Protocol)
+		}
+		rule.CIDRs[index] = subnet.String()
+	}
+	return nil
+}
+
+// parsePortRange parses "22" or "8000-8100".
+func parsePortRange(value string) (start, end int, err error) {
+	startStr, endStr, isRange := strings.Cut(value, "-")
+	if !isRange {
+		endStr = startStr
+	}
+	start, errStart := strconv.Atoi(strings.TrimSpace(startStr))
+	end, errEnd := strconv.Atoi(strings.TrimSpace(endStr))
+	if errStart != nil || errEnd != nil || !isPort(start) || !isPort(end) || start > end {
+		return 0, 0, fmt.Errorf("invalid port or port range %q", value)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    firewallRuleDef
+		want    firewallRuleDef
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			rule: firewallRuleDef{Direction: "in"},
+			want: firewallRuleDef{Action: "accept", Direction: "in", Protocol: "all"},
+		},
+		{
+			name: "ports rewritten as ranges",
+			rule: firewallRuleDef{Direction: "in", Protocol: "tcp", Ports: []string{"22", "8000-8100", " 443 "}},
+			want: firewallRuleDef{
+				Action:    "accept",
+				Direction: "in",
+				Protocol:  "tcp",
+				Ports:     []string{"22-22", "8000-8100", "443-443"},
+			},
+		},
+		{
+			name: "cidrs normalised",
+			rule: firewallRuleDef{Action: "drop", Direction: "out", CIDRs: []string{"10.0.0.7/8"}},
+			want: firewallRuleDef{Action: "drop", Direction: "out", Protocol: "all", CIDRs: []string{"10.0.0.0/8"}},
+		},
+		{
+			name: "IPv6 cidr with an IPv6 protocol",
+			rule: firewallRuleDef{Direction: "in", Protocol: "tcp-ipv6", Ports: []string{"22"}, CIDRs: []string{"fd00::1/64"}},
+			want: firewallRuleDef{
+				Action:    "accept",
+				Direction: "in",
+				Protocol:  "tcp-ipv6",
+				Ports:     []string{"22-22"},
+				CIDRs:     []string{"fd00::/64"},
+			},
+		},
+		{
+			name: "priority at the bound",
+			rule: firewallRuleDef{Direction: "inout", Priority: -1000},
+			want: firewallRuleDef{Action: "accept", Direction: "inout", Protocol: "all", Priority: -1000},
+		},
+		{name: "unknown action", rule: firewallRuleDef{Action: "allow", Direction: "in"}, wantErr: true},
+		{name: "missing direction", rule: firewallRuleDef{}, wantErr: true},
+		{name: "unknown direction", rule: firewallRuleDef{Direction: "both"}, wantErr: true},
+		{name: "unknown protocol", rule: firewallRuleDef{Direction: "in", Protocol: "gre"}, wantErr: true},
+		{name: "priority out of range", rule: firewallRuleDef{Direction: "in", Priority: 1001}, wantErr: true},
+		{
+			name:    "ports on a protocol without ports",
+			rule:    firewallRuleDef{Direction: "in", Protocol: "icmp", Ports: []string{"22"}},
+			wantErr: true,
+		},
+		{
+			name:    "ports on all protocols",
+			rule:    firewallRuleDef{Direction: "in", Ports: []string{"22"}},
+			wantErr: true,
+		},
+		{
+			name:    "cidrs on both directions",
+			rule:    firewallRuleDef{Direction: "inout", CIDRs: []string{"10.0.0.0/8"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			rule:    firewallRuleDef{Direction: "in", Protocol: "udp", Ports: []string{"dns"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			rule:    firewallRuleDef{Direction: "in", CIDRs: []string{"10.0.0.0"}},
+			wantErr: true,
+		},
+		{
+			name:    "IPv6 cidr with an IPv4 protocol",
+			rule:    firewallRuleDef{Direction: "in", Protocol: "tcp", CIDRs: []string{"fd00::/64"}},
+			wantErr: true,
+		},
+		{
+			name:    "IPv4 cidr with an IPv6 protocol",
+			rule:    firewallRuleDef{Direction: "in", Protocol: "icmpv6", CIDRs: []string{"10.0.0.0/8"}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := test.rule
+			err := normalizeRule(&rule)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("normalizeRule() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeRule() error = %v", err)
+			}
+			if !reflect.DeepEqual(rule, test.want) {
+				t.Errorf("normalizeRule() = %+v, want %+v", rule, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantStart int
+		wantEnd   int
+		wantErr   bool
+	}{
+		{value: "22", wantStart: 22, wantEnd: 22},
+		{value: "8000-8100", wantStart: 8000, wantEnd: 8100},
+		{value: "1-65535", wantStart: 1, wantEnd: 65535},
+		{value: " 80 - 90 ", wantStart: 80, wantEnd: 90},
+		{value: "", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "65536", wantErr: true},
+		{value: "8100-8000", wantErr: true},
+		{value: "80-", wantErr: true},
+		{value: "-80", wantErr: true},
+		{value: "80-90-100", wantErr: true},
+		{value: "http", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			start, end, err := parsePortRange(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parsePortRange(%q) = %d, %d, want an error", test.value, start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePortRange(%q) error = %v", test.value, err)
+			}
+			if start != test.wantStart || end != test.wantEnd {
+				t.Errorf(
+					"parsePortRange(%q) = %d, %d, want %d, %d",
+					test.value, start, end, test.wantStart, test.wantEnd,
+				)
+			}
+		})
+	}
+}
@@ -154,7 +154,8 @@ func LoadDefaultConfig() (*GlobalConfig, error) {
 			return nil, fmt.Errorf("read config %q: %w", p, err)
 		}
 
-		var cfg GlobalConfig
+		// Start from the defaults so missing sections keep sensible values
+		cfg := DefaultGlobalConfig()
 		if err := toml.Unmarshal(content, &cfg); err != nil {
 			return nil, fmt.Errorf("parse config %q: %w", p, err)
 		}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Checksum algorithms accepted in image checksums, with their digest size.
+var checksumSizes = map[string]int{
+	"sha256": 32,
+	"sha512": 64,
+}
+
+// normalizeImages checks the images of a store: unique names, a
+// checksum of the form <algorithm>:<hex digest> and an http(s) or file
+// source. An image with a source and no file is stored as <name>.qcow2.
+func normalizeImages(store *storeDef) error {
+	if _, err := collectNames(
+		fmt.Sprintf("store %q: image", store.Name),
+		store.Images,
+		func(i imageDef) string { return i.Name },
+	); err != nil {
+		return err
+	}
+
+	for index := range store.Images {
+		image := &store.Images[index]
+		if image.Checksum != "" {
+			if err := validateChecksum(image.Checksum); err != nil {
+				return fmt.Errorf("store %q: image %q: %w", store.Name, image.Name, err)
+			}
+		}
+		if image.Source == "" {
+			continue
+		}
+		source, err := url.Parse(image.Source)
+		if err != nil {
+			return fmt.Errorf("store %q: image %q: invalid source: %w", store.Name, image.Name, err)
+		}
+		switch source.Scheme {
+		case "http", "https", "file":
+		default:
+			return fmt.Errorf(
+				"store %q: image %q: source must be an http(s):// or file:// URL, got %q",
+				store.Name,
+				image.Name,
+				image.Source,
+			)
+		}
+		if image.File == "" {
+			image.File = image.Name + ".qcow2"
+		}
+	}
+	return nil
+}
+
+// validateChecksum checks a checksum of the form sha256:<hex digest>.
+func validateChecksum(checksum string) error {
+	algorithm, digest, ok := strings.Cut(checksum, ":")
+	size, known := checksumSizes[algorithm]
+	if !ok || !known {
+		return fmt.Errorf("checksum %q: expected sha256:<hex> or sha512:<hex>", checksum)
+	}
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != size {
+		return fmt.Errorf("checksum %q: invalid %s digest", checksum, algorithm)
+	}
+	return nil
+}
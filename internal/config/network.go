@@ -0,0 +1,359 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Forward modes accepted in network blocks.
+var forwardModes = []string{"nat", "route", "isolated", "open", "bridge", "macvtap"}
+
+// normalizeNetwork derives the gateway address and netmask of a network
+// from its cidr, and validates that the DHCP range fits inside it.
+// The gateway defaults to the first host of the subnet.
+// Networks without cidr keep their netaddress/netmask as written.
+// Extra ip blocks (IPv6 prefixes, secondary subnets) are normalised the same way.
+func normalizeNetwork(n *networkDef) error {
+	if err := normalizePrimary(n); err != nil {
+		return err
+	}
+
+	// libvirt serves DHCP on at most one <ip> per family
+	dhcpFamilies := map[int]bool{}
+	if n.DHCP != nil {
+		dhcpFamilies[net.IPv4len] = true
+	}
+	for index := range n.IPs {
+		block := &n.IPs[index]
+		subnet, gateway, err := normalizeSubnet(block.CIDR, block.Gateway, block.DHCP)
+		if err != nil {
+			return fmt.Errorf("network %q: ip %d: %w", n.Name, index, err)
+		}
+		if block.DHCP != nil {
+			family := len(subnet.IP)
+			if dhcpFamilies[family] {
+				return fmt.Errorf(
+					"network %q: ip %d: only one subnet per family can have dhcp",
+					n.Name,
+					index,
+				)
+			}
+			dhcpFamilies[family] = true
+		}
+		block.CIDR = subnet.String()
+		block.Gateway = gateway.String()
+	}
+	return nil
+}
+
+// normalizePrimary normalises the top-level cidr/gateway/dhcp of a network,
+// which describe its primary IPv4 subnet.
+func normalizePrimary(n *networkDef) error {
+	if n.CIDR == "" {
+		if n.Gateway != "" {
+			return fmt.Errorf("network %q: gateway requires cidr", n.Name)
+		}
+		return nil
+	}
+
+	// netaddress is the legacy name for the gateway
+	gatewayStr := n.Gateway
+	if gatewayStr == "" {
+		gatewayStr = n.NetAddress
+	}
+	subnet, gateway, err := normalizeSubnet(n.CIDR, gatewayStr, n.DHCP)
+	if err != nil {
+		return fmt.Errorf("network %q: %w", n.Name, err)
+	}
+	if subnet.IP.To4() == nil {
+		return fmt.Errorf("network %q: cidr %q is not IPv4, use an ip block for IPv6", n.Name, n.CIDR)
+	}
+
+	netmask := net.IP(subnet.Mask).String()
+	if n.NetMask != "" && n.NetMask != netmask {
+		return fmt.Errorf(
+			"network %q: netmask %q does not match cidr %s",
+			n.Name,
+			n.NetMask,
+			subnet,
+		)
+	}
+
+	n.CIDR = subnet.String()
+	n.Gateway = gateway.String()
+	n.NetAddress = gateway.String()
+	n.NetMask = netmask
+	return nil
+}
+
+// normalizeSubnet parses an IPv4 or IPv6 cidr, picks its gateway (the first
+// host unless gatewayStr is set) and validates the DHCP range against it.
+// The DHCP range is rewritten in canonical form.
+func normalizeSubnet(cidr, gatewayStr string, dhcp *dhcpDef) (*net.IPNet, net.IP, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cidr: %w", err)
+	}
+	if ipv4 := subnet.IP.To4(); ipv4 != nil {
+		subnet.IP = ipv4
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return nil, nil, fmt.Errorf("cidr %q has no room for hosts", cidr)
+	}
+
+	gateway := firstHost(subnet)
+	if gatewayStr != "" {
+		gateway = parseFamily(gatewayStr, subnet)
+		if gateway == nil || !isHost(subnet, gateway) {
+			return nil, nil, fmt.Errorf(
+				"gateway %q is not a host address of %s",
+				gatewayStr,
+				subnet,
+			)
+		}
+	}
+
+	if dhcp != nil {
+		start := parseFamily(dhcp.Start, subnet)
+		end := parseFamily(dhcp.End, subnet)
+		if start == nil || !isHost(subnet, start) {
+			return nil, nil, fmt.Errorf("dhcp start %q is outside %s", dhcp.Start, subnet)
+		}
+		if end == nil || !isHost(subnet, end) {
+			return nil, nil, fmt.Errorf("dhcp end %q is outside %s", dhcp.End, subnet)
+		}
+		if bytes.Compare(start, end) > 0 {
+			return nil, nil, fmt.Errorf("dhcp start %s is after end %s", start, end)
+		}
+		if bytes.Compare(start, gateway) <= 0 && bytes.Compare(gateway, end) <= 0 {
+			return nil, nil, fmt.Errorf("dhcp range contains the gateway %s", gateway)
+		}
+		dhcp.Start, dhcp.End = start.String(), end.String()
+	}
+	return subnet, gateway, nil
+}
+
+// parseFamily parses ip with the same length as the subnet address
+// (4 bytes for IPv4, 16 for IPv6), or returns nil.
+func parseFamily(ipStr string, subnet *net.IPNet) net.IP {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil
+	}
+	if len(subnet.IP) == net.IPv4len {
+		return ip.To4()
+	}
+	if ip.To4() != nil {
+		return nil
+	}
+	return ip
+}
+
+// firstHost returns the first usable address of a subnet.
+func firstHost(subnet *net.IPNet) net.IP {
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+	ip[len(ip)-1]++
+	return ip
+}
+
+// isHost reports whether ip is inside subnet and is not its network
+// address, nor its broadcast address for IPv4.
+func isHost(subnet *net.IPNet, ip net.IP) bool {
+	if !subnet.Contains(ip) || ip.Equal(subnet.IP) {
+		return false
+	}
+	ipv4 := subnet.IP.To4()
+	if ipv4 == nil {
+		return true
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = ipv4[i] | ^subnet.Mask[i]
+	}
+	return !ip.Equal(broadcast)
+}
+
+// validateForward checks that a network sets the attributes its forward
+// mode needs, and nothing that mode cannot use. An empty mode means nat.
+//
+//   - nat, route, isolated, open: libvirt manages an IP subnet (cidr)
+//   - bridge: attaches to an existing host bridge (bridge), no subnet
+//   - macvtap: attaches directly to a host device (dev), no subnet
+func validateForward(n *networkDef) error {
+	if n.Mode == "" {
+		n.Mode = "nat"
+	}
+	if !slices.Contains(forwardModes, n.Mode) {
+		return fmt.Errorf(
+			"network %q: unknown mode %q (supported: %v)",
+			n.Name,
+			n.Mode,
+			forwardModes,
+		)
+	}
+
+	hasSubnet := n.CIDR != "" || n.NetAddress != "" || len(n.IPs) > 0
+	switch n.Mode {
+	case "nat", "route", "isolated", "open":
+		if !hasSubnet {
+			return fmt.Errorf("network %q: mode %q requires cidr", n.Name, n.Mode)
+		}
+	case "bridge", "macvtap":
+		if hasSubnet || n.DHCP != nil || len(n.Routes) > 0 {
+			return fmt.Errorf(
+				"network %q: mode %q does not take cidr, dhcp or routes, addressing is up to the host network",
+				n.Name,
+				n.Mode,
+			)
+		}
+	}
+
+	switch n.Mode {
+	case "bridge":
+		if n.Bridge == "" {
+			return fmt.Errorf("network %q: mode bridge requires the host bridge name (bridge)", n.Name)
+		}
+		if n.Dev != "" {
+			return fmt.Errorf("network %q: mode bridge does not take dev", n.Name)
+		}
+	case "macvtap":
+		if n.Dev == "" {
+			return fmt.Errorf("network %q: mode macvtap requires the host device (dev)", n.Name)
+		}
+		if n.Bridge != "" {
+			return fmt.Errorf("network %q: mode macvtap does not take bridge", n.Name)
+		}
+	case "isolated", "open":
+		if n.Dev != "" {
+			return fmt.Errorf("network %q: mode %q does not take dev", n.Name, n.Mode)
+		}
+	}
+
+	if n.NAT != nil {
+		if n.Mode != "nat" {
+			return fmt.Errorf("network %q: nat block requires mode nat", n.Name)
+		}
+		if n.NAT.PortStart < 1 || n.NAT.PortEnd > 65535 || n.NAT.PortStart > n.NAT.PortEnd {
+			return fmt.Errorf(
+				"network %q: invalid nat port range %d-%d",
+				n.Name,
+				n.NAT.PortStart,
+				n.NAT.PortEnd,
+			)
+		}
+	}
+
+	for index := range n.Routes {
+		route := &n.Routes[index]
+		_, dest, err := net.ParseCIDR(route.CIDR)
+		if err != nil {
+			return fmt.Errorf("network %q: route %d: invalid cidr: %w", n.Name, index, err)
+		}
+		if net.ParseIP(route.Gateway) == nil {
+			return fmt.Errorf("network %q: route %d: invalid gateway %q", n.Name, index, route.Gateway)
+		}
+		if n.CIDR != "" && route.Gateway != "" && net.ParseIP(route.Gateway).To4() != nil {
+			_, subnet, _ := net.ParseCIDR(n.CIDR)
+			if !subnet.Contains(net.ParseIP(route.Gateway)) {
+				return fmt.Errorf(
+					"network %q: route %d: gateway %s is outside %s",
+					n.Name,
+					index,
+					route.Gateway,
+					subnet,
+				)
+			}
+		}
+		route.CIDR = dest.String()
+	}
+	return nil
+}
+
+// validateDNS checks the dns block of a network. DNS is served by the
+// libvirt dnsmasq, so bridge and macvtap networks cannot have one.
+func validateDNS(n *networkDef) error {
+	if n.DNS == nil {
+		return nil
+	}
+	if n.Mode == "bridge" || n.Mode == "macvtap" {
+		return fmt.Errorf("network %q: mode %q does not take a dns block", n.Name, n.Mode)
+	}
+
+	dns := n.DNS
+	if dns.Domain != "" && !isDomainName(dns.Domain) {
+		return fmt.Errorf("network %q: dns: invalid domain %q", n.Name, dns.Domain)
+	}
+	for _, forwarder := range dns.Forwarders {
+		if net.ParseIP(forwarder) == nil {
+			return fmt.Errorf("network %q: dns: invalid forwarder %q", n.Name, forwarder)
+		}
+	}
+	for index, host := range dns.Hosts {
+		if net.ParseIP(host.IP) == nil {
+			return fmt.Errorf("network %q: dns host %d: invalid ip %q", n.Name, index, host.IP)
+		}
+		if len(host.Hostnames) == 0 {
+			return fmt.Errorf("network %q: dns host %d: hostnames is empty", n.Name, index)
+		}
+		for _, hostname := range host.Hostnames {
+			if !isDomainName(hostname) {
+				return fmt.Errorf("network %q: dns host %d: invalid hostname %q", n.Name, index, hostname)
+			}
+		}
+	}
+	for index, srv := range dns.SRVs {
+		if srv.Protocol != "tcp" && srv.Protocol != "udp" {
+			return fmt.Errorf(
+				"network %q: dns srv %d: protocol must be tcp or udp, got %q",
+				n.Name,
+				index,
+				srv.Protocol,
+			)
+		}
+		if srv.Port < 0 || srv.Port > 65535 {
+			return fmt.Errorf("network %q: dns srv %d: invalid port %d", n.Name, index, srv.Port)
+		}
+	}
+	for index, txt := range dns.TXTs {
+		if !isRecordName(txt.Name) {
+			return fmt.Errorf("network %q: dns txt %d: invalid name %q", n.Name, index, txt.Name)
+		}
+	}
+	return nil
+}
+
+// isDomainName reports whether name is a valid DNS name: dot separated
+// labels of letters, digits and hyphens, not starting or ending with a hyphen.
+func isDomainName(name string) bool {
+	return validDNSName(name, false)
+}
+
+// isRecordName reports whether name is a valid name for a TXT record. It
+// is a DNS name whose labels may also hold underscores, as in
+// _acme-challenge or _dmarc.
+func isRecordName(name string) bool {
+	return validDNSName(name, true)
+}
+
+func validDNSName(name string, underscores bool) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			isAlnum := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+			if !isAlnum && r != '-' && !(underscores && r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
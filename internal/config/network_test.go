@@ -0,0 +1,307 @@
+package config
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSubnet(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		gateway string
+		dhcp    *dhcpDef
+		// wantSubnet and wantGateway are only checked without wantErr
+		wantSubnet  string
+		wantGateway string
+		wantDHCP    *dhcpDef
+		wantErr     bool
+	}{
+		{
+			name:        "gateway defaults to the first host",
+			cidr:        "192.168.10.0/24",
+			wantSubnet:  "192.168.10.0/24",
+			wantGateway: "192.168.10.1",
+		},
+		{
+			name:        "host bits cleared",
+			cidr:        "192.168.10.77/24",
+			wantSubnet:  "192.168.10.0/24",
+			wantGateway: "192.168.10.1",
+		},
+		{
+			name:        "explicit gateway",
+			cidr:        "192.168.10.0/24",
+			gateway:     "192.168.10.254",
+			wantSubnet:  "192.168.10.0/24",
+			wantGateway: "192.168.10.254",
+		},
+		{
+			name:        "IPv6",
+			cidr:        "fd00:10::/64",
+			wantSubnet:  "fd00:10::/64",
+			wantGateway: "fd00:10::1",
+		},
+		{
+			name:        "dhcp range in canonical form",
+			cidr:        "fd00:10::/64",
+			dhcp:        &dhcpDef{Start: "fd00:10:0:0::100", End: "fd00:10::1ff"},
+			wantSubnet:  "fd00:10::/64",
+			wantGateway: "fd00:10::1",
+			wantDHCP:    &dhcpDef{Start: "fd00:10::100", End: "fd00:10::1ff"},
+		},
+		{
+			name:        "smallest subnet with hosts",
+			cidr:        "10.0.0.0/30",
+			wantSubnet:  "10.0.0.0/30",
+			wantGateway: "10.0.0.1",
+		},
+		{name: "invalid cidr", cidr: "192.168.10.0", wantErr: true},
+		{name: "no room for hosts", cidr: "10.0.0.0/31", wantErr: true},
+		{name: "gateway outside", cidr: "192.168.10.0/24", gateway: "192.168.11.1", wantErr: true},
+		{name: "gateway is the network address", cidr: "192.168.10.0/24", gateway: "192.168.10.0", wantErr: true},
+		{name: "gateway is the broadcast address", cidr: "192.168.10.0/24", gateway: "192.168.10.255", wantErr: true},
+		{name: "gateway of the other family", cidr: "fd00:10::/64", gateway: "192.168.10.1", wantErr: true},
+		{
+			name:    "dhcp start outside",
+			cidr:    "192.168.10.0/24",
+			dhcp:    &dhcpDef{Start: "192.168.9.10", End: "192.168.10.20"},
+			wantErr: true,
+		},
+		{
+			name:    "dhcp end is the broadcast address",
+			cidr:    "192.168.10.0/24",
+			dhcp:    &dhcpDef{Start: "192.168.10.10", End: "192.168.10.255"},
+			wantErr: true,
+		},
+		{
+			name:    "dhcp start after end",
+			cidr:    "192.168.10.0/24",
+			dhcp:    &dhcpDef{Start: "192.168.10.20", End: "192.168.10.10"},
+			wantErr: true,
+		},
+		{
+			name:    "dhcp range contains the gateway",
+			cidr:    "192.168.10.0/24",
+			dhcp:    &dhcpDef{Start: "192.168.10.1", End: "192.168.10.10"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subnet, gateway, err := normalizeSubnet(test.cidr, test.gateway, test.dhcp)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("normalizeSubnet(%q, %q) succeeded, want an error", test.cidr, test.gateway)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeSubnet(%q, %q) error = %v", test.cidr, test.gateway, err)
+			}
+			if subnet.String() != test.wantSubnet || gateway.String() != test.wantGateway {
+				t.Errorf(
+					"normalizeSubnet(%q, %q) = %s, %s, want %s, %s",
+					test.cidr, test.gateway, subnet, gateway, test.wantSubnet, test.wantGateway,
+				)
+			}
+			if test.wantDHCP != nil && *test.dhcp != *test.wantDHCP {
+				t.Errorf("dhcp = %+v, want %+v", *test.dhcp, *test.wantDHCP)
+			}
+		})
+	}
+}
+
+func TestIsHost(t *testing.T) {
+	tests := []struct {
+		cidr string
+		ip   string
+		want bool
+	}{
+		{"192.168.10.0/24", "192.168.10.1", true},
+		{"192.168.10.0/24", "192.168.10.254", true},
+		{"192.168.10.0/24", "192.168.10.0", false},
+		{"192.168.10.0/24", "192.168.10.255", false},
+		{"192.168.10.0/24", "192.168.11.1", false},
+		{"10.0.0.0/30", "10.0.0.2", true},
+		{"10.0.0.0/30", "10.0.0.3", false},
+		// IPv6 has no broadcast address
+		{"fd00::/126", "fd00::3", true},
+		{"fd00::/126", "fd00::", false},
+	}
+	for _, test := range tests {
+		t.Run(test.cidr+" "+test.ip, func(t *testing.T) {
+			_, subnet, err := net.ParseCIDR(test.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ip := parseFamily(test.ip, subnet)
+			if ipv4 := subnet.IP.To4(); ipv4 != nil {
+				subnet.IP = ipv4
+			}
+			if got := isHost(subnet, ip); got != test.want {
+				t.Errorf("isHost(%s, %s) = %t, want %t", subnet, test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFirstHost(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"192.168.10.0/24", "192.168.10.1"},
+		{"10.0.0.64/26", "10.0.0.65"},
+		{"fd00:10::/64", "fd00:10::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.cidr, func(t *testing.T) {
+			_, subnet, err := net.ParseCIDR(test.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := firstHost(subnet).String(); got != test.want {
+				t.Errorf("firstHost(%s) = %s, want %s", subnet, got, test.want)
+			}
+			// The subnet is left untouched
+			if subnet.String() != test.cidr {
+				t.Errorf("firstHost modified the subnet to %s", subnet)
+			}
+		})
+	}
+}
+
+func TestValidateForward(t *testing.T) {
+	const cidr = "192.168.10.0/24"
+	tests := []struct {
+		name     string
+		network  networkDef
+		wantMode string
+		wantErr  bool
+	}{
+		{name: "mode defaults to nat", network: networkDef{CIDR: cidr}, wantMode: "nat"},
+		{name: "route", network: networkDef{Mode: "route", CIDR: cidr, Dev: "eth0"}, wantMode: "route"},
+		{name: "isolated", network: networkDef{Mode: "isolated", CIDR: cidr}, wantMode: "isolated"},
+		{name: "open", network: networkDef{Mode: "open", CIDR: cidr}, wantMode: "open"},
+		{
+			name:     "ipv6 only",
+			network:  networkDef{Mode: "isolated", IPs: []ipDef{{CIDR: "fd00::/64"}}},
+			wantMode: "isolated",
+		},
+		{name: "bridge", network: networkDef{Mode: "bridge", Bridge: "br0"}, wantMode: "bridge"},
+		{name: "macvtap", network: networkDef{Mode: "macvtap", Dev: "eth0"}, wantMode: "macvtap"},
+		{
+			name:     "nat port range",
+			network:  networkDef{CIDR: cidr, NAT: &natDef{PortStart: 1024, PortEnd: 65535}},
+			wantMode: "nat",
+		},
+		{
+			name: "route inside the subnet",
+			network: networkDef{
+				CIDR:   cidr,
+				Routes: []routeDef{{CIDR: "10.1.0.0/16", Gateway: "192.168.10.2"}},
+			},
+			wantMode: "nat",
+		},
+		{name: "unknown mode", network: networkDef{Mode: "vepa", CIDR: cidr}, wantErr: true},
+		{name: "nat without subnet", network: networkDef{}, wantErr: true},
+		{name: "bridge without bridge", network: networkDef{Mode: "bridge"}, wantErr: true},
+		{name: "bridge with cidr", network: networkDef{Mode: "bridge", Bridge: "br0", CIDR: cidr}, wantErr: true},
+		{
+			name:    "bridge with dhcp",
+			network: networkDef{Mode: "bridge", Bridge: "br0", DHCP: &dhcpDef{}},
+			wantErr: true,
+		},
+		{name: "bridge with dev", network: networkDef{Mode: "bridge", Bridge: "br0", Dev: "eth0"}, wantErr: true},
+		{name: "macvtap without dev", network: networkDef{Mode: "macvtap"}, wantErr: true},
+		{name: "macvtap with bridge", network: networkDef{Mode: "macvtap", Dev: "eth0", Bridge: "br0"}, wantErr: true},
+		{name: "isolated with dev", network: networkDef{Mode: "isolated", CIDR: cidr, Dev: "eth0"}, wantErr: true},
+		{
+			name:    "nat block without nat",
+			network: networkDef{Mode: "route", CIDR: cidr, NAT: &natDef{PortStart: 1024, PortEnd: 2048}},
+			wantErr: true,
+		},
+		{
+			name:    "reversed nat port range",
+			network: networkDef{CIDR: cidr, NAT: &natDef{PortStart: 2048, PortEnd: 1024}},
+			wantErr: true,
+		},
+		{
+			name:    "nat port out of range",
+			network: networkDef{CIDR: cidr, NAT: &natDef{PortStart: 0, PortEnd: 1024}},
+			wantErr: true,
+		},
+		{
+			name:    "route with invalid cidr",
+			network: networkDef{CIDR: cidr, Routes: []routeDef{{CIDR: "10.1.0.0", Gateway: "192.168.10.2"}}},
+			wantErr: true,
+		},
+		{
+			name:    "route gateway outside the subnet",
+			network: networkDef{CIDR: cidr, Routes: []routeDef{{CIDR: "10.1.0.0/16", Gateway: "192.168.11.2"}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := test.network
+			network.Name = "lab"
+			err := validateForward(&network)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("validateForward() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateForward() error = %v", err)
+			}
+			if network.Mode != test.wantMode {
+				t.Errorf("mode = %q, want %q", network.Mode, test.wantMode)
+			}
+		})
+	}
+}
+
+func TestValidDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		// wantDomain is the result for a domain or host name,
+		// wantRecord the one for a TXT record name
+		wantDomain bool
+		wantRecord bool
+	}{
+		{"lab", true, true},
+		{"lab.example.com", true, true},
+		{"web-01.lab", true, true},
+		{"123.lab", true, true},
+		{"WEB.Lab", true, true},
+		{"_acme-challenge", false, true},
+		{"_dmarc.lab", false, true},
+		{"", false, false},
+		{"lab.", false, false},
+		{".lab", false, false},
+		{"lab..example", false, false},
+		{"-web.lab", false, false},
+		{"web-.lab", false, false},
+		{"web_01.lab", false, true},
+		{"web 01", false, false},
+		{"wéb.lab", false, false},
+		{strings.Repeat("a", 63) + ".lab", true, true},
+		{strings.Repeat("a", 64) + ".lab", false, false},
+		{strings.Repeat("a.", 126) + "a", true, true},
+		{strings.Repeat("a.", 126) + "ab", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isDomainName(test.name); got != test.wantDomain {
+				t.Errorf("isDomainName(%q) = %t, want %t", test.name, got, test.wantDomain)
+			}
+			if got := isRecordName(test.name); got != test.wantRecord {
+				t.Errorf("isRecordName(%q) = %t, want %t", test.name, got, test.wantRecord)
+			}
+		})
+	}
+}
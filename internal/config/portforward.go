@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// validatePortForwards fills in the defaults of port_forward blocks
+// (guest_port = host_port, protocol = tcp) and checks that no two
+// forwards in the file listen on the same host address, port and protocol.
+func validatePortForwards(vms []vmDef) error {
+	// protocol/port -> host address -> vm
+	listeners := make(map[string]map[string]string)
+	for vmIndex := range vms {
+		vm := &vms[vmIndex]
+		for index := range vm.Forwards {
+			forward := &vm.Forwards[index]
+			if forward.GuestPort == 0 {
+				forward.GuestPort = forward.HostPort
+			}
+			if forward.Protocol == "" {
+				forward.Protocol = "tcp"
+			}
+
+			switch {
+			case forward.Protocol != "tcp" && forward.Protocol != "udp":
+				return fmt.Errorf(
+					"vm %q: port_forward %d: protocol must be tcp or udp, got %q",
+					vm.Name,
+					index,
+					forward.Protocol,
+				)
+			case !isPort(forward.HostPort):
+				return fmt.Errorf("vm %q: port_forward %d: invalid host_port %d", vm.Name, index, forward.HostPort)
+			case !isPort(forward.GuestPort):
+				return fmt.Errorf("vm %q: port_forward %d: invalid guest_port %d", vm.Name, index, forward.GuestPort)
+			case forward.HostAddress != "" && net.ParseIP(forward.HostAddress).To4() == nil:
+				return fmt.Errorf(
+					"vm %q: port_forward %d: host_address %q is not an IPv4 address",
+					vm.Name,
+					index,
+					forward.HostAddress,
+				)
+			}
+
+			// A forward without host_address listens on every host address
+			key := fmt.Sprintf("%s/%d", forward.Protocol, forward.HostPort)
+			if listeners[key] == nil {
+				listeners[key] = make(map[string]string)
+			}
+			for address, owner := range listeners[key] {
+				if address == "" || forward.HostAddress == "" || address == forward.HostAddress {
+					return fmt.Errorf(
+						"vm %q: port_forward %d: %s port %d is already forwarded to vm %q",
+						vm.Name,
+						index,
+						forward.Protocol,
+						forward.HostPort,
+						owner,
+					)
+				}
+			}
+			listeners[key][forward.HostAddress] = vm.Name
+		}
+	}
+	return nil
+}
+
+func isPort(port int) bool { return port >= 1 && port <= 65535 }
@@ -0,0 +1,101 @@
+package config
+
+import "testing"
+
+func TestValidatePortForwards(t *testing.T) {
+	vm := func(name string, forwards ...portForwardDef) vmDef {
+		return vmDef{Name: name, Forwards: forwards}
+	}
+
+	tests := []struct {
+		name    string
+		vms     []vmDef
+		wantErr bool
+	}{
+		{
+			name: "distinct ports",
+			vms: []vmDef{
+				vm("web", portForwardDef{HostPort: 8080, GuestPort: 80}),
+				vm("db", portForwardDef{HostPort: 5432}),
+			},
+		},
+		{
+			name: "same port, other protocol",
+			vms: []vmDef{
+				vm("dns1", portForwardDef{HostPort: 53, Protocol: "udp"}),
+				vm("dns2", portForwardDef{HostPort: 53}),
+			},
+		},
+		{
+			name: "same port, other host addresses",
+			vms: []vmDef{
+				vm("web1", portForwardDef{HostPort: 80, HostAddress: "192.168.1.10"}),
+				vm("web2", portForwardDef{HostPort: 80, HostAddress: "192.168.1.11"}),
+			},
+		},
+		{
+			name: "same port and protocol",
+			vms: []vmDef{
+				vm("web1", portForwardDef{HostPort: 80}),
+				vm("web2", portForwardDef{HostPort: 80, Protocol: "tcp"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "same port twice in a vm",
+			vms: []vmDef{
+				vm("web", portForwardDef{HostPort: 80}, portForwardDef{HostPort: 80, GuestPort: 8080}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "every address and one address",
+			vms: []vmDef{
+				vm("web1", portForwardDef{HostPort: 80, HostAddress: "192.168.1.10"}),
+				vm("web2", portForwardDef{HostPort: 80}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "same host address",
+			vms: []vmDef{
+				vm("web1", portForwardDef{HostPort: 80, HostAddress: "192.168.1.10"}),
+				vm("web2", portForwardDef{HostPort: 80, HostAddress: "192.168.1.10"}),
+			},
+			wantErr: true,
+		},
+		{name: "unknown protocol", vms: []vmDef{vm("web", portForwardDef{HostPort: 80, Protocol: "sctp"})}, wantErr: true},
+		{name: "host port zero", vms: []vmDef{vm("web", portForwardDef{GuestPort: 80})}, wantErr: true},
+		{name: "host port too high", vms: []vmDef{vm("web", portForwardDef{HostPort: 65536})}, wantErr: true},
+		{name: "guest port too high", vms: []vmDef{vm("web", portForwardDef{HostPort: 80, GuestPort: 70000})}, wantErr: true},
+		{
+			name:    "IPv6 host address",
+			vms:     []vmDef{vm("web", portForwardDef{HostPort: 80, HostAddress: "fd00::1"})},
+			wantErr: true,
+		},
+		{
+			name:    "invalid host address",
+			vms:     []vmDef{vm("web", portForwardDef{HostPort: 80, HostAddress: "localhost"})},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePortForwards(test.vms)
+			if (err != nil) != test.wantErr {
+				t.Errorf("validatePortForwards() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePortForwardsDefaults(t *testing.T) {
+	vms := []vmDef{{Name: "web", Forwards: []portForwardDef{{HostPort: 8080}}}}
+	if err := validatePortForwards(vms); err != nil {
+		t.Fatal(err)
+	}
+	want := portForwardDef{HostPort: 8080, GuestPort: 8080, Protocol: "tcp"}
+	if got := vms[0].Forwards[0]; got != want {
+		t.Errorf("port_forward = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import "fmt"
+
+// validateBandwidth checks the rates of a bandwidth block: libvirt needs an
+// average, and a peak below the average would never be reached.
+func validateBandwidth(bandwidth *bandwidthDef) error {
+	if bandwidth == nil {
+		return nil
+	}
+	if bandwidth.Inbound == nil && bandwidth.Outbound == nil {
+		return fmt.Errorf("bandwidth: set inbound and/or outbound")
+	}
+	for direction, rate := range map[string]*rateDef{
+		"inbound":  bandwidth.Inbound,
+		"outbound": bandwidth.Outbound,
+	} {
+		if rate == nil {
+			continue
+		}
+		if rate.Average < 1 {
+			return fmt.Errorf("bandwidth %s: average must be positive", direction)
+		}
+		if rate.Peak < 0 || rate.Burst < 0 {
+			return fmt.Errorf("bandwidth %s: peak and burst cannot be negative", direction)
+		}
+		if rate.Peak != 0 && rate.Peak < rate.Average {
+			return fmt.Errorf(
+				"bandwidth %s: peak %d is below average %d",
+				direction,
+				rate.Peak,
+				rate.Average,
+			)
+		}
+	}
+	return nil
+}
+
+// validateMTU checks an mtu attribute; 0 means unset.
+func validateMTU(mtu int) error {
+	if mtu != 0 && (mtu < 68 || mtu > 65535) {
+		return fmt.Errorf("mtu %d is out of range (68-65535)", mtu)
+	}
+	return nil
+}
+
+// validateNetworkQoS checks the bandwidth and mtu of a network. The mtu is
+// set on the bridge libvirt creates, so bridge and macvtap networks, which
+// use a host device, cannot set it.
+func validateNetworkQoS(n *networkDef) error {
+	if err := validateBandwidth(n.Bandwidth); err != nil {
+		return fmt.Errorf("network %q: %w", n.Name, err)
+	}
+	if err := validateMTU(n.MTU); err != nil {
+		return fmt.Errorf("network %q: %w", n.Name, err)
+	}
+	if n.MTU != 0 && (n.Mode == "bridge" || n.Mode == "macvtap") {
+		return fmt.Errorf("network %q: mode %q does not take mtu", n.Name, n.Mode)
+	}
+	return nil
+}
+
+// validateVMQoS checks the bandwidth and mtu of the interfaces of a VM.
+func validateVMQoS(vm *vmDef) error {
+	if err := validateBandwidth(vm.Bandwidth); err != nil {
+		return fmt.Errorf("vm %q: %w", vm.Name, err)
+	}
+	if err := validateMTU(vm.MTU); err != nil {
+		return fmt.Errorf("vm %q: %w", vm.Name, err)
+	}
+	if vm.NetName == "" && (vm.MTU != 0 || vm.Bandwidth != nil) {
+		return fmt.Errorf("vm %q: mtu and bandwidth need a network, or go in an interface block", vm.Name)
+	}
+	for index, iface := range vm.Interfaces {
+		if err := validateBandwidth(iface.Bandwidth); err != nil {
+			return fmt.Errorf("vm %q: interface %d: %w", vm.Name, index, err)
+		}
+		if err := validateMTU(iface.MTU); err != nil {
+			return fmt.Errorf("vm %q: interface %d: %w", vm.Name, index, err)
+		}
+	}
+	return nil
+}
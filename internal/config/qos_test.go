@@ -0,0 +1,60 @@
+package config
+
+import "testing"
+
+func TestValidateBandwidth(t *testing.T) {
+	tests := []struct {
+		name      string
+		bandwidth *bandwidthDef
+		wantErr   bool
+	}{
+		{name: "unset", bandwidth: nil},
+		{name: "inbound only", bandwidth: &bandwidthDef{Inbound: &rateDef{Average: 1000}}},
+		{name: "outbound only", bandwidth: &bandwidthDef{Outbound: &rateDef{Average: 1000}}},
+		{
+			name: "peak and burst",
+			bandwidth: &bandwidthDef{
+				Inbound:  &rateDef{Average: 1000, Peak: 2000, Burst: 512},
+				Outbound: &rateDef{Average: 500, Peak: 500},
+			},
+		},
+		{name: "empty block", bandwidth: &bandwidthDef{}, wantErr: true},
+		{name: "no average", bandwidth: &bandwidthDef{Inbound: &rateDef{Peak: 1000}}, wantErr: true},
+		{name: "negative average", bandwidth: &bandwidthDef{Outbound: &rateDef{Average: -1}}, wantErr: true},
+		{name: "negative burst", bandwidth: &bandwidthDef{Inbound: &rateDef{Average: 1000, Burst: -1}}, wantErr: true},
+		{
+			name:      "peak below average",
+			bandwidth: &bandwidthDef{Outbound: &rateDef{Average: 1000, Peak: 500}},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateBandwidth(test.bandwidth)
+			if (err != nil) != test.wantErr {
+				t.Errorf("validateBandwidth() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMTU(t *testing.T) {
+	tests := []struct {
+		mtu     int
+		wantErr bool
+	}{
+		{0, false},
+		{68, false},
+		{1500, false},
+		{9000, false},
+		{65535, false},
+		{67, true},
+		{65536, true},
+		{-1, true},
+	}
+	for _, test := range tests {
+		if err := validateMTU(test.mtu); (err != nil) != test.wantErr {
+			t.Errorf("validateMTU(%d) error = %v, want error %v", test.mtu, err, test.wantErr)
+		}
+	}
+}
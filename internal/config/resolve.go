@@ -23,14 +23,48 @@ func resolve(cfg *hclConfig, ctx context.Context, dbHandler *database.DBHandler)
 		return err
 	}
 
+	for index := range cfg.Networks {
+		if err := normalizeNetwork(&cfg.Networks[index]); err != nil {
+			return err
+		}
+		if err := validateForward(&cfg.Networks[index]); err != nil {
+			return err
+		}
+		if err := validateDNS(&cfg.Networks[index]); err != nil {
+			return err
+		}
+		if err := validateNetworkQoS(&cfg.Networks[index]); err != nil {
+			return err
+		}
+	}
+
 	stores, err := collectNames("store", cfg.Stores, func(s storeDef) string { return s.Name })
 	if err != nil {
 		return err
 	}
+	for index := range cfg.Stores {
+		if err := normalizeImages(&cfg.Stores[index]); err != nil {
+			return err
+		}
+	}
+
+	firewalls, err := collectNames(
+		"firewall",
+		cfg.Firewalls,
+		func(f firewallDef) string { return f.Name },
+	)
+	if err != nil {
+		return err
+	}
+	for index := range cfg.Firewalls {
+		if err := normalizeFirewall(&cfg.Firewalls[index]); err != nil {
+			return err
+		}
+	}
 
 	// Build the HCL eval context: the symbol table that lets
 	// expressions like network.test or data.store.homelab evaluate
-	evalCtx, err := buildEvalContext(cfg, networks, stores, ctx, dbHandler)
+	evalCtx, err := buildEvalContext(cfg, networks, stores, firewalls, ctx, dbHandler)
 	if err != nil {
 		return err
 	}
@@ -39,7 +73,7 @@ func resolve(cfg *hclConfig, ctx context.Context, dbHandler *database.DBHandler)
 	for index := range cfg.VMs {
 		vm := &cfg.VMs[index]
 
-		if err := resolveExpr(
+		if err := resolveOptionalExpr(
 			vm.NetExpr,
 			evalCtx,
 			&vm.NetName,
@@ -48,6 +82,43 @@ func resolve(cfg *hclConfig, ctx context.Context, dbHandler *database.DBHandler)
 		); err != nil {
 			return err
 		}
+		if err := resolveOptionalExpr(
+			vm.FilterExpr,
+			evalCtx,
+			&vm.Filter,
+			"vm %q: filter",
+			vm.Name,
+		); err != nil {
+			return err
+		}
+		for i := range vm.Interfaces {
+			if err := resolveExpr(
+				vm.Interfaces[i].NetExpr,
+				evalCtx,
+				&vm.Interfaces[i].NetName,
+				"vm %q: interface %d: network",
+				vm.Name,
+				i,
+			); err != nil {
+				return err
+			}
+			if err := resolveOptionalExpr(
+				vm.Interfaces[i].FilterExpr,
+				evalCtx,
+				&vm.Interfaces[i].Filter,
+				"vm %q: interface %d: filter",
+				vm.Name,
+				i,
+			); err != nil {
+				return err
+			}
+		}
+		if vm.NetName == "" && vm.Filter != "" {
+			return fmt.Errorf("vm %q: filter needs a network, or goes in an interface block", vm.Name)
+		}
+		if vm.NetName == "" && len(vm.Interfaces) == 0 {
+			return fmt.Errorf("vm %q: a network or at least one interface block is required", vm.Name)
+		}
 		if err := resolveExpr(
 			vm.StoreExpr,
 			evalCtx,
@@ -57,8 +128,51 @@ func resolve(cfg *hclConfig, ctx context.Context, dbHandler *database.DBHandler)
 		); err != nil {
 			return err
 		}
+
+		if err := validateVMQoS(vm); err != nil {
+			return err
+		}
+		if err := normalizeWaitFor(vm); err != nil {
+			return err
+		}
+		if err := validateSnapshotPolicy(vm); err != nil {
+			return err
+		}
+
+		// Extra disks must have unique names within the VM
+		if _, err := collectNames(
+			fmt.Sprintf("vm %q: disk", vm.Name),
+			vm.Disks,
+			func(d diskDef) string { return d.Name },
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDiskFiles(cfg.VMs); err != nil {
+		return err
 	}
+	return validatePortForwards(cfg.VMs)
+}
 
+// validateDiskFiles checks that no extra disk of a VM shares its file
+// with the root overlay of another VM: disk "data" of VM "web" is
+// web-data.<format>, the overlay of a VM named "web-data" web-data.qcow2.
+func validateDiskFiles(vms []vmDef) error {
+	names := make(map[string]bool, len(vms))
+	for _, vm := range vms {
+		names[vm.Name] = true
+	}
+	for _, vm := range vms {
+		for _, disk := range vm.Disks {
+			if names[vm.Name+"-"+disk.Name] {
+				return fmt.Errorf(
+					"vm %q: disk %q: its file would be the disk of vm %q, rename the disk",
+					vm.Name, disk.Name, vm.Name+"-"+disk.Name,
+				)
+			}
+		}
+	}
 	return nil
 }
 
@@ -105,18 +219,37 @@ func resolveExpr(
 	return nil
 }
 
+// resolveOptionalExpr is like resolveExpr, but leaves target untouched
+// when the attribute was omitted (null).
+func resolveOptionalExpr(
+	expr hcl.Expression,
+	evalCtx *hcl.EvalContext,
+	target *string,
+	errFmt string,
+	errArgs ...any,
+) error {
+	val, diags := expr.Value(evalCtx)
+	if !diags.HasErrors() && val.IsNull() {
+		return nil
+	}
+	return resolveExpr(expr, evalCtx, target, errFmt, errArgs...)
+}
+
 // buildEvalContext creates the HCL symbol table.
 //
 // It registers these namespaces so HCL expressions can reference them:
-//   - local.X        → value from the locals block
-//   - network.X      → name of a network defined in this file
-//   - store.X        → name of a store defined in this file
-//   - data.store.X   → name of a store that exists in the DB
-//   - data.network.X → name of a network that exists in the DB
+//   - local.X         → value from the locals block
+//   - network.X       → name of a network defined in this file
+//   - store.X         → name of a store defined in this file
+//   - firewall.X      → name of a firewall defined in this file
+//   - data.store.X    → name of a store that exists in the DB
+//   - data.network.X  → name of a network that exists in the DB
+//   - data.firewall.X → name of a firewall that exists in the DB
 func buildEvalContext(
 	cfg *hclConfig,
 	networks map[string]struct{},
 	stores map[string]struct{},
+	firewalls map[string]struct{},
 	ctx context.Context,
 	dbHandler *database.DBHandler,
 ) (*hcl.EvalContext, error) {
@@ -149,12 +282,19 @@ func buildEvalContext(
 		storeMap[name] = cty.StringVal(name)
 	}
 
+	// Firewalls defined in this file
+	firewallMap := map[string]cty.Value{}
+	for name := range firewalls {
+		firewallMap[name] = cty.StringVal(name)
+	}
+
 	// Data sources: references to resources already in the DB
 	dataNet := map[string]cty.Value{}
 	dataStore := map[string]cty.Value{}
+	dataFirewall := map[string]cty.Value{}
 	for _, data := range cfg.Data {
 		switch data.Type {
-		case "network", "store":
+		case "network", "store", "firewall":
 			obj, err := dbHandler.Get(ctx, data.Type, data.Name, "default")
 			if err != nil {
 				return nil, fmt.Errorf("data.%s.%s: %w", data.Type, data.Name, err)
@@ -162,13 +302,19 @@ func buildEvalContext(
 			if obj == nil {
 				return nil, fmt.Errorf("data.%s.%s: resource not found", data.Type, data.Name)
 			}
-			if data.Type == "network" {
+			switch data.Type {
+			case "network":
 				dataNet[data.Name] = cty.StringVal(data.Name)
-			} else {
+			case "store":
 				dataStore[data.Name] = cty.StringVal(data.Name)
+			case "firewall":
+				dataFirewall[data.Name] = cty.StringVal(data.Name)
 			}
 		default:
-			return nil, fmt.Errorf("unknown data type %q (supported: store, network)", data.Type)
+			return nil, fmt.Errorf(
+				"unknown data type %q (supported: store, network, firewall)",
+				data.Type,
+			)
 		}
 	}
 
@@ -179,6 +325,9 @@ func buildEvalContext(
 	if len(storeMap) > 0 {
 		evalCtx.Variables["store"] = cty.ObjectVal(storeMap)
 	}
+	if len(firewallMap) > 0 {
+		evalCtx.Variables["firewall"] = cty.ObjectVal(firewallMap)
+	}
 
 	dataVars := map[string]cty.Value{}
 	if len(dataNet) > 0 {
@@ -187,6 +336,9 @@ func buildEvalContext(
 	if len(dataStore) > 0 {
 		dataVars["store"] = cty.ObjectVal(dataStore)
 	}
+	if len(dataFirewall) > 0 {
+		dataVars["firewall"] = cty.ObjectVal(dataFirewall)
+	}
 	if len(dataVars) > 0 {
 		evalCtx.Variables["data"] = cty.ObjectVal(dataVars)
 	}
@@ -0,0 +1,42 @@
+package config
+
+import "testing"
+
+func TestValidateDiskFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		vms     []vmDef
+		wantErr bool
+	}{
+		{
+			name: "distinct files",
+			vms: []vmDef{
+				{Name: "web", Disks: []diskDef{{Name: "data"}}},
+				{Name: "db", Disks: []diskDef{{Name: "data"}}},
+			},
+		},
+		{
+			name: "disk file is the overlay of another vm",
+			vms: []vmDef{
+				{Name: "web", Disks: []diskDef{{Name: "data"}}},
+				{Name: "web-data"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vm named like a disk it does not have",
+			vms: []vmDef{
+				{Name: "web", Disks: []diskDef{{Name: "logs"}}},
+				{Name: "web-data"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDiskFiles(test.vms)
+			if (err != nil) != test.wantErr {
+				t.Errorf("validateDiskFiles() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
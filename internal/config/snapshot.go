@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/schedule"
+)
+
+// validateSnapshotPolicy checks the schedule and retention of the
+// snapshot_policy block of a VM.
+func validateSnapshotPolicy(vm *vmDef) error {
+	policy := vm.Snapshots
+	if policy == nil {
+		return nil
+	}
+	cron, err := schedule.Parse(policy.Schedule)
+	if err != nil {
+		return fmt.Errorf("vm %q: snapshot_policy: %w", vm.Name, err)
+	}
+	if cron.Next(time.Now()).IsZero() {
+		return fmt.Errorf("vm %q: snapshot_policy: schedule %q never runs", vm.Name, policy.Schedule)
+	}
+	if policy.KeepLast < 0 || policy.KeepDaily < 0 || policy.KeepWeekly < 0 {
+		return fmt.Errorf("vm %q: snapshot_policy: keep_* cannot be negative", vm.Name)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultWaitTimeout bounds a wait_for block without a timeout.
+const defaultWaitTimeout = "5m"
+
+// normalizeWaitFor checks the wait_for block of a VM and sets its default
+// timeout. A block waiting for nothing is an error.
+func normalizeWaitFor(vm *vmDef) error {
+	wait := vm.WaitFor
+	if wait == nil {
+		return nil
+	}
+	if !wait.IP && !wait.SSH && !wait.GuestAgent {
+		return fmt.Errorf("vm %q: wait_for: set ip, ssh and/or guest_agent", vm.Name)
+	}
+	if wait.SSH && vm.NetName == "" && len(vm.Interfaces) == 0 {
+		return fmt.Errorf("vm %q: wait_for: ssh needs a network", vm.Name)
+	}
+	if wait.Timeout == "" {
+		wait.Timeout = defaultWaitTimeout
+	}
+	timeout, err := time.ParseDuration(wait.Timeout)
+	if err != nil {
+		return fmt.Errorf("vm %q: wait_for: invalid timeout %q: %w", vm.Name, wait.Timeout, err)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("vm %q: wait_for: timeout must be positive", vm.Name)
+	}
+	return nil
+}
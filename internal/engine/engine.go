@@ -23,8 +23,9 @@ func New(session registry.Session, dbHandler *database.DBHandler) *Engine {
 	return &Engine{dbHandler: dbHandler, session: session}
 }
 
-// Apply creates each desired resource and persists its state.
-// Later this will diff desired vs current and handle dependency ordering.
+// Apply creates or updates each desired resource and persists its state.
+// The provider Plan decides, from the state saved by a previous apply,
+// whether the resource is created, updated in place or left untouched.
 func (e *Engine) Apply(desired []registry.Object) error {
 	levels := sortByDependency(desired, false)
 
@@ -35,23 +36,43 @@ func (e *Engine) Apply(desired []registry.Object) error {
 				return fmt.Errorf("unknown object type: %s", obj.TypeName)
 			}
 
+			resource := obj.TypeName + "/" + obj.Name
+			current, err := e.dbHandler.Get(e.session.Ctx, obj.TypeName, obj.Name, obj.Namespace)
+			if err != nil {
+				return fmt.Errorf("get %s: %w", resource, err)
+			}
+			action, err := objectType.Lifecycle.Plan(&obj, current)
+			if err != nil {
+				return fmt.Errorf("plan %s: %w", resource, err)
+			}
+			if action == registry.ActionNone {
+				logger.Info(resource, "unchanged", nil)
+				continue
+			}
+
 			change := registry.Change{
-				Action:  registry.ActionCreate,
+				Action:  action,
 				Desired: &obj,
-				Current: nil,
+				Current: current,
 			}
 
-			resource := obj.TypeName + "/" + obj.Name
+			verb, status := "create", "created"
+			if action == registry.ActionUpdate {
+				verb, status = "update", "updated"
+			}
 			if err := objectType.Lifecycle.Apply(e.session, change); err != nil {
-				logger.Info(resource, "create", err)
+				logger.Info(resource, verb, err)
 				return fmt.Errorf("apply %s: %w", resource, err)
 			}
 
-			obj.Status = "created"
+			// Providers may report a more precise status (running, ready ...)
+			if obj.Status == "" {
+				obj.Status = status
+			}
 			if err := e.dbHandler.Put(e.session.Ctx, &obj); err != nil {
 				return fmt.Errorf("save object %s: %w", resource, err)
 			}
-			logger.Info(resource, obj.Status, nil)
+			logger.Info(resource, status, nil)
 		}
 	}
 	return nil
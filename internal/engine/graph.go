@@ -18,7 +18,6 @@ func sortByDependency(objects []registry.Object, reverse bool) [][]registry.Obje
 	for typeName := range objectsByType {
 		remaining[typeName] = true
 	}
-	resolved := make(map[string]bool)
 
 	// Step 3: Build levels by resolving dependencies round by round
 	var levels [][]registry.Object
@@ -37,7 +36,9 @@ func sortByDependency(objects []registry.Object, reverse bool) [][]registry.Obje
 
 			allDepsResolved := true
 			for _, dep := range resourceType.DependsOn {
-				if !resolved[dep] {
+				// Types absent from this batch (e.g. only referenced
+				// through data blocks) don't hold anything back
+				if remaining[dep] {
 					allDepsResolved = false
 					break
 				}
@@ -54,9 +55,8 @@ func sortByDependency(objects []registry.Object, reverse bool) [][]registry.Obje
 			break
 		}
 
-		// Step 6: Move ready types from remaining to resolved
+		// Step 6: Ready types are resolved, drop them from remaining
 		for _, typeName := range readyThisRound {
-			resolved[typeName] = true
 			delete(remaining, typeName)
 		}
 
@@ -0,0 +1,83 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// backupTimeout bounds backups and restores: they copy whole disks.
+const backupTimeout = time.Hour
+
+// BackupVM writes a backup of a VM in state to dir and returns the path
+// of its sidecar.
+func BackupVM(vmName, namespace, dir string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return "", fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return vm.BackupVM(session, object, dir)
+}
+
+// RestoreVM creates a VM from the backup whose sidecar is at path and
+// saves it in state. It returns the restored VM as namespace/name.
+func RestoreVM(path string, opts vm.RestoreOptions) (string, error) {
+	backup, err := vm.ReadBackup(path)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return "", fmt.Errorf("ensure state table: %w", err)
+	}
+	name, namespace := backup.VM.Name, backup.VM.Namespace
+	if opts.Name != "" {
+		name = opts.Name
+	}
+	if opts.Namespace != "" {
+		namespace = opts.Namespace
+	}
+	existing, err := dbHandler.Get(ctx, "vm", name, namespace)
+	if err != nil {
+		return "", fmt.Errorf("get vm %q: %w", name, err)
+	}
+	if existing != nil {
+		return "", fmt.Errorf("vm %q already exists in namespace %q", name, namespace)
+	}
+
+	restored, err := vm.Restore(session, backup, filepath.Dir(path), opts)
+	if err != nil {
+		return "", err
+	}
+	if err := dbHandler.Put(ctx, restored); err != nil {
+		return "", fmt.Errorf("save vm %q: %w", name, err)
+	}
+	return restored.Namespace + "/" + restored.Name, nil
+}
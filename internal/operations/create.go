@@ -8,15 +8,19 @@ import (
 	"github.com/zakariakebairia/kvmcli/internal/config"
 	"github.com/zakariakebairia/kvmcli/internal/database"
 	"github.com/zakariakebairia/kvmcli/internal/engine"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
 
 	// Blank imports so provider init() functions register resource types
+	_ "github.com/zakariakebairia/kvmcli/internal/providers/firewall"
 	_ "github.com/zakariakebairia/kvmcli/internal/providers/network"
-	_ "github.com/zakariakebairia/kvmcli/internal/providers/store"
-	_ "github.com/zakariakebairia/kvmcli/internal/providers/vm"
 )
 
-func CreateFromManifest(manifestPath string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// applyTimeout bounds a whole apply. It leaves room for image copies and
+// for VMs waiting to become ready.
+const applyTimeout = 30 * time.Minute
+
+func CreateFromManifest(manifestPath string, force bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
 	defer cancel()
 
 	session, cleanup, err := NewSession(ctx)
@@ -35,6 +39,12 @@ func CreateFromManifest(manifestPath string) error {
 		return fmt.Errorf("load config %q: %w", manifestPath, err)
 	}
 
+	// Images backing VM disks are protected from removal
+	if err := vm.RecordMissingBackings(session); err != nil {
+		return err
+	}
+	session.Force = force
+
 	eng := engine.New(session, dbHandler)
 	return eng.Apply(objects)
 }
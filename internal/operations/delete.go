@@ -8,9 +8,10 @@ import (
 	"github.com/zakariakebairia/kvmcli/internal/config"
 	"github.com/zakariakebairia/kvmcli/internal/database"
 	"github.com/zakariakebairia/kvmcli/internal/engine"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
 )
 
-func DeleteFromManifest(manifestPath string) error {
+func DeleteFromManifest(manifestPath string, force bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -30,6 +31,12 @@ func DeleteFromManifest(manifestPath string) error {
 		return fmt.Errorf("load config %q: %w", manifestPath, err)
 	}
 
+	// Images backing VM disks are protected from removal
+	if err := vm.RecordMissingBackings(session); err != nil {
+		return err
+	}
+	session.Force = force
+
 	eng := engine.New(session, dbHandler)
 	return eng.Destroy(objects)
 }
@@ -0,0 +1,61 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// diskTimeout bounds disk rewrites: flattening copies whole images.
+const diskTimeout = time.Hour
+
+// FlattenDisk makes the disks of a VM in state standalone copies of
+// their backing chains.
+func FlattenDisk(vmName, namespace string) error {
+	return rewriteDisk(vmName, namespace, func(session registry.Session, object *registry.Object) error {
+		return vm.Flatten(session, object)
+	})
+}
+
+// RebaseDisk moves the root disk of a VM in state onto another image of
+// its store.
+func RebaseDisk(vmName, namespace, imageName string) error {
+	return rewriteDisk(vmName, namespace, func(session registry.Session, object *registry.Object) error {
+		return vm.Rebase(session, object, imageName)
+	})
+}
+
+// rewriteDisk loads a VM, rewrites its disks and saves it.
+func rewriteDisk(
+	vmName, namespace string,
+	rewrite func(registry.Session, *registry.Object) error,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), diskTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+	if err != nil {
+		return err
+	}
+	if err := rewrite(session, object); err != nil {
+		return err
+	}
+	if err := dbHandler.Put(ctx, object); err != nil {
+		return fmt.Errorf("save vm %q: %w", vmName, err)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// pullTimeout bounds an image pull: cloud images are hundreds of MiB.
+const pullTimeout = time.Hour
+
+// PullImage downloads an image of a store from its source into the
+// artifacts_path of the store. An image already there is kept unless
+// force is set.
+func PullImage(storeName, namespace, imageName string, force bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "store", storeName, namespace)
+	if err != nil {
+		return err
+	}
+	image, err := store.FindImage(object, imageName)
+	if err != nil {
+		return err
+	}
+
+	dest := store.ImagePath(object, image)
+	if _, err := os.Stat(dest); err == nil {
+		if !force {
+			return fmt.Errorf("image %q is already at %s, use --force to pull it again", imageName, dest)
+		}
+		// Overlays read the file they were created on: replacing it corrupts them
+		if err := vm.RecordMissingBackings(session); err != nil {
+			return err
+		}
+		dependents, err := store.Dependents(session, object, imageName)
+		if err != nil {
+			return err
+		}
+		if len(dependents) > 0 {
+			return fmt.Errorf(
+				"cannot pull image %q again: it backs the disks of %s; delete them first",
+				imageName, strings.Join(dependents, ", "),
+			)
+		}
+	}
+	converted, err := store.Pull(ctx, image, dest, os.Stderr)
+	if err != nil {
+		return err
+	}
+	// The declared checksum is the one of the source, not of a converted file
+	if err := store.RecordConversion(session, object, image, converted); err != nil {
+		return err
+	}
+	// Register the new file as a volume of the artifacts pool
+	return store.RefreshPoolOf(session, dest)
+}
+
+// VerifyImages checks the image files of a store, or of every store if
+// storeName is empty, and prints what it found. It fails if any image
+// can't back an overlay.
+func VerifyImages(storeName, namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	stores, err := findStores(ctx, dbHandler, storeName, namespace)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STORE\tIMAGE\tFORMAT\tVIRTUAL SIZE\tCHECKSUM\tSTATUS")
+	var failures []error
+	for index := range stores {
+		object := &stores[index]
+		for _, image := range store.Images(object) {
+			check, err := store.VerifyImage(session, object, &image)
+			status := "ok"
+			if err != nil {
+				status = "failed"
+				failures = append(failures, err)
+			}
+			format, virtualSize := orDash(check.Format), "-"
+			if check.VirtualSize > 0 {
+				virtualSize = store.FormatBytes(check.VirtualSize)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				object.Name, image.Name, format, virtualSize, check.Checksum, status)
+		}
+	}
+	w.Flush()
+
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			logger.Errorf("%v", failure)
+		}
+		return fmt.Errorf("%d image(s) failed verification", len(failures))
+	}
+	return nil
+}
+
+// findStores returns a store, or every store of namespace if storeName
+// is empty, or every store if both are.
+func findStores(
+	ctx context.Context,
+	dbHandler *database.DBHandler,
+	storeName, namespace string,
+) ([]registry.Object, error) {
+	if storeName != "" {
+		object, err := findObject(ctx, dbHandler, "store", storeName, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return []registry.Object{*object}, nil
+	}
+	stores, err := dbHandler.List(ctx, "store")
+	if err != nil {
+		return nil, fmt.Errorf("list stores: %w", err)
+	}
+	if namespace == "" {
+		return stores, nil
+	}
+	return slices.DeleteFunc(stores, func(object registry.Object) bool {
+		return object.Namespace != namespace
+	}), nil
+}
+
+// ListImages prints the image catalog of a store, or of every store if
+// storeName is empty. Checksum statuses come from the checksum cache,
+// `image verify` refreshes it.
+func ListImages(storeName, namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	stores, err := findStores(ctx, dbHandler, storeName, namespace)
+	if err != nil {
+		return err
+	}
+	if err := vm.RecordMissingBackings(session); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STORE\tNAMESPACE\tIMAGE\tDISPLAY\tVERSION\tSIZE\tACTUAL SIZE\tCHECKSUM\tVMS")
+	for index := range stores {
+		object := &stores[index]
+		for _, image := range store.Images(object) {
+			status, err := store.ChecksumStatus(session, object, &image)
+			if err != nil {
+				return err
+			}
+			users, err := store.Dependents(session, object, image.Name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+				object.Name,
+				object.Namespace,
+				image.Name,
+				orDash(image.Display),
+				orDash(image.Version),
+				orDash(image.Size),
+				actualSize(store.ImagePath(object, &image)),
+				status,
+				len(users),
+			)
+		}
+	}
+	return w.Flush()
+}
+
+// DescribeImage prints everything known about an image of a store. It
+// verifies the image file, hashing it if the cached checksum is stale.
+func DescribeImage(storeName, namespace, imageName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "store", storeName, namespace)
+	if err != nil {
+		return err
+	}
+	image, err := store.FindImage(object, imageName)
+	if err != nil {
+		return err
+	}
+	if err := vm.RecordMissingBackings(session); err != nil {
+		return err
+	}
+	users, err := store.Dependents(session, object, image.Name)
+	if err != nil {
+		return err
+	}
+	added, err := store.ImageAdded(session, object, image.Name)
+	if err != nil {
+		return err
+	}
+
+	check, verifyErr := store.VerifyImage(session, object, image)
+	status := "ok"
+	if verifyErr != nil {
+		status = verifyErr.Error()
+	}
+	format, virtualSize := orDash(check.Format), "-"
+	if check.VirtualSize > 0 {
+		virtualSize = store.FormatBytes(check.VirtualSize)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", image.Name)
+	fmt.Fprintf(w, "Store:\t%s/%s\n", object.Namespace, object.Name)
+	fmt.Fprintf(w, "Display:\t%s\n", orDash(image.Display))
+	fmt.Fprintf(w, "Version:\t%s\n", orDash(image.Version))
+	fmt.Fprintf(w, "OS profile:\t%s\n", orDash(image.OSProfile))
+	fmt.Fprintf(w, "File:\t%s\n", check.Path)
+	fmt.Fprintf(w, "Source:\t%s\n", orDash(image.Source))
+	fmt.Fprintf(w, "Format:\t%s\n", format)
+	fmt.Fprintf(w, "Virtual size:\t%s\n", virtualSize)
+	fmt.Fprintf(w, "Size:\t%s declared, %s actual\n", orDash(image.Size), actualSize(check.Path))
+	fmt.Fprintf(w, "Checksum:\t%s (%s)\n", orDash(image.Checksum), check.Checksum)
+	if !added.IsZero() {
+		fmt.Fprintf(w, "Added:\t%s\n", added.Local().Format(time.DateTime))
+	}
+	fmt.Fprintf(w, "Status:\t%s\n", status)
+	fmt.Fprintf(w, "Used by:\t%s\n", orDash(strings.Join(users, ", ")))
+	return w.Flush()
+}
+
+// AddImage adds an image to the catalog of a store in state. Applying
+// the store from a file again replaces the catalog with its image blocks.
+func AddImage(storeName, namespace string, image store.Image) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "store", storeName, namespace)
+	if err != nil {
+		return err
+	}
+	if err := store.AddImage(session, object, image); err != nil {
+		return err
+	}
+	if err := dbHandler.Put(ctx, object); err != nil {
+		return fmt.Errorf("save store %q: %w", storeName, err)
+	}
+	return nil
+}
+
+// RemoveImage removes an image from the catalog of a store in state.
+// The image file stays in the store. It refuses while VM disks are
+// backed by the image, unless force is set.
+func RemoveImage(storeName, namespace, imageName string, force bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "store", storeName, namespace)
+	if err != nil {
+		return err
+	}
+	if err := vm.RecordMissingBackings(session); err != nil {
+		return err
+	}
+	session.Force = force
+	if err := store.RemoveImage(session, object, imageName); err != nil {
+		return err
+	}
+	if err := dbHandler.Put(ctx, object); err != nil {
+		return fmt.Errorf("save store %q: %w", storeName, err)
+	}
+	return nil
+}
+
+// actualSize returns the size of a file on disk, or - if it is missing.
+func actualSize(path string) string {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "-"
+	}
+	return store.FormatBytes(stat.Size())
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
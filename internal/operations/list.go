@@ -1,5 +1,18 @@
 package operations
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
 // -------------------------------------------
 // IDEA: resources, err := operator.GetResources()
 //
@@ -89,3 +102,51 @@ package operations
 // 	w.Flush()
 // 	return nil
 // }
+
+// ListObjects prints the objects of a resource type from state, with the
+// columns its provider registered. If namespace is empty, every namespace
+// is listed. wide adds the provider's wide columns.
+func ListObjects(typeName, namespace string, wide bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resourceType, ok := registry.Get(typeName)
+	if !ok {
+		return fmt.Errorf("unknown object type: %s", typeName)
+	}
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	objects, err := dbHandler.List(ctx, typeName)
+	if err != nil {
+		return fmt.Errorf("list %s: %w", typeName, err)
+	}
+
+	columns := resourceType.Columns
+	wide = wide && resourceType.WideFormat != nil
+	if wide {
+		columns = append(slices.Clone(columns), resourceType.WideColumns...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, object := range objects {
+		if namespace != "" && object.Namespace != namespace {
+			continue
+		}
+		row := resourceType.Format(object)
+		if wide {
+			row = append(row, resourceType.WideFormat(session, object)...)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
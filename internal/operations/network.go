@@ -0,0 +1,220 @@
+package operations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/network"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// PruneReservations removes DHCP reservations that no VM in state owns.
+// If networkName is empty, every network in state is pruned.
+func PruneReservations(networkName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+
+	networks, err := dbHandler.List(ctx, "network")
+	if err != nil {
+		return fmt.Errorf("list networks: %w", err)
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	// MAC addresses owned by VMs in state, grouped by network
+	inUse := make(map[string]map[string]bool)
+	for _, object := range vms {
+		for _, iface := range vm.Interfaces(&object) {
+			if inUse[iface.Network] == nil {
+				inUse[iface.Network] = make(map[string]bool)
+			}
+			inUse[iface.Network][iface.MAC] = true
+		}
+	}
+
+	found := false
+	for _, nw := range networks {
+		if networkName != "" && nw.Name != networkName {
+			continue
+		}
+		found = true
+
+		pruned, err := network.PruneStaticMappings(session, nw.Name, inUse[nw.Name])
+		for _, mapping := range pruned {
+			ip := mapping.IP
+			if ip == nil {
+				ip = mapping.IPv6
+			}
+			fmt.Printf(
+				"network/%s reservation %s -> %s removed\n",
+				nw.Name,
+				mapping.MAC,
+				ip,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("prune network %q: %w", nw.Name, err)
+		}
+	}
+
+	if networkName != "" && !found {
+		return fmt.Errorf("network %q not found in state", networkName)
+	}
+	return nil
+}
+
+// ListNetworkIPs prints the addresses used on a network: static IPs of VMs
+// in state and the ones allocated by IPAM.
+func ListNetworkIPs(networkName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+
+	if _, err := network.Lookup(session, networkName, ""); err != nil {
+		return err
+	}
+	allocations, err := network.Allocations(session, networkName)
+	if err != nil {
+		return err
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	type row struct{ ip, mac, vm, namespace, source string }
+	allocated := make(map[string]network.Allocation, len(allocations))
+	for _, allocation := range allocations {
+		allocated[allocation.IP] = allocation
+	}
+
+	var rows []row
+	for _, object := range vms {
+		for _, iface := range vm.Interfaces(&object) {
+			if iface.Network != networkName {
+				continue
+			}
+			if iface.IP != "" {
+				source := "static"
+				if _, ok := allocated[iface.IP]; ok {
+					source = "allocated"
+					delete(allocated, iface.IP)
+				}
+				rows = append(rows, row{iface.IP, iface.MAC, object.Name, object.Namespace, source})
+			}
+			if iface.IPv6 != "" {
+				rows = append(rows, row{iface.IPv6, iface.MAC, object.Name, object.Namespace, "static"})
+			}
+		}
+	}
+	// Allocations whose VM is not in state (yet): a failed or running apply
+	for _, allocation := range allocated {
+		rows = append(rows, row{
+			allocation.IP, "", allocation.Owner, allocation.Namespace, "allocated (pending)",
+		})
+	}
+
+	slices.SortFunc(rows, func(a, b row) int {
+		return bytes.Compare(net.ParseIP(a.ip).To16(), net.ParseIP(b.ip).To16())
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "IP\tMAC\tVM\tNAMESPACE\tSOURCE")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ip, r.mac, r.vm, r.namespace, r.source)
+	}
+	return w.Flush()
+}
+
+// ListNetworkLeases prints the DHCP leases of a network with the VM in
+// state owning each MAC. Leases of unknown MACs (VMs created outside
+// kvmcli, stale state) show "<unknown>"; unknownOnly lists only those.
+func ListNetworkLeases(networkName string, unknownOnly bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+
+	leases, err := network.Leases(session, networkName)
+	if err != nil {
+		return err
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	// VM owning each MAC on this network
+	owners := make(map[string]string)
+	for _, object := range vms {
+		for _, iface := range vm.Interfaces(&object) {
+			if iface.Network == networkName {
+				owners[strings.ToLower(iface.MAC)] = object.Namespace + "/" + object.Name
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "IP\tMAC\tHOSTNAME\tEXPIRES\tVM")
+	for _, lease := range leases {
+		owner, known := owners[strings.ToLower(lease.MAC)]
+		if known && unknownOnly {
+			continue
+		}
+		if !known {
+			owner = "<unknown>"
+		}
+		hostname := lease.Hostname
+		if hostname == "" {
+			hostname = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			lease.IP,
+			lease.MAC,
+			hostname,
+			lease.Expiry.Format(time.DateTime),
+			owner,
+		)
+	}
+	return w.Flush()
+}
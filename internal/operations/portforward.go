@@ -0,0 +1,59 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// ListPortForwards prints the port forwards of the VMs in state.
+// If namespace is empty, every namespace is listed.
+func ListPortForwards(namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "VM\tNAMESPACE\tPROTOCOL\tHOST\tGUEST\tSTATUS")
+	for _, object := range vms {
+		if namespace != "" && object.Namespace != namespace {
+			continue
+		}
+		for _, forward := range vm.PortForwards(&object) {
+			hostAddress := forward.HostAddress
+			if hostAddress == "" {
+				hostAddress = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s:%d\t%s:%d\t%s\n",
+				object.Name,
+				object.Namespace,
+				forward.Protocol,
+				hostAddress,
+				forward.HostPort,
+				object.GetString("ip"),
+				forward.GuestPort,
+				object.Status,
+			)
+		}
+	}
+	return w.Flush()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/zakariakebairia/kvmcli/internal"
 	"github.com/zakariakebairia/kvmcli/internal/config"
 	db "github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
 )
 
@@ -26,6 +27,10 @@ func NewSession(ctx context.Context) (registry.Session, func(), error) {
 		return registry.Session{}, nil, fmt.Errorf("load global config: %w", err)
 	}
 
+	// Providers read disk and interface defaults from here
+	vm.DiskDefaults = cfg.Disk
+	vm.NetworkDefaults = cfg.Network
+
 	// Connect to libvirt
 	conn, err := internal.ConnectLibvirt()
 	if err != nil {
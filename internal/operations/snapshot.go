@@ -0,0 +1,215 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// snapshotTimeout bounds snapshot operations: saving or restoring the
+// memory of a running VM takes a while.
+const snapshotTimeout = 10 * time.Minute
+
+// CreateSnapshot takes a snapshot of a VM in state.
+func CreateSnapshot(vmName, namespace, snapshotName, description string, quiesce bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+	if err != nil {
+		return err
+	}
+	return vm.CreateSnapshot(session, object, snapshotName, description, quiesce)
+}
+
+// ListSnapshots prints the snapshots of a VM. An empty vmName lists the
+// snapshots of every VM, filtered by namespace if set.
+func ListSnapshots(vmName, namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	if vmName != "" {
+		object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+		if err != nil {
+			return err
+		}
+		namespace = object.Namespace
+	}
+	snapshots, err := vm.Snapshots(session, vmName, namespace)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "VM\tNAMESPACE\tSNAPSHOT\tPARENT\tVM STATE\tCREATED\tDESCRIPTION")
+	for _, snapshot := range snapshots {
+		if namespace != "" && snapshot.Namespace != namespace {
+			continue
+		}
+		parent := snapshot.Parent
+		if parent == "" {
+			parent = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			snapshot.VM,
+			snapshot.Namespace,
+			snapshot.Name,
+			parent,
+			snapshot.VMState,
+			snapshot.CreatedAt.Local().Format(time.DateTime),
+			snapshot.Description,
+		)
+	}
+	return w.Flush()
+}
+
+// RevertSnapshot reverts a VM to one of its snapshots and records the
+// state the VM is back in.
+func RevertSnapshot(vmName, namespace, snapshotName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+	if err != nil {
+		return err
+	}
+	snapshot, err := vm.RevertSnapshot(session, object, snapshotName)
+	if err != nil {
+		return err
+	}
+
+	object.Status = snapshot.VMState
+	if err := dbHandler.Put(ctx, object); err != nil {
+		return fmt.Errorf("save vm %q: %w", vmName, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes a snapshot of a VM.
+func DeleteSnapshot(vmName, namespace, snapshotName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", vmName, namespace)
+	if err != nil {
+		return err
+	}
+	return vm.DeleteSnapshot(session, object, snapshotName)
+}
+
+// runDueTimeout bounds a run-due invocation, which may snapshot many VMs.
+const runDueTimeout = time.Hour
+
+// RunDueSnapshots runs the snapshot policies that are due: each takes
+// a snapshot and prunes the old ones. The outcome of every run is
+// printed and recorded; an error is returned if any run failed.
+func RunDueSnapshots(namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), runDueTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	now := time.Now()
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "VM\tNAMESPACE\tSNAPSHOT\tPRUNED\tOUTCOME")
+	for index := range vms {
+		object := &vms[index]
+		if namespace != "" && object.Namespace != namespace {
+			continue
+		}
+		due, err := vm.SnapshotDue(session, object, now)
+		if err != nil {
+			logger.Warnf("%v", err)
+			failed++
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		run, err := vm.RunSnapshotPolicy(session, object, now)
+		outcome := run.Outcome
+		if err != nil {
+			failed++
+			outcome = fmt.Sprintf("%s: %v", run.Outcome, err)
+		}
+		snapshot, pruned := run.Snapshot, strings.Join(run.Pruned, ",")
+		if snapshot == "" {
+			snapshot = "-"
+		}
+		if pruned == "" {
+			pruned = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			run.VM, run.Namespace, snapshot, pruned, outcome,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d snapshot policy run(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+)
+
+// GCStores lists the files of stores no VM, libvirt domain or image
+// uses, and removes them unless dryRun is set.
+func GCStores(dryRun bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	stores, err := dbHandler.List(ctx, "store")
+	if err != nil {
+		return fmt.Errorf("list stores: %w", err)
+	}
+	vms, err := dbHandler.List(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+
+	// Files are in use when a VM in state or any libvirt domain refers to them
+	inUse, err := vm.DomainDiskFiles(session)
+	if err != nil {
+		return err
+	}
+	for index := range vms {
+		inUse = append(inUse, vm.DiskFiles(&vms[index])...)
+	}
+	orphans, err := store.FindOrphans(ctx, stores, inUse)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned files")
+		return nil
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "KIND\tSIZE\tPATH")
+	for _, orphan := range orphans {
+		total += orphan.Size
+		fmt.Fprintf(w, "%s\t%s\t%s\n", orphan.Kind, store.FormatBytes(orphan.Size), orphan.Path)
+	}
+	w.Flush()
+
+	if dryRun {
+		fmt.Printf("%d orphaned file(s), %s would be reclaimed\n", len(orphans), store.FormatBytes(total))
+		return nil
+	}
+	reclaimed, err := store.RemoveOrphans(orphans)
+	for index := range stores {
+		if refreshErr := store.RefreshPools(session, &stores[index]); refreshErr != nil {
+			logger.Warnf("%v", refreshErr)
+		}
+	}
+	fmt.Printf("%s reclaimed\n", store.FormatBytes(reclaimed))
+	return err
+}
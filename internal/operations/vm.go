@@ -0,0 +1,148 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/vm"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// findObject loads an object from state. With an empty namespace the
+// name must be unique across namespaces.
+func findObject(
+	ctx context.Context,
+	dbHandler *database.DBHandler,
+	typeName, name, namespace string,
+) (*registry.Object, error) {
+	if namespace != "" {
+		object, err := dbHandler.Get(ctx, typeName, name, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("get %s %q: %w", typeName, name, err)
+		}
+		if object == nil {
+			return nil, fmt.Errorf("%s %q not found in namespace %q", typeName, name, namespace)
+		}
+		return object, nil
+	}
+
+	objects, err := dbHandler.List(ctx, typeName)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", typeName, err)
+	}
+	var found *registry.Object
+	for index := range objects {
+		if objects[index].Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf(
+				"%s %q exists in several namespaces, set the namespace",
+				typeName,
+				name,
+			)
+		}
+		found = &objects[index]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s %q not found", typeName, name)
+	}
+	return found, nil
+}
+
+// WaitVM blocks until a VM reaches condition and records the reached
+// state in its status: ready, or stopped for shutdown.
+func WaitVM(name, namespace, condition string, timeout time.Duration) error {
+	// The session outlives the wait by the time needed to save the status
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+30*time.Second)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	object, err := findObject(ctx, dbHandler, "vm", name, namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := vm.Wait(session, object, []string{condition}, timeout); err != nil {
+		if errors.Is(err, vm.ErrWaitTimeout) {
+			return fmt.Errorf("%w after %s", err, timeout)
+		}
+		return err
+	}
+
+	object.Status = "ready"
+	if condition == vm.WaitShutdown {
+		object.Status = "stopped"
+	}
+	if err := dbHandler.Put(ctx, object); err != nil {
+		return fmt.Errorf("save vm %q: %w", name, err)
+	}
+	return nil
+}
+
+// cloneTimeout bounds a clone: full clones copy whole disks.
+const cloneTimeout = time.Hour
+
+// CloneVM creates dstName from the VM srcName and saves it in state. An
+// empty dstNamespace puts the clone in the namespace of the source.
+func CloneVM(srcName, srcNamespace, dstName, dstNamespace, ip string, linked bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cloneTimeout)
+	defer cancel()
+
+	session, cleanup, err := NewSession(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create context: %w", err)
+	}
+	defer cleanup()
+
+	dbHandler := database.NewDBHandler(session.DB)
+	if err := dbHandler.EnsureTable(ctx); err != nil {
+		return fmt.Errorf("ensure state table: %w", err)
+	}
+	source, err := findObject(ctx, dbHandler, "vm", srcName, srcNamespace)
+	if err != nil {
+		return err
+	}
+	if dstNamespace == "" {
+		dstNamespace = source.Namespace
+	}
+	existing, err := dbHandler.Get(ctx, "vm", dstName, dstNamespace)
+	if err != nil {
+		return fmt.Errorf("get vm %q: %w", dstName, err)
+	}
+	if existing != nil {
+		return fmt.Errorf("vm %q already exists in namespace %q", dstName, dstNamespace)
+	}
+
+	clone, err := vm.Clone(session, source, vm.CloneOptions{
+		Name:      dstName,
+		Namespace: dstNamespace,
+		IP:        ip,
+		Linked:    linked,
+	})
+	if err != nil {
+		return err
+	}
+	if err := dbHandler.Put(ctx, clone); err != nil {
+		return fmt.Errorf("save vm %q: %w", dstName, err)
+	}
+	// A linked clone changed the disk chain of the source
+	if linked {
+		if err := dbHandler.Put(ctx, source); err != nil {
+			return fmt.Errorf("save vm %q: %w", srcName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package firewall
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// buildFilterXML generates the libvirt nwfilter XML from an Object.
+//
+// A rule with several ports and cidrs becomes one libvirt rule per
+// (port, cidr) pair. For direction "in" the cidrs match the source of the
+// traffic, for "out" its destination; ports are always destination ports.
+func buildFilterXML(obj *registry.Object) (string, error) {
+	filter := templates.NewFilter(obj.Name, obj.GetString("chain"), obj.GetInt("priority"))
+
+	for _, include := range registry.AsStrings(obj.Attrs["include"]) {
+		filter.AddFilterRef(include)
+	}
+
+	for index, rule := range obj.GetList("rules") {
+		action, _ := rule["action"].(string)
+		direction, _ := rule["direction"].(string)
+		protocol, _ := rule["protocol"].(string)
+		priority := registry.AsInt(rule["priority"])
+
+		matches, err := ruleMatches(
+			protocol,
+			direction,
+			registry.AsStrings(rule["ports"]),
+			registry.AsStrings(rule["cidrs"]),
+		)
+		if err != nil {
+			return "", fmt.Errorf("firewall %s: rule %d: %w", obj.Name, index, err)
+		}
+		for _, match := range matches {
+			filter.AddRule(action, direction, priority, match)
+		}
+	}
+
+	// Traffic no rule accepted; evaluated last
+	if defaultAction := obj.GetString("default_action"); defaultAction != "" {
+		filter.AddRule(defaultAction, "inout", 1000, templates.ProtocolMatch{
+			XMLName: xml.Name{Local: "all"},
+		})
+		filter.AddRule(defaultAction, "inout", 1000, templates.ProtocolMatch{
+			XMLName: xml.Name{Local: "all-ipv6"},
+		})
+	}
+
+	xmlConfig, err := filter.GenerateXML()
+	if err != nil {
+		return "", fmt.Errorf("generate XML for firewall %s: %w", obj.Name, err)
+	}
+	return xml.Header + string(xmlConfig), nil
+}
+
+// ruleMatches expands the ports and cidrs of a rule into protocol matches.
+func ruleMatches(protocol, direction string, ports, cidrs []string) ([]templates.ProtocolMatch, error) {
+	base := templates.ProtocolMatch{XMLName: xml.Name{Local: protocol}}
+
+	withPorts := []templates.ProtocolMatch{base}
+	if len(ports) > 0 {
+		withPorts = withPorts[:0]
+		for _, port := range ports {
+			startStr, endStr, _ := strings.Cut(port, "-")
+			start, errStart := strconv.Atoi(startStr)
+			end, errEnd := strconv.Atoi(endStr)
+			if errStart != nil || errEnd != nil {
+				return nil, fmt.Errorf("invalid port range %q", port)
+			}
+			match := base
+			match.DstPortStart, match.DstPortEnd = start, end
+			withPorts = append(withPorts, match)
+		}
+	}
+	if len(cidrs) == 0 {
+		return withPorts, nil
+	}
+
+	var matches []templates.ProtocolMatch
+	for _, cidr := range cidrs {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+		ones, _ := subnet.Mask.Size()
+		for _, match := range withPorts {
+			// A /0 matches any address, leave it out
+			if ones > 0 {
+				if direction == "out" {
+					match.DstIPAddr, match.DstIPMask = subnet.IP.String(), ones
+				} else {
+					match.SrcIPAddr, match.SrcIPMask = subnet.IP.String(), ones
+				}
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
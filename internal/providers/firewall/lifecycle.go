@@ -0,0 +1,130 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
+)
+
+func init() {
+	registry.Register(&registry.ResourceType{
+		Name:      "firewall",
+		DependsOn: []string{},
+		Lifecycle: &FirewallLifecycle{},
+		Columns:   []string{"NAME", "NAMESPACE", "RULES", "DEFAULT", "STATUS"},
+		Format: func(f registry.Object) []string {
+			return []string{
+				f.Name,
+				f.Namespace,
+				strconv.Itoa(len(f.GetList("rules"))),
+				f.GetString("default_action"),
+				f.Status,
+			}
+		},
+	})
+}
+
+// FirewallLifecycle implements registry.ResourceLifecycle for libvirt
+// network filters (nwfilter).
+type FirewallLifecycle struct{}
+
+func (l *FirewallLifecycle) Plan(desired, current *registry.Object) (registry.Action, error) {
+	if current == nil && desired != nil {
+		return registry.ActionCreate, nil
+	}
+	if current != nil && desired == nil {
+		return registry.ActionDelete, nil
+	}
+	if maps.Equal(desired.Labels, current.Labels) &&
+		registry.SameAttr(desired.Attrs, current.Attrs) {
+		return registry.ActionNone, nil
+	}
+	// Redefining a filter updates it on the running VMs that use it
+	return registry.ActionUpdate, nil
+}
+
+// previousFilter is the journaled state of a filter before it was defined.
+type previousFilter struct {
+	Name string `json:"name"`
+	XML  string `json:"xml"`
+}
+
+func (l *FirewallLifecycle) Apply(session registry.Session, change registry.Change) error {
+	spec := change.Desired
+
+	xmlConfig, err := buildFilterXML(spec)
+	if err != nil {
+		return err
+	}
+
+	steps := []transaction.Step{
+		{
+			// Define (or redefine) the filter in libvirt
+			Name: "define",
+			Do: func() (string, error) {
+				previous := previousFilter{Name: spec.Name}
+				if filter, err := session.Conn.NwfilterLookupByName(spec.Name); err == nil {
+					previous.XML, _ = session.Conn.NwfilterGetXMLDesc(filter, 0)
+				}
+				data, err := json.Marshal(previous)
+				if err != nil {
+					return "", fmt.Errorf("encode previous filter: %w", err)
+				}
+				if _, err := session.Conn.NwfilterDefineXML(xmlConfig); err != nil {
+					return "", fmt.Errorf("define filter %q: %w", spec.Name, err)
+				}
+				return string(data), nil
+			},
+			Undo: func(data string) error { return restoreFilter(session, data) },
+		},
+	}
+
+	if err := transaction.NewRunner(session, spec).Run(steps); err != nil {
+		return fmt.Errorf("apply firewall %q: %w", spec.Name, err)
+	}
+
+	spec.Status = "created"
+	return nil
+}
+
+func (l *FirewallLifecycle) Destroy(session registry.Session, change registry.Change) error {
+	current := change.Current
+
+	filter, err := session.Conn.NwfilterLookupByName(current.Name)
+	if err != nil {
+		return fmt.Errorf("filter %q not found: %w", current.Name, err)
+	}
+	// libvirt refuses to undefine a filter still used by a VM
+	if err := session.Conn.NwfilterUndefine(filter); err != nil {
+		return fmt.Errorf("undefine filter %q: %w", current.Name, err)
+	}
+	return nil
+}
+
+// restoreFilter puts back the filter definition that existed before the
+// define step, or undefines the filter if there was none.
+func restoreFilter(session registry.Session, data string) error {
+	var previous previousFilter
+	if err := json.Unmarshal([]byte(data), &previous); err != nil {
+		return fmt.Errorf("decode previous filter: %w", err)
+	}
+	if previous.XML != "" {
+		if _, err := session.Conn.NwfilterDefineXML(previous.XML); err != nil {
+			return fmt.Errorf("restore filter %q: %w", previous.Name, err)
+		}
+		return nil
+	}
+	filter, err := session.Conn.NwfilterLookupByName(previous.Name)
+	if err != nil {
+		// Never defined, nothing to undo
+		return nil
+	}
+	if err := session.Conn.NwfilterUndefine(filter); err != nil {
+		return fmt.Errorf("undefine filter %q: %w", previous.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package firewall
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// stored returns object as read back from the state DB.
+func stored(t *testing.T, object *registry.Object) *registry.Object {
+	t.Helper()
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded registry.Object
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestFirewallPlan(t *testing.T) {
+	firewall := func(change func(*registry.Object)) *registry.Object {
+		object := &registry.Object{
+			TypeName: "firewall",
+			Name:     "web",
+			Attrs: map[string]any{
+				"chain":          "",
+				"priority":       0,
+				"include":        []string{"clean-traffic"},
+				"default_action": "drop",
+				"rules": []map[string]any{
+					{"action": "accept", "direction": "in", "protocol": "tcp", "ports": []string{"22-22"}, "priority": 500},
+				},
+			},
+		}
+		if change != nil {
+			change(object)
+		}
+		return object
+	}
+
+	tests := []struct {
+		name    string
+		desired *registry.Object
+		current *registry.Object
+		want    registry.Action
+	}{
+		{name: "new", desired: firewall(nil), want: registry.ActionCreate},
+		{name: "removed", current: firewall(nil), want: registry.ActionDelete},
+		{name: "unchanged", desired: firewall(nil), current: firewall(nil), want: registry.ActionNone},
+		{
+			name: "rule",
+			desired: firewall(func(o *registry.Object) {
+				o.GetList("rules")[0]["ports"] = []string{"22-22", "443-443"}
+			}),
+			current: firewall(nil),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name:    "default action",
+			desired: firewall(func(o *registry.Object) { o.Attrs["default_action"] = "reject" }),
+			current: firewall(nil),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name:    "labels",
+			desired: firewall(func(o *registry.Object) { o.Labels = map[string]string{"tier": "front"} }),
+			current: firewall(nil),
+			want:    registry.ActionUpdate,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := test.current
+			if current != nil {
+				current = stored(t, current)
+			}
+			got, err := (&FirewallLifecycle{}).Plan(test.desired, current)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Plan() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -1,103 +1,262 @@
 package network
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net"
 
 	"github.com/digitalocean/go-libvirt"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
 )
 
-func modifyDHCPHost(
+// updateDHCPHost runs one ip-dhcp-host update on the <ip> element at
+// parentIndex. With several <ip> elements carrying DHCP (dual-stack),
+// libvirt cannot pick one itself, so the index is always explicit.
+func updateDHCPHost(
 	session registry.Session,
 	nw libvirt.Network,
-	ip net.IP,
-	mac net.HardwareAddr,
+	command libvirt.NetworkUpdateCommand,
+	parentIndex int32,
+	xmlEntry string,
 	flags libvirt.NetworkUpdateFlags,
 ) error {
-	xmlEntry := dhcpHostXML(mac, ip)
-
 	return session.Conn.NetworkUpdate(
 		nw,
-		uint32(libvirt.NetworkUpdateCommandModify),
+		uint32(command),
 		uint32(libvirt.NetworkSectionIPDhcpHost),
-		-1,
+		parentIndex,
 		xmlEntry,
 		flags,
 	)
 }
 
-func deleteDHCPHost(
-	session registry.Session,
-	nw libvirt.Network,
-	mac net.HardwareAddr,
-	flags libvirt.NetworkUpdateFlags,
-) error {
-	selector := dhcpHostSelectorXML(mac)
+func dhcpHostXML(mac net.HardwareAddr, ip net.IP) string {
+	return fmt.Sprintf(`<host mac='%s' ip='%s'/>`, mac.String(), ip.String())
+}
 
-	return session.Conn.NetworkUpdate(
-		nw,
-		uint32(libvirt.NetworkUpdateCommandDelete),
-		uint32(libvirt.NetworkSectionIPDhcpHost),
-		-1,
-		selector,
-		flags,
+func dhcpHostSelectorXML(mac net.HardwareAddr) string {
+	return fmt.Sprintf(`<host mac='%s'/>`, mac.String())
+}
+
+// dhcpv6HostXML is a DHCPv6 reservation, keyed by the DUID-LL of the MAC.
+func dhcpv6HostXML(hostAddr *HostAddr) string {
+	if hostAddr.Name == "" {
+		return dhcpv6HostSelectorXML(hostAddr)
+	}
+	return fmt.Sprintf(
+		`<host id='%s' name='%s' ip='%s'/>`,
+		duidLL(hostAddr.MAC),
+		hostAddr.Name,
+		hostAddr.IPv6.String(),
 	)
 }
 
-func addDHCPHost(
-	session registry.Session,
-	nw libvirt.Network,
-	ip net.IP,
-	mac net.HardwareAddr,
-	flags libvirt.NetworkUpdateFlags,
-) error {
-	xml := dhcpHostXML(mac, ip)
+func dhcpv6HostSelectorXML(hostAddr *HostAddr) string {
+	return fmt.Sprintf(`<host id='%s' ip='%s'/>`, duidLL(hostAddr.MAC), hostAddr.IPv6.String())
+}
 
-	return session.Conn.NetworkUpdate(
-		nw,
-		uint32(libvirt.NetworkUpdateCommandAddLast),
-		uint32(libvirt.NetworkSectionIPDhcpHost),
-		-1,
-		xml,
-		flags,
-	)
+// liveDefinition returns the parsed XML of a libvirt network.
+func liveDefinition(session registry.Session, nw libvirt.Network) (*templates.Network, error) {
+	xmlDesc, err := session.Conn.NetworkGetXMLDesc(nw, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get XML of network %q: %w", nw.Name, err)
+	}
+	var def templates.Network
+	if err := xml.Unmarshal([]byte(xmlDesc), &def); err != nil {
+		return nil, fmt.Errorf("parse XML of network %q: %w", nw.Name, err)
+	}
+	return &def, nil
 }
 
-func dhcpHostXML(mac net.HardwareAddr, ip net.IP) string {
-	return fmt.Sprintf(`<host mac='%s' ip='%s'/>`, mac.String(), ip.String())
+// ipElementSubnet returns the subnet of an <ip> element, which libvirt
+// writes either with a netmask or with a prefix.
+func ipElementSubnet(element templates.IP) *net.IPNet {
+	address := net.ParseIP(element.Address)
+	if address == nil {
+		return nil
+	}
+	if ipv4 := address.To4(); ipv4 != nil {
+		mask := net.CIDRMask(element.Prefix, 8*net.IPv4len)
+		if element.Netmask != "" {
+			mask = net.IPMask(net.ParseIP(element.Netmask).To4())
+		}
+		return &net.IPNet{IP: ipv4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(element.Prefix, 8*net.IPv6len)
+	return &net.IPNet{IP: address.Mask(mask), Mask: mask}
 }
 
-func dhcpHostSelectorXML(mac net.HardwareAddr) string {
-	return fmt.Sprintf(`<host mac='%s'/>`, mac.String())
+// ipElementIndex returns the index of the <ip> element whose subnet contains ip.
+func ipElementIndex(def *templates.Network, ip net.IP) (int32, error) {
+	for index, element := range def.IPs {
+		if subnet := ipElementSubnet(element); subnet != nil && subnet.Contains(ip) {
+			return int32(index), nil
+		}
+	}
+	return 0, fmt.Errorf("no <ip> element of network %q contains %s", def.Name, ip)
 }
 
-// TODO: I need to fix this, not clean, over engineered
-// SetStaticMapping ensures a DHCP reservation (MAC → IP) exists on a libvirt network.
-func SetStaticMapping(session registry.Session, spec *registry.Object, hostAddr *HostAddr) error {
-	networkName := spec.GetString("network")
+// dhcpReservation is one <host> entry to add or remove.
+type dhcpReservation struct {
+	ip       net.IP
+	entry    string
+	selector string
+}
+
+// reservations returns the IPv4 and DHCPv6 entries of a host.
+func reservations(hostAddr *HostAddr) []dhcpReservation {
+	var entries []dhcpReservation
+	if hostAddr.IP != nil {
+		entries = append(entries, dhcpReservation{
+			ip:       hostAddr.IP,
+			entry:    dhcpHostXML(hostAddr.MAC, hostAddr.IP),
+			selector: dhcpHostSelectorXML(hostAddr.MAC),
+		})
+	}
+	if hostAddr.IPv6 != nil {
+		entries = append(entries, dhcpReservation{
+			ip:       hostAddr.IPv6,
+			entry:    dhcpv6HostXML(hostAddr),
+			selector: dhcpv6HostSelectorXML(hostAddr),
+		})
+	}
+	return entries
+}
 
+// TODO: I need to fix this, not clean, over engineered
+// SetStaticMapping ensures the DHCP reservations (MAC → IPv4, DUID → IPv6)
+// of a host exist on a libvirt network.
+func SetStaticMapping(session registry.Session, networkName string, hostAddr *HostAddr) error {
 	flags := libvirt.NetworkUpdateAffectLive | libvirt.NetworkUpdateAffectConfig
 
 	nw, err := session.Conn.NetworkLookupByName(networkName)
 	if err != nil {
 		return fmt.Errorf("lookup network %q: %w", networkName, err)
 	}
-	if err := modifyDHCPHost(session, nw, hostAddr.IP, hostAddr.MAC, flags); err == nil {
-		return nil
+	def, err := liveDefinition(session, nw)
+	if err != nil {
+		return err
 	}
 
-	_ = deleteDHCPHost(session, nw, hostAddr.MAC, flags)
+	for _, reservation := range reservations(hostAddr) {
+		index, err := ipElementIndex(def, reservation.ip)
+		if err != nil {
+			return err
+		}
+		if err := updateDHCPHost(
+			session, nw, libvirt.NetworkUpdateCommandModify, index, reservation.entry, flags,
+		); err == nil {
+			continue
+		}
 
-	if err := addDHCPHost(session, nw, hostAddr.IP, hostAddr.MAC, flags); err != nil {
-		return fmt.Errorf(
-			"set dhcp mapping on network %q (mac=%s ip=%s): %w",
-			networkName,
-			hostAddr.MAC,
-			hostAddr.IP,
-			err,
+		_ = updateDHCPHost(
+			session, nw, libvirt.NetworkUpdateCommandDelete, index, reservation.selector, flags,
 		)
+
+		if err := updateDHCPHost(
+			session, nw, libvirt.NetworkUpdateCommandAddLast, index, reservation.entry, flags,
+		); err != nil {
+			return fmt.Errorf(
+				"set dhcp mapping on network %q (mac=%s ip=%s): %w",
+				networkName,
+				hostAddr.MAC,
+				reservation.ip,
+				err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// RemoveStaticMapping deletes the DHCP reservations of a host from a libvirt network.
+func RemoveStaticMapping(session registry.Session, networkName string, hostAddr *HostAddr) error {
+	flags := libvirt.NetworkUpdateAffectLive | libvirt.NetworkUpdateAffectConfig
+
+	nw, err := session.Conn.NetworkLookupByName(networkName)
+	if err != nil {
+		return fmt.Errorf("lookup network %q: %w", networkName, err)
+	}
+	def, err := liveDefinition(session, nw)
+	if err != nil {
+		return err
 	}
 
+	for _, reservation := range reservations(hostAddr) {
+		index, err := ipElementIndex(def, reservation.ip)
+		if err != nil {
+			return err
+		}
+		if err := updateDHCPHost(
+			session, nw, libvirt.NetworkUpdateCommandDelete, index, reservation.selector, flags,
+		); err != nil {
+			return fmt.Errorf(
+				"remove dhcp mapping on network %q (mac=%s ip=%s): %w",
+				networkName,
+				hostAddr.MAC,
+				reservation.ip,
+				err,
+			)
+		}
+	}
 	return nil
 }
+
+// StaticMappings returns the DHCP reservations currently defined on a
+// libvirt network: IPv4 hosts keyed by MAC and DHCPv6 hosts keyed by a
+// DUID-LL, both reported with their MAC.
+func StaticMappings(session registry.Session, networkName string) ([]HostAddr, error) {
+	nw, err := session.Conn.NetworkLookupByName(networkName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup network %q: %w", networkName, err)
+	}
+	def, err := liveDefinition(session, nw)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings []HostAddr
+	for _, element := range def.IPs {
+		if element.DHCP == nil {
+			continue
+		}
+		for _, host := range element.DHCP.Hosts {
+			ip := net.ParseIP(host.IP)
+			if mac, err := net.ParseMAC(host.MAC); err == nil && ip.To4() != nil {
+				mappings = append(mappings, HostAddr{IP: ip.To4(), MAC: mac})
+				continue
+			}
+			if mac := macFromDUID(host.ID); mac != nil && ip != nil {
+				mappings = append(mappings, HostAddr{IPv6: ip, MAC: mac, Name: host.Name})
+			}
+			// Other hosts (keyed by name or foreign DUIDs) are not ours
+		}
+	}
+	return mappings, nil
+}
+
+// PruneStaticMappings removes the DHCP reservations of a network whose MAC
+// is not in inUse, and returns the removed entries.
+func PruneStaticMappings(
+	session registry.Session,
+	networkName string,
+	inUse map[string]bool,
+) ([]HostAddr, error) {
+	mappings, err := StaticMappings(session, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []HostAddr
+	for _, mapping := range mappings {
+		if inUse[mapping.MAC.String()] {
+			continue
+		}
+		if err := RemoveStaticMapping(session, networkName, &mapping); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, mapping)
+	}
+	return pruned, nil
+}
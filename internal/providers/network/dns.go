@@ -0,0 +1,79 @@
+package network
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// Hostname returns the DNS name of a VM on a network, <vm>.<domain>,
+// or "" if the network has no domain.
+func Hostname(object *registry.Object, vmName string) string {
+	domain := object.GetString("domain")
+	if domain == "" {
+		return ""
+	}
+	return vmName + "." + domain
+}
+
+// updateDNSHost runs one dns-host update on a libvirt network.
+func updateDNSHost(
+	session registry.Session,
+	nw libvirt.Network,
+	command libvirt.NetworkUpdateCommand,
+	host templates.DNSHost,
+) error {
+	entry, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"host"`
+		templates.DNSHost
+	}{DNSHost: host})
+	if err != nil {
+		return fmt.Errorf("encode dns host: %w", err)
+	}
+	return session.Conn.NetworkUpdate(
+		nw,
+		uint32(command),
+		uint32(libvirt.NetworkSectionDNSHost),
+		-1,
+		string(entry),
+		libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig,
+	)
+}
+
+// SetDNSHost registers hostname on a libvirt network, with one record per
+// address (A for IPv4, AAAA for IPv6). Existing records for the same
+// address and name are replaced.
+func SetDNSHost(session registry.Session, networkName, hostname string, ips []net.IP) error {
+	nw, err := session.Conn.NetworkLookupByName(networkName)
+	if err != nil {
+		return fmt.Errorf("lookup network %q: %w", networkName, err)
+	}
+	for _, ip := range ips {
+		host := templates.DNSHost{IP: ip.String(), Hostnames: []string{hostname}}
+		// libvirt cannot modify dns hosts, delete then add
+		_ = updateDNSHost(session, nw, libvirt.NetworkUpdateCommandDelete, host)
+		if err := updateDNSHost(session, nw, libvirt.NetworkUpdateCommandAddLast, host); err != nil {
+			return fmt.Errorf("add dns record %s -> %s on network %q: %w", hostname, ip, networkName, err)
+		}
+	}
+	return nil
+}
+
+// RemoveDNSHost removes the records of hostname from a libvirt network.
+func RemoveDNSHost(session registry.Session, networkName, hostname string, ips []net.IP) error {
+	nw, err := session.Conn.NetworkLookupByName(networkName)
+	if err != nil {
+		return fmt.Errorf("lookup network %q: %w", networkName, err)
+	}
+	for _, ip := range ips {
+		host := templates.DNSHost{IP: ip.String(), Hostnames: []string{hostname}}
+		if err := updateDNSHost(session, nw, libvirt.NetworkUpdateCommandDelete, host); err != nil {
+			return fmt.Errorf("remove dns record %s -> %s on network %q: %w", hostname, ip, networkName, err)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"encoding/xml"
 	"fmt"
+	"net"
 
 	"github.com/zakariakebairia/kvmcli/internal/registry"
 	"github.com/zakariakebairia/kvmcli/internal/templates"
@@ -24,6 +25,63 @@ func buildNetworkXML(obj *registry.Object) (string, error) {
 		opts = append(opts, templates.WithBridge(bridge))
 	}
 
+	if dev := obj.GetString("dev"); dev != "" {
+		opts = append(opts, templates.WithForwardDev(dev))
+	}
+
+	if nat, ok := obj.Attrs["nat"].(map[string]any); ok {
+		opts = append(opts, templates.WithNATPorts(
+			registry.AsInt(nat["port_start"]),
+			registry.AsInt(nat["port_end"]),
+		))
+	}
+
+	for _, route := range obj.GetList("routes") {
+		cidr, _ := route["cidr"].(string)
+		gateway, _ := route["gateway"].(string)
+		_, dest, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("network %s: route %q: %w", obj.Name, cidr, err)
+		}
+		prefix, _ := dest.Mask.Size()
+		opts = append(opts, templates.WithRoute(dest.IP.String(), prefix, gateway))
+	}
+
+	if mtu := obj.GetInt("mtu"); mtu != 0 {
+		opts = append(opts, templates.WithMTU(mtu))
+	}
+	if bandwidth := BandwidthFromAttrs(obj.Attrs["bandwidth"]); bandwidth != nil {
+		opts = append(opts, templates.WithBandwidth(bandwidth))
+	}
+
+	if domain := obj.GetString("domain"); domain != "" {
+		opts = append(opts, templates.WithDomain(domain))
+	}
+	if dns, ok := obj.Attrs["dns"].(map[string]any); ok {
+		opts = append(opts, templates.WithDNS(dnsFromAttrs(dns)))
+	}
+
+	// Extra subnets come after the primary one, WithDHCP only targets the first
+	for _, block := range obj.GetList("ips") {
+		cidr, _ := block["cidr"].(string)
+		gateway, _ := block["gateway"].(string)
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("network %s: ip %q: %w", obj.Name, cidr, err)
+		}
+		family := templates.FamilyIPv4
+		if subnet.IP.To4() == nil {
+			family = templates.FamilyIPv6
+		}
+		var start, end string
+		if dhcp, ok := block["dhcp"].(map[string]any); ok {
+			start, _ = dhcp["start"].(string)
+			end, _ = dhcp["end"].(string)
+		}
+		prefix, _ := subnet.Mask.Size()
+		opts = append(opts, templates.WithIP(family, gateway, prefix, start, end))
+	}
+
 	netXML := templates.NewNetwork(
 		obj.Name,
 		obj.GetString("mode"),
@@ -40,3 +98,80 @@ func buildNetworkXML(obj *registry.Object) (string, error) {
 
 	return xml.Header + string(xmlConfig), nil
 }
+
+// dnsFromAttrs converts the stored dns block to its XML form.
+func dnsFromAttrs(attrs map[string]any) templates.DNS {
+	var dns templates.DNS
+	for _, addr := range registry.AsStrings(attrs["forwarders"]) {
+		dns.Forwarders = append(dns.Forwarders, templates.DNSForwarder{Addr: addr})
+	}
+	for _, host := range registry.AsList(attrs["hosts"]) {
+		ip, _ := host["ip"].(string)
+		dns.Hosts = append(dns.Hosts, templates.DNSHost{
+			IP:        ip,
+			Hostnames: registry.AsStrings(host["hostnames"]),
+		})
+	}
+	for _, srv := range registry.AsList(attrs["srv"]) {
+		record := templates.DNSSRV{
+			Port:     registry.AsInt(srv["port"]),
+			Priority: registry.AsInt(srv["priority"]),
+			Weight:   registry.AsInt(srv["weight"]),
+		}
+		record.Service, _ = srv["service"].(string)
+		record.Protocol, _ = srv["protocol"].(string)
+		record.Domain, _ = srv["domain"].(string)
+		record.Target, _ = srv["target"].(string)
+		dns.SRVs = append(dns.SRVs, record)
+	}
+	for _, txt := range registry.AsList(attrs["txt"]) {
+		record := templates.DNSTXT{}
+		record.Name, _ = txt["name"].(string)
+		record.Value, _ = txt["value"].(string)
+		dns.TXTs = append(dns.TXTs, record)
+	}
+	return dns
+}
+
+// BandwidthFromAttrs converts a stored bandwidth block to its XML form.
+// It returns nil if value holds no bandwidth.
+func BandwidthFromAttrs(value any) *templates.Bandwidth {
+	attrs, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	rate := func(direction string) *templates.Rate {
+		item, ok := attrs[direction].(map[string]any)
+		if !ok {
+			return nil
+		}
+		return &templates.Rate{
+			Average: registry.AsInt(item["average"]),
+			Peak:    registry.AsInt(item["peak"]),
+			Burst:   registry.AsInt(item["burst"]),
+		}
+	}
+	bandwidth := &templates.Bandwidth{Inbound: rate("inbound"), Outbound: rate("outbound")}
+	if bandwidth.Inbound == nil && bandwidth.Outbound == nil {
+		return nil
+	}
+	return bandwidth
+}
+
+// BandwidthAttrs converts a bandwidth back to its stored form.
+func BandwidthAttrs(bandwidth *templates.Bandwidth) map[string]any {
+	attrs := make(map[string]any)
+	for direction, rate := range map[string]*templates.Rate{
+		"inbound":  bandwidth.Inbound,
+		"outbound": bandwidth.Outbound,
+	} {
+		if rate != nil {
+			attrs[direction] = map[string]any{
+				"average": rate.Average,
+				"peak":    rate.Peak,
+				"burst":   rate.Burst,
+			}
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,341 @@
+package network
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// Allocation is an IP address handed out by IPAM to a VM interface.
+type Allocation struct {
+	Network   string
+	IP        string
+	Owner     string
+	Namespace string
+	Interface int
+	CreatedAt time.Time
+}
+
+// ensureAllocationsTable creates the ip_allocations table if it doesn't exist.
+// Allocations are owned by the network provider: a row keeps an address
+// reserved for a VM interface, so re-applying the VM gets the same address.
+func ensureAllocationsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS ip_allocations (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		network    TEXT NOT NULL,
+		ip         TEXT NOT NULL,
+		owner      TEXT NOT NULL,
+		owner_ns   TEXT NOT NULL DEFAULT '',
+		iface      INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_ip_allocations_network_ip
+		ON ip_allocations(network, ip);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure ip_allocations table: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns a network from state. The namespace is tried first,
+// then any network with that name (VMs may reference a network from
+// another namespace through a data block).
+func Lookup(session registry.Session, name, namespace string) (*registry.Object, error) {
+	dbHandler := database.NewDBHandler(session.DB)
+	object, err := dbHandler.Get(session.Ctx, "network", name, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get network %q: %w", name, err)
+	}
+	if object != nil {
+		return object, nil
+	}
+
+	networks, err := dbHandler.List(session.Ctx, "network")
+	if err != nil {
+		return nil, fmt.Errorf("list networks: %w", err)
+	}
+	for index := range networks {
+		if networks[index].Name == name {
+			return &networks[index], nil
+		}
+	}
+	return nil, fmt.Errorf("network %q not found in state", name)
+}
+
+// Subnet returns the IPv4 subnet of a network object and its gateway,
+// the address libvirt gives to the bridge. The normalised cidr is used
+// when present, older objects fall back to net_address/netmask.
+func Subnet(object *registry.Object) (*net.IPNet, net.IP, error) {
+	gateway := net.ParseIP(object.GetString("net_address")).To4()
+	if gateway == nil {
+		return nil, nil, fmt.Errorf(
+			"network %q: invalid address %q",
+			object.Name,
+			object.GetString("net_address"),
+		)
+	}
+	if cidr := object.GetString("cidr"); cidr != "" {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("network %q: invalid cidr: %w", object.Name, err)
+		}
+		return subnet, gateway, nil
+	}
+	mask := net.IPMask(net.ParseIP(object.GetString("netmask")).To4())
+	if ones, bits := mask.Size(); bits == 0 || ones == 0 {
+		return nil, nil, fmt.Errorf(
+			"network %q: invalid netmask %q",
+			object.Name,
+			object.GetString("netmask"),
+		)
+	}
+	return &net.IPNet{IP: gateway.Mask(mask), Mask: mask}, gateway, nil
+}
+
+// ValidateHostIP checks that ip can be given to a VM on the network:
+// inside the subnet, not its network or broadcast address, not the gateway.
+func ValidateHostIP(object *registry.Object, ip net.IP) error {
+	subnet, gateway, err := Subnet(object)
+	if err != nil {
+		return err
+	}
+	ones, bits := subnet.Mask.Size()
+	first := ipToUint(subnet.IP)
+	last := first + uint32(1)<<(bits-ones) - 1
+	value := ipToUint(ip)
+	switch {
+	case ip.To4() == nil || !subnet.Contains(ip):
+		return fmt.Errorf("%s is outside network %q (%s)", ip, object.Name, subnet)
+	case value == first || value == last:
+		return fmt.Errorf("%s is not a host address of %s", ip, subnet)
+	case ip.Equal(gateway):
+		return fmt.Errorf("%s is the gateway of network %q", ip, object.Name)
+	}
+	return nil
+}
+
+// ValidateHostIPv6 checks that ip is a host address of one of the IPv6
+// subnets (ip blocks) of the network and is not their gateway.
+func ValidateHostIPv6(object *registry.Object, ip net.IP) error {
+	if ip.To4() != nil {
+		return fmt.Errorf("%s is not an IPv6 address", ip)
+	}
+	for _, block := range object.GetList("ips") {
+		cidr, _ := block["cidr"].(string)
+		gateway, _ := block["gateway"].(string)
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil || subnet.IP.To4() != nil || !subnet.Contains(ip) {
+			continue
+		}
+		switch {
+		case ip.Equal(subnet.IP):
+			return fmt.Errorf("%s is not a host address of %s", ip, subnet)
+		case ip.Equal(net.ParseIP(gateway)):
+			return fmt.Errorf("%s is the gateway of network %q", ip, object.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is outside the IPv6 subnets of network %q", ip, object.Name)
+}
+
+// HasIPv4 reports whether a network has a primary IPv4 subnet, the one
+// IPAM allocates from. IPv6-only networks leave it empty.
+func HasIPv4(object *registry.Object) bool {
+	return object.GetString("net_address") != ""
+}
+
+// ManagesAddresses reports whether libvirt manages the subnet of a network,
+// i.e. whether it hands out (and reserves) addresses. Bridge and macvtap
+// networks leave addressing to the host network.
+func ManagesAddresses(object *registry.Object) bool {
+	switch object.GetString("mode") {
+	case templates.ForwardBridge, templates.ForwardMacvtap:
+		return false
+	}
+	return HasIPv4(object) || len(object.GetList("ips")) > 0
+}
+
+// dhcpRange returns the dynamic DHCP range of a network, or nils if none.
+func dhcpRange(object *registry.Object) (start, end net.IP) {
+	dhcp, ok := object.Attrs["dhcp"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	startStr, _ := dhcp["start"].(string)
+	endStr, _ := dhcp["end"].(string)
+	return net.ParseIP(startStr).To4(), net.ParseIP(endStr).To4()
+}
+
+func ipToUint(ip net.IP) uint32 { return binary.BigEndian.Uint32(ip.To4()) }
+
+func uintToIP(value uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, value)
+	return ip
+}
+
+// AllocateIP returns the address reserved for interface iface of a VM
+// on a network, allocating the next free one if there is none yet.
+// allocated reports a new allocation, which the caller releases if the
+// VM ends up not being created.
+//
+// Free means inside the network subnet, outside the dynamic DHCP range,
+// not the gateway, not in taken (addresses held by VMs in state) and
+// not allocated to another interface. A previous allocation that is no
+// longer free, e.g. taken since by a VM with a static IP, is dropped and
+// a new address allocated.
+func AllocateIP(
+	session registry.Session,
+	object *registry.Object,
+	owner, namespace string,
+	iface int,
+	taken map[string]bool,
+) (ip net.IP, allocated bool, err error) {
+	if err := ensureAllocationsTable(session.Ctx, session.DB); err != nil {
+		return nil, false, err
+	}
+
+	// Reuse the previous allocation, so the address is stable across applies
+	const existing = `
+	SELECT ip FROM ip_allocations
+	WHERE network = ? AND owner = ? AND owner_ns = ? AND iface = ?
+	`
+	var ipStr string
+	err = session.DB.QueryRowContext(
+		session.Ctx, existing, object.Name, owner, namespace, iface,
+	).Scan(&ipStr)
+	switch {
+	case err == nil:
+		previous := net.ParseIP(ipStr).To4()
+		if previous != nil && !taken[previous.String()] && ValidateHostIP(object, previous) == nil {
+			return previous, false, nil
+		}
+		if err := ReleaseIP(session, object.Name, ipStr, owner, namespace); err != nil {
+			return nil, false, err
+		}
+	case err != sql.ErrNoRows:
+		return nil, false, fmt.Errorf("lookup allocation: %w", err)
+	}
+
+	subnet, gateway, err := Subnet(object)
+	if err != nil {
+		return nil, false, err
+	}
+
+	allocations, err := Allocations(session, object.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	used := make(map[string]bool, len(taken)+len(allocations)+1)
+	for ip := range taken {
+		used[ip] = true
+	}
+	for _, allocation := range allocations {
+		used[allocation.IP] = true
+	}
+	used[gateway.String()] = true
+
+	var rangeStart, rangeEnd uint32
+	if start, end := dhcpRange(object); start != nil && end != nil {
+		rangeStart, rangeEnd = ipToUint(start), ipToUint(end)
+	}
+
+	ones, bits := subnet.Mask.Size()
+	first := ipToUint(subnet.IP) + 1
+	last := ipToUint(subnet.IP) + uint32(1)<<(bits-ones) - 2
+	for value := first; value <= last; value++ {
+		if rangeEnd != 0 && value >= rangeStart && value <= rangeEnd {
+			continue
+		}
+		candidate := uintToIP(value)
+		if used[candidate.String()] {
+			continue
+		}
+
+		const insert = `
+		INSERT INTO ip_allocations (network, ip, owner, owner_ns, iface)
+		VALUES (?, ?, ?, ?, ?)
+		`
+		if _, err := session.DB.ExecContext(
+			session.Ctx, insert, object.Name, candidate.String(), owner, namespace, iface,
+		); err != nil {
+			return nil, false, fmt.Errorf("record allocation: %w", err)
+		}
+		return candidate, true, nil
+	}
+
+	return nil, false, fmt.Errorf("network %q: no free address left in %s", object.Name, subnet)
+}
+
+// ReleaseIP drops the allocation of an address on a network, if the VM
+// still holds it. An address another VM was given since is left alone.
+func ReleaseIP(session registry.Session, networkName, ip, owner, namespace string) error {
+	if err := ensureAllocationsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const query = `
+	DELETE FROM ip_allocations
+	WHERE network = ? AND ip = ? AND owner = ? AND owner_ns = ?
+	`
+	if _, err := session.DB.ExecContext(
+		session.Ctx, query, networkName, ip, owner, namespace,
+	); err != nil {
+		return fmt.Errorf("release allocation of %s: %w", ip, err)
+	}
+	return nil
+}
+
+// ReleaseIPs drops every allocation held by a VM.
+func ReleaseIPs(session registry.Session, owner, namespace string) error {
+	if err := ensureAllocationsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const query = `DELETE FROM ip_allocations WHERE owner = ? AND owner_ns = ?`
+	if _, err := session.DB.ExecContext(session.Ctx, query, owner, namespace); err != nil {
+		return fmt.Errorf("release allocations of %q: %w", owner, err)
+	}
+	return nil
+}
+
+// Allocations lists the addresses allocated on a network.
+func Allocations(session registry.Session, networkName string) ([]Allocation, error) {
+	if err := ensureAllocationsTable(session.Ctx, session.DB); err != nil {
+		return nil, err
+	}
+	const query = `
+	SELECT network, ip, owner, owner_ns, iface, created_at
+	FROM ip_allocations
+	WHERE network = ?
+	`
+	rows, err := session.DB.QueryContext(session.Ctx, query, networkName)
+	if err != nil {
+		return nil, fmt.Errorf("list allocations: %w", err)
+	}
+	defer rows.Close()
+
+	var allocations []Allocation
+	for rows.Next() {
+		var allocation Allocation
+		if err := rows.Scan(
+			&allocation.Network,
+			&allocation.IP,
+			&allocation.Owner,
+			&allocation.Namespace,
+			&allocation.Interface,
+			&allocation.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan allocation: %w", err)
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations, rows.Err()
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+func newSession(t *testing.T) registry.Session {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return registry.Session{Ctx: context.Background(), DB: db}
+}
+
+// allocate records an allocation as AllocateIP would.
+func allocate(t *testing.T, session registry.Session, network, ip, owner string, iface int) {
+	t.Helper()
+	if err := ensureAllocationsTable(session.Ctx, session.DB); err != nil {
+		t.Fatal(err)
+	}
+	const insert = `
+	INSERT INTO ip_allocations (network, ip, owner, owner_ns, iface)
+	VALUES (?, ?, ?, '', ?)
+	`
+	if _, err := session.DB.Exec(insert, network, ip, owner, iface); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllocateIP(t *testing.T) {
+	// 192.168.10.0/29 has the hosts .1 to .6, .1 being the gateway
+	subnet := map[string]any{"net_address": "192.168.10.1", "cidr": "192.168.10.0/29"}
+	withDHCP := map[string]any{
+		"net_address": "192.168.10.1",
+		"cidr":        "192.168.10.0/29",
+		"dhcp":        map[string]any{"start": "192.168.10.2", "end": "192.168.10.3"},
+	}
+	type allocation struct {
+		ip    string
+		owner string
+		iface int
+	}
+
+	tests := []struct {
+		name        string
+		attrs       map[string]any
+		allocations []allocation
+		taken       []string
+		iface       int
+		want        string
+		// wantAllocated reports a new allocation, not a reused one
+		wantAllocated bool
+		wantErr       string
+	}{
+		{
+			name:          "first host after the gateway",
+			attrs:         subnet,
+			want:          "192.168.10.2",
+			wantAllocated: true,
+		},
+		{
+			name: "gateway elsewhere in the subnet",
+			attrs: map[string]any{
+				"net_address": "192.168.10.2",
+				"cidr":        "192.168.10.0/29",
+			},
+			want:          "192.168.10.1",
+			wantAllocated: true,
+		},
+		{
+			name:          "DHCP range skipped",
+			attrs:         withDHCP,
+			want:          "192.168.10.4",
+			wantAllocated: true,
+		},
+		{
+			name:          "taken by a VM in state",
+			attrs:         subnet,
+			taken:         []string{"192.168.10.2", "192.168.10.3"},
+			want:          "192.168.10.4",
+			wantAllocated: true,
+		},
+		{
+			name:          "allocated to another VM",
+			attrs:         subnet,
+			allocations:   []allocation{{"192.168.10.2", "vm2", 0}},
+			want:          "192.168.10.3",
+			wantAllocated: true,
+		},
+		{
+			name:        "previous allocation reused",
+			attrs:       subnet,
+			allocations: []allocation{{"192.168.10.5", "vm1", 0}},
+			want:        "192.168.10.5",
+		},
+		{
+			name:          "allocation of another interface not reused",
+			attrs:         subnet,
+			allocations:   []allocation{{"192.168.10.2", "vm1", 0}},
+			iface:         1,
+			want:          "192.168.10.3",
+			wantAllocated: true,
+		},
+		{
+			name:          "previous allocation taken since",
+			attrs:         subnet,
+			allocations:   []allocation{{"192.168.10.5", "vm1", 0}},
+			taken:         []string{"192.168.10.5"},
+			want:          "192.168.10.2",
+			wantAllocated: true,
+		},
+		{
+			name:          "previous allocation outside a shrunk subnet",
+			attrs:         subnet,
+			allocations:   []allocation{{"192.168.10.9", "vm1", 0}},
+			want:          "192.168.10.2",
+			wantAllocated: true,
+		},
+		{
+			name:    "no address left",
+			attrs:   withDHCP,
+			taken:   []string{"192.168.10.4", "192.168.10.5", "192.168.10.6"},
+			wantErr: "no free address left",
+		},
+		{
+			name:          "last host",
+			attrs:         subnet,
+			taken:         []string{"192.168.10.2", "192.168.10.3", "192.168.10.4", "192.168.10.5"},
+			want:          "192.168.10.6",
+			wantAllocated: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := newSession(t)
+			for _, a := range test.allocations {
+				allocate(t, session, "lab", a.ip, a.owner, a.iface)
+			}
+			taken := make(map[string]bool, len(test.taken))
+			for _, ip := range test.taken {
+				taken[ip] = true
+			}
+			object := &registry.Object{TypeName: "network", Name: "lab", Attrs: test.attrs}
+
+			ip, allocated, err := AllocateIP(session, object, "vm1", "", test.iface, taken)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("AllocateIP() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AllocateIP() error = %v", err)
+			}
+			if ip.String() != test.want || allocated != test.wantAllocated {
+				t.Errorf(
+					"AllocateIP() = %s, %t, want %s, %t",
+					ip, allocated, test.want, test.wantAllocated,
+				)
+			}
+
+			// The address is recorded for vm1, and only once
+			allocations, err := Allocations(session, "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var owners []string
+			for _, allocation := range allocations {
+				if allocation.IP == test.want {
+					owners = append(owners, allocation.Owner)
+				}
+			}
+			if len(owners) != 1 || owners[0] != "vm1" {
+				t.Errorf("allocations of %s = %v, want [vm1]", test.want, owners)
+			}
+		})
+	}
+}
+
+func TestReleaseIP(t *testing.T) {
+	session := newSession(t)
+	allocate(t, session, "lab", "192.168.10.2", "vm1", 0)
+	allocate(t, session, "lab", "192.168.10.3", "vm2", 0)
+
+	// vm1 releasing the address of vm2 leaves it allocated
+	if err := ReleaseIP(session, "lab", "192.168.10.3", "vm1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseIP(session, "lab", "192.168.10.2", "vm1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	allocations, err := Allocations(session, "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].IP != "192.168.10.3" {
+		t.Errorf("allocations = %v, want the one of vm2", allocations)
+	}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Lease is a DHCP lease handed out by the dnsmasq of a libvirt network.
+type Lease struct {
+	IP       string
+	MAC      string
+	Hostname string
+	ClientID string
+	Expiry   time.Time
+}
+
+// optString returns the value of an optional libvirt string, or "".
+func optString(value libvirt.OptString) string {
+	if len(value) == 0 {
+		return ""
+	}
+	return value[0]
+}
+
+// Leases returns the active DHCP leases (IPv4 and DHCPv6) of a libvirt network.
+func Leases(session registry.Session, networkName string) ([]Lease, error) {
+	nw, err := session.Conn.NetworkLookupByName(networkName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup network %q: %w", networkName, err)
+	}
+	rawLeases, _, err := session.Conn.NetworkGetDhcpLeases(nw, nil, 1, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get dhcp leases of network %q: %w", networkName, err)
+	}
+
+	leases := make([]Lease, 0, len(rawLeases))
+	for _, raw := range rawLeases {
+		mac := optString(raw.Mac)
+		// DHCPv6 leases carry the client DUID instead of the MAC
+		if mac == "" {
+			if fromDUID := macFromDUID(optString(raw.Clientid)); fromDUID != nil {
+				mac = fromDUID.String()
+			}
+		}
+		leases = append(leases, Lease{
+			IP:       raw.Ipaddr,
+			MAC:      mac,
+			Hostname: optString(raw.Hostname),
+			ClientID: optString(raw.Clientid),
+			Expiry:   time.Unix(raw.Expirytime, 0),
+		})
+	}
+	return leases, nil
+}
@@ -2,8 +2,13 @@ package network
 
 import (
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
+	"github.com/digitalocean/go-libvirt"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
 )
 
 func init() {
@@ -35,40 +40,116 @@ func (l *NetworkLifecycle) Plan(desired, current *registry.Object) (registry.Act
 	if current != nil && desired == nil {
 		return registry.ActionDelete, nil
 	}
+
+	// libvirt only changes the definition of a network on redefinition:
+	// every attribute but autostart needs the network to be recreated.
+	var changed []string
+	for key := range desired.Attrs {
+		if key != "autostart" && !registry.SameAttr(desired.Attrs[key], current.Attrs[key]) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range current.Attrs {
+		if _, ok := desired.Attrs[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) > 0 {
+		slices.Sort(changed)
+		return registry.ActionNone, fmt.Errorf(
+			"network %q: %s changed, recreate the network to apply it",
+			desired.Name,
+			strings.Join(changed, ", "),
+		)
+	}
+	if desired.GetBool("autostart") != current.GetBool("autostart") ||
+		!maps.Equal(desired.Labels, current.Labels) {
+		return registry.ActionUpdate, nil
+	}
 	return registry.ActionNone, nil
 }
 
 func (l *NetworkLifecycle) Apply(session registry.Session, change registry.Change) error {
 	spec := change.Desired
+	if change.Action == registry.ActionUpdate {
+		return updateNetwork(session, change)
+	}
 
 	xmlConfig, err := buildNetworkXML(spec)
 	if err != nil {
 		return err
 	}
 
-	// Define the network in libvirt
-	netInstance, err := session.Conn.NetworkDefineXML(xmlConfig)
-	if err != nil {
-		return fmt.Errorf("define network %q: %w", spec.Name, err)
+	// Steps return the name of the network only when this run defined or
+	// started it: a rollback must never undefine or stop a network that
+	// already existed.
+	var netInstance libvirt.Network
+	steps := []transaction.Step{
+		{
+			// Define the network in libvirt, unless it already exists
+			Name: "define",
+			Do: func() (string, error) {
+				if existing, err := session.Conn.NetworkLookupByName(spec.Name); err == nil {
+					netInstance = existing
+					return "", nil
+				}
+				netInstance, err = session.Conn.NetworkDefineXML(xmlConfig)
+				return spec.Name, err
+			},
+			Undo: func(name string) error { return undefineNetwork(session, name) },
+		},
+		{
+			// Start the network, unless it already runs
+			Name: "start",
+			Do: func() (string, error) {
+				active, err := session.Conn.NetworkIsActive(netInstance)
+				if err != nil {
+					return "", fmt.Errorf("get state of network %q: %w", spec.Name, err)
+				}
+				if active == 1 {
+					return "", nil
+				}
+				return spec.Name, session.Conn.NetworkCreate(netInstance)
+			},
+			Undo: func(name string) error { return stopNetwork(session, name) },
+		},
 	}
-
-	// Start the network
-	if err := session.Conn.NetworkCreate(netInstance); err != nil {
-		return fmt.Errorf("start network %q: %w", spec.Name, err)
+	if spec.GetBool("autostart") {
+		steps = append(steps, transaction.Step{
+			Name: "autostart",
+			Do: func() (string, error) {
+				return spec.Name, session.Conn.NetworkSetAutostart(netInstance, 1)
+			},
+		})
 	}
 
-	if spec.GetBool("autostart") {
-		{
-			if err := session.Conn.NetworkSetAutostart(netInstance, 1); err != nil {
-				return fmt.Errorf("set autostart for network %q: %w", spec.Name, err)
-			}
-		}
+	if err := transaction.NewRunner(session, spec).Run(steps); err != nil {
+		return fmt.Errorf("apply network %q: %w", spec.Name, err)
 	}
 
 	spec.Status = "created"
 	return nil
 }
 
+// updateNetwork applies the changes Plan allows on an existing network:
+// its autostart flag and its labels, which only live in state.
+func updateNetwork(session registry.Session, change registry.Change) error {
+	spec := change.Desired
+	netInstance, err := session.Conn.NetworkLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("network %q not found: %w", spec.Name, err)
+	}
+	var autostart int32
+	if spec.GetBool("autostart") {
+		autostart = 1
+	}
+	if err := session.Conn.NetworkSetAutostart(netInstance, autostart); err != nil {
+		return fmt.Errorf("set autostart of network %q: %w", spec.Name, err)
+	}
+	spec.Status = change.Current.Status
+	return nil
+}
+
 func (l *NetworkLifecycle) Destroy(session registry.Session, change registry.Change) error {
 	current := change.Current
 
@@ -87,3 +168,31 @@ func (l *NetworkLifecycle) Destroy(session registry.Session, change registry.Cha
 
 	return nil
 }
+
+func undefineNetwork(session registry.Session, name string) error {
+	if name == "" {
+		return nil
+	}
+	netInstance, err := session.Conn.NetworkLookupByName(name)
+	if err != nil {
+		// Never defined, nothing to undo
+		return nil
+	}
+	if err := session.Conn.NetworkUndefine(netInstance); err != nil {
+		return fmt.Errorf("undefine network %q: %w", name, err)
+	}
+	return nil
+}
+
+func stopNetwork(session registry.Session, name string) error {
+	if name == "" {
+		return nil
+	}
+	netInstance, err := session.Conn.NetworkLookupByName(name)
+	if err != nil {
+		return nil
+	}
+	// Ignore error — network might not be active
+	_ = session.Conn.NetworkDestroy(netInstance)
+	return nil
+}
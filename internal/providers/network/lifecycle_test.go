@@ -0,0 +1,110 @@
+package network
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// stored returns object as read back from the state DB.
+func stored(t *testing.T, object *registry.Object) *registry.Object {
+	t.Helper()
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded registry.Object
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestNetworkPlan(t *testing.T) {
+	network := func(change func(*registry.Object)) *registry.Object {
+		object := &registry.Object{
+			TypeName: "network",
+			Name:     "lab",
+			Labels:   map[string]string{"site": "home"},
+			Attrs: map[string]any{
+				"cidr":        "192.168.10.0/24",
+				"net_address": "192.168.10.1",
+				"netmask":     "255.255.255.0",
+				"mode":        "nat",
+				"mtu":         9000,
+				"dhcp":        map[string]any{"start": "192.168.10.100", "end": "192.168.10.200"},
+				"autostart":   false,
+			},
+		}
+		if change != nil {
+			change(object)
+		}
+		return object
+	}
+
+	tests := []struct {
+		name    string
+		desired *registry.Object
+		current *registry.Object
+		want    registry.Action
+		wantErr bool
+	}{
+		{name: "new", desired: network(nil), want: registry.ActionCreate},
+		{name: "removed", current: network(nil), want: registry.ActionDelete},
+		{name: "unchanged", desired: network(nil), current: network(nil), want: registry.ActionNone},
+		{
+			name:    "autostart",
+			desired: network(func(o *registry.Object) { o.Attrs["autostart"] = true }),
+			current: network(nil),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name:    "labels",
+			desired: network(func(o *registry.Object) { o.Labels = nil }),
+			current: network(nil),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name:    "cidr",
+			desired: network(func(o *registry.Object) { o.Attrs["cidr"] = "192.168.20.0/24" }),
+			current: network(nil),
+			wantErr: true,
+		},
+		{
+			name: "dhcp range",
+			desired: network(func(o *registry.Object) {
+				o.Attrs["dhcp"] = map[string]any{"start": "192.168.10.50", "end": "192.168.10.200"}
+			}),
+			current: network(nil),
+			wantErr: true,
+		},
+		{
+			name:    "attribute dropped",
+			desired: network(func(o *registry.Object) { delete(o.Attrs, "mtu") }),
+			current: network(nil),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := test.current
+			if current != nil {
+				current = stored(t, current)
+			}
+			got, err := (&NetworkLifecycle{}).Plan(test.desired, current)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Plan() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Plan() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,55 @@
 package network
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"strings"
 )
 
 type HostAddr struct {
 	// NetworkName string
-	IP  net.IP
-	MAC net.HardwareAddr
+	IP   net.IP // IPv4, nil on IPv6-only interfaces
+	IPv6 net.IP
+	MAC  net.HardwareAddr
+	// Name is sent along DHCPv6 reservations (usually the VM name).
+	Name string
+}
+
+// Addresses returns the IPv4 and IPv6 addresses of a host that are set.
+func (h *HostAddr) Addresses() []net.IP {
+	var ips []net.IP
+	for _, ip := range []net.IP{h.IP, h.IPv6} {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
 }
 
 // This is the mac address prefix for now.
 // TODO: move this to global config.
 const MACAddressPrefix = "02:AA"
 
+// macPrefix holds the bytes of MACAddressPrefix.
+var macPrefix = func() []byte {
+	prefix, err := hex.DecodeString(strings.ReplaceAll(MACAddressPrefix, ":", ""))
+	if err != nil || len(prefix) != 2 {
+		panic(fmt.Sprintf("invalid MAC address prefix %q", MACAddressPrefix))
+	}
+	return prefix
+}()
+
+// prefixedMAC returns the MAC address made of the kvmcli prefix and the
+// four bytes of suffix.
+func prefixedMAC(suffix []byte) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 0, 6)
+	mac = append(mac, macPrefix...)
+	return append(mac, suffix[:4]...)
+}
+
 /**
  * ARCHITECTURE NOTES:
  * - If mac is given: parse it and return it if it is valide
@@ -24,25 +59,44 @@ const MACAddressPrefix = "02:AA"
  * or be strictly auto-generated by this utility.
  */
 
-// IP2MAC derives a deterministic MAC address from an IPv4 address.
+// IP2MAC derives a deterministic MAC address from an IP address.
+// IPv4 addresses are embedded as is; IPv6 addresses don't fit in the four
+// remaining bytes, so the first four bytes of their SHA-256 are used.
+
+func ip2MAC(ip net.IP) net.HardwareAddr {
+	suffix := ip.To4()
+	if suffix == nil {
+		sum := sha256.Sum256(ip.To16())
+		suffix = sum[:4]
+	}
+	return prefixedMAC(suffix)
+}
 
-func ip2MAC(ipv4 net.IP) net.HardwareAddr {
-	return net.HardwareAddr{
-		MACAddressPrefix[0], MACAddressPrefix[1],
-		ipv4[0], ipv4[1], ipv4[2], ipv4[3],
+// RandomMAC returns a random MAC address with the kvmcli prefix, for
+// interfaces that have no IP to derive one from.
+func RandomMAC() (net.HardwareAddr, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("generate MAC address: %w", err)
 	}
+	return prefixedMAC(suffix), nil
 }
 
-// ResolveL2L3Pair validates the given IPv4 address and MAC address.
+// ResolveL2L3Pair validates the given IP address (IPv4 or IPv6) and MAC address.
 // If macStr is empty, the MAC is derived deterministically from the IP.
 func ResolveL2L3Pair(ipStr, macStr string) (*HostAddr, error) {
-	ipv4 := net.ParseIP(ipStr).To4()
-	if ipv4 == nil {
-		return nil, fmt.Errorf("invalid IPv4 address: %q", ipStr)
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %q", ipStr)
+	}
+	hostAddr := &HostAddr{}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		hostAddr.IP = ipv4
+	} else {
+		hostAddr.IPv6 = ip
 	}
-	hostAddr := &HostAddr{IP: ipv4}
 	if macStr == "" {
-		hostAddr.MAC = ip2MAC(hostAddr.IP)
+		hostAddr.MAC = ip2MAC(ip)
 		return hostAddr, nil
 	}
 	mac, err := net.ParseMAC(macStr)
@@ -52,3 +106,22 @@ func ResolveL2L3Pair(ipStr, macStr string) (*HostAddr, error) {
 	hostAddr.MAC = mac
 	return hostAddr, nil
 }
+
+// duidLL returns the DHCPv6 DUID-LL (type 3, hardware type 1) of a MAC.
+// It is the client id used for DHCPv6 reservations.
+func duidLL(mac net.HardwareAddr) string {
+	return "00:03:00:01:" + mac.String()
+}
+
+// macFromDUID reverses duidLL. It returns nil for other DUID types.
+func macFromDUID(id string) net.HardwareAddr {
+	rest, ok := strings.CutPrefix(strings.ToLower(id), "00:03:00:01:")
+	if !ok {
+		return nil
+	}
+	mac, err := net.ParseMAC(rest)
+	if err != nil {
+		return nil
+	}
+	return mac
+}
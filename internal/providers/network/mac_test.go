@@ -0,0 +1,40 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResolveL2L3Pair(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		mac  string
+		want string
+	}{
+		{"derived from IPv4", "192.168.100.1", "", "02:aa:c0:a8:64:01"},
+		{"derived from IPv6", "fd00::10", "", "02:aa:fb:c5:42:f7"},
+		{"given", "192.168.100.1", "52:54:00:12:34:56", "52:54:00:12:34:56"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := ResolveL2L3Pair(test.ip, test.mac)
+			if err != nil {
+				t.Fatalf("ResolveL2L3Pair(%q, %q) error = %v", test.ip, test.mac, err)
+			}
+			if got := addr.MAC.String(); got != test.want {
+				t.Errorf("MAC = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRandomMAC(t *testing.T) {
+	mac, err := RandomMAC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mac) != 6 || !bytes.Equal(mac[:2], []byte{0x02, 0xaa}) {
+		t.Errorf("RandomMAC() = %s, want the 02:aa prefix", mac)
+	}
+}
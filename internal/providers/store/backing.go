@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// ensureImageBackingsTable creates the image_backings table if it
+// doesn't exist. Each row says the disks of a VM are overlays of an
+// image of a store. VMs use the store of their own namespace.
+func ensureImageBackingsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS image_backings (
+		vm    TEXT NOT NULL,
+		vm_ns TEXT NOT NULL DEFAULT '',
+		store TEXT NOT NULL,
+		image TEXT NOT NULL,
+		UNIQUE(vm, vm_ns, image)
+	);
+	CREATE INDEX IF NOT EXISTS idx_image_backings_store
+		ON image_backings(store, vm_ns, image);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure image_backings table: %w", err)
+	}
+	return nil
+}
+
+// RecordBackings records the images of a store the disks of a VM are
+// overlays of, replacing what was recorded for the VM.
+func RecordBackings(session registry.Session, vm *registry.Object, storeName string, images []string) error {
+	if err := ensureImageBackingsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	tx, err := session.DB.BeginTx(session.Ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const clear = `DELETE FROM image_backings WHERE vm = ? AND vm_ns = ?`
+	if _, err := tx.ExecContext(session.Ctx, clear, vm.Name, vm.Namespace); err != nil {
+		return fmt.Errorf("clear backings of vm %q: %w", vm.Name, err)
+	}
+	const insert = `INSERT INTO image_backings (vm, vm_ns, store, image) VALUES (?, ?, ?, ?)`
+	for _, image := range images {
+		if _, err := tx.ExecContext(session.Ctx, insert, vm.Name, vm.Namespace, storeName, image); err != nil {
+			return fmt.Errorf("record backing of vm %q: %w", vm.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ForgetBackings drops what was recorded for a VM.
+func ForgetBackings(session registry.Session, vm *registry.Object) error {
+	return RecordBackings(session, vm, "", nil)
+}
+
+// TrackedVMs returns the VMs, as namespace/name, with recorded backings.
+func TrackedVMs(session registry.Session) (map[string]bool, error) {
+	if err := ensureImageBackingsTable(session.Ctx, session.DB); err != nil {
+		return nil, err
+	}
+	rows, err := session.DB.QueryContext(session.Ctx, `SELECT DISTINCT vm, vm_ns FROM image_backings`)
+	if err != nil {
+		return nil, fmt.Errorf("list image backings: %w", err)
+	}
+	defer rows.Close()
+
+	tracked := make(map[string]bool)
+	for rows.Next() {
+		var vm, namespace string
+		if err := rows.Scan(&vm, &namespace); err != nil {
+			return nil, fmt.Errorf("scan image backing: %w", err)
+		}
+		tracked[namespace+"/"+vm] = true
+	}
+	return tracked, rows.Err()
+}
+
+// Dependents returns the VMs, as namespace/name, whose disks are backed
+// by an image of a store, or by any of its images if image is empty.
+func Dependents(session registry.Session, store *registry.Object, image string) ([]string, error) {
+	if err := ensureImageBackingsTable(session.Ctx, session.DB); err != nil {
+		return nil, err
+	}
+	query := `SELECT DISTINCT vm, vm_ns FROM image_backings WHERE store = ? AND vm_ns = ?`
+	args := []any{store.Name, store.Namespace}
+	if image != "" {
+		query += ` AND image = ?`
+		args = append(args, image)
+	}
+	rows, err := session.DB.QueryContext(session.Ctx, query+` ORDER BY vm_ns, vm`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list dependents of store %q: %w", store.Name, err)
+	}
+	defer rows.Close()
+
+	var dependents []string
+	for rows.Next() {
+		var vm, namespace string
+		if err := rows.Scan(&vm, &namespace); err != nil {
+			return nil, fmt.Errorf("scan image backing: %w", err)
+		}
+		dependents = append(dependents, namespace+"/"+vm)
+	}
+	return dependents, rows.Err()
+}
+
+// checkDependents fails when VMs depend on an image of a store, or on any
+// of its images if image is empty, unless the session forces it.
+func checkDependents(session registry.Session, store *registry.Object, image, action string) error {
+	dependents, err := Dependents(session, store, image)
+	if err != nil || len(dependents) == 0 {
+		return err
+	}
+	what := fmt.Sprintf("store %q", store.Name)
+	if image != "" {
+		what = fmt.Sprintf("image %q of store %q", image, store.Name)
+	}
+	if session.Force {
+		logger.Warnf("%s: %s still backs the disks of %s", action, what, strings.Join(dependents, ", "))
+		return nil
+	}
+	return fmt.Errorf(
+		"cannot %s: %s backs the disks of %s; delete them first or use --force",
+		action, what, strings.Join(dependents, ", "),
+	)
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Kinds of orphaned files.
+const (
+	OrphanDisk    = "disk"
+	OrphanISO     = "iso"
+	OrphanPartial = "partial"
+)
+
+// Extensions of the disk files kvmcli creates in images_path.
+var diskExtensions = []string{".qcow2", ".raw", ".img"}
+
+// FlattenSuffix marks the standalone copy of a disk being written while
+// a stopped VM is flattened. It replaces the disk once complete.
+const FlattenSuffix = ".flatten.part"
+
+// Orphan is a file in a store no VM or image uses.
+type Orphan struct {
+	Path string
+	Kind string
+	Size int64
+}
+
+// FindOrphans scans the directories of stores for files nothing uses:
+// disks and ISOs in images_path that no VM in state, libvirt domain or
+// other disk refers to, and partial downloads in artifacts_path. inUse
+// holds the files VMs and domains use. Image files of the catalogs are
+// never orphans, nor are the flatten copies of disks in use: the flatten
+// may still be running.
+func FindOrphans(ctx context.Context, stores []registry.Object, inUse []string) ([]Orphan, error) {
+	used := make(map[string]bool, len(inUse))
+	for _, path := range inUse {
+		used[filepath.Clean(path)] = true
+	}
+	// A disk in use keeps its whole backing chain in use
+	for _, path := range inUse {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := markBackingChain(ctx, filepath.Clean(path), used); err != nil {
+			return nil, err
+		}
+	}
+
+	imagesDirs := make(map[string]bool)
+	artifactsDirs := make(map[string]bool)
+	for index := range stores {
+		store := &stores[index]
+		for _, image := range Images(store) {
+			used[filepath.Clean(ImagePath(store, &image))] = true
+		}
+		if path := store.GetString("images_path"); path != "" {
+			imagesDirs[filepath.Clean(path)] = true
+		}
+		if path := store.GetString("artifacts_path"); path != "" {
+			artifactsDirs[filepath.Clean(path)] = true
+		}
+	}
+
+	var candidates []Orphan
+	for dir := range imagesDirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			extension := strings.ToLower(filepath.Ext(entry.Name()))
+			switch {
+			case extension == ".iso":
+				candidates = append(candidates, Orphan{Path: path, Kind: OrphanISO})
+			case slices.Contains(diskExtensions, extension):
+				candidates = append(candidates, Orphan{Path: path, Kind: OrphanDisk})
+			case extension == partialSuffix:
+				candidates = append(candidates, Orphan{Path: path, Kind: OrphanPartial})
+			}
+		}
+	}
+	for dir := range artifactsDirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.Type().IsRegular() && strings.HasSuffix(path, partialSuffix) {
+				candidates = append(candidates, Orphan{Path: path, Kind: OrphanPartial})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %q: %w", dir, err)
+		}
+	}
+
+	var orphans []Orphan
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if used[candidate.Path] || seen[candidate.Path] {
+			continue
+		}
+		if disk, ok := strings.CutSuffix(candidate.Path, FlattenSuffix); ok && used[disk] {
+			continue
+		}
+		seen[candidate.Path] = true
+		stat, err := os.Stat(candidate.Path)
+		if err != nil {
+			continue
+		}
+		candidate.Size = stat.Size()
+		orphans = append(orphans, candidate)
+	}
+	slices.SortFunc(orphans, func(a, b Orphan) int { return strings.Compare(a.Path, b.Path) })
+	return orphans, nil
+}
+
+// markBackingChain marks the backing files of a disk, and theirs, as used.
+func markBackingChain(ctx context.Context, path string, used map[string]bool) error {
+	for {
+		info, err := imageInfo(ctx, path, "")
+		if err != nil {
+			return err
+		}
+		if info.BackingFilename == "" {
+			return nil
+		}
+		backing := info.BackingFilename
+		if !filepath.IsAbs(backing) {
+			backing = filepath.Join(filepath.Dir(path), backing)
+		}
+		backing = filepath.Clean(backing)
+		if used[backing] {
+			return nil
+		}
+		used[backing] = true
+		path = backing
+	}
+}
+
+// RemoveOrphans deletes orphaned files and returns the space reclaimed.
+func RemoveOrphans(orphans []Orphan) (int64, error) {
+	var reclaimed int64
+	var errs []error
+	for _, orphan := range orphans {
+		if err := os.Remove(orphan.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("remove %q: %w", orphan.Path, err))
+			continue
+		}
+		reclaimed += orphan.Size
+	}
+	return reclaimed, errors.Join(errs...)
+}
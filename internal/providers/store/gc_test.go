@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+func TestFindOrphans(t *testing.T) {
+	fakeQemuImg(t, `{"format": "qcow2", "virtual-size": 1024}`)
+
+	tests := []struct {
+		name  string
+		files []string
+		inUse []string
+		want  []string
+	}{
+		{
+			name:  "disks in use",
+			files: []string{"images/web.qcow2", "images/db-data.raw"},
+			inUse: []string{"images/web.qcow2", "images/db-data.raw"},
+		},
+		{
+			name:  "unused disks and ISOs",
+			files: []string{"images/web.qcow2", "images/old.qcow2", "images/old-data.img", "images/seed.iso"},
+			inUse: []string{"images/web.qcow2"},
+			want:  []string{"images/old-data.img", "images/old.qcow2", "images/seed.iso"},
+		},
+		{
+			name:  "flatten of a disk in use",
+			files: []string{"images/web.qcow2", "images/web.qcow2.flatten.part"},
+			inUse: []string{"images/web.qcow2"},
+		},
+		{
+			name:  "flatten of a disk gone",
+			files: []string{"images/old.qcow2.flatten.part"},
+			want:  []string{"images/old.qcow2.flatten.part"},
+		},
+		{
+			name:  "partial downloads",
+			files: []string{"artifacts/rocky/rocky.qcow2.part", "images/rocky.qcow2.part"},
+			want:  []string{"artifacts/rocky/rocky.qcow2.part", "images/rocky.qcow2.part"},
+		},
+		{
+			name:  "other files",
+			files: []string{"images/notes.txt", "artifacts/rocky/rocky.qcow2.sha256"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, file := range test.files {
+				path := filepath.Join(root, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("disk"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var inUse []string
+			for _, file := range test.inUse {
+				inUse = append(inUse, filepath.Join(root, file))
+			}
+			stores := []registry.Object{{
+				TypeName: "store",
+				Name:     "homelab",
+				Attrs: map[string]any{
+					"images_path":    filepath.Join(root, "images"),
+					"artifacts_path": filepath.Join(root, "artifacts"),
+				},
+			}}
+
+			orphans, err := FindOrphans(context.Background(), stores, inUse)
+			if err != nil {
+				t.Fatalf("FindOrphans() error = %v", err)
+			}
+			var got []string
+			for _, orphan := range orphans {
+				path, err := filepath.Rel(root, orphan.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, path)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("FindOrphans() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
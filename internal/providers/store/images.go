@@ -3,9 +3,64 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
 )
 
+// Image is an image a store offers, as declared in its image blocks.
+type Image struct {
+	Name      string
+	Display   string
+	Version   string
+	OSProfile string
+	File      string
+	Size      string
+	Checksum  string
+	Source    string
+}
+
+// Images reads the images stored in the attributes of a store.
+func Images(store *registry.Object) []Image {
+	items := store.GetList("images")
+	images := make([]Image, 0, len(items))
+	for _, item := range items {
+		image := Image{}
+		image.Name, _ = item["name"].(string)
+		image.Display, _ = item["display"].(string)
+		image.Version, _ = item["version"].(string)
+		image.OSProfile, _ = item["os_profile"].(string)
+		image.File, _ = item["file"].(string)
+		image.Size, _ = item["size"].(string)
+		image.Checksum, _ = item["checksum"].(string)
+		image.Source, _ = item["source"].(string)
+		images = append(images, image)
+	}
+	return images
+}
+
+// FindImage returns the image name of a store.
+func FindImage(store *registry.Object, name string) (*Image, error) {
+	for _, image := range Images(store) {
+		if image.Name == name {
+			return &image, nil
+		}
+	}
+	return nil, fmt.Errorf("store %q has no image %q", store.Name, name)
+}
+
+// ImagePath returns where the file of an image lives in a store.
+func ImagePath(store *registry.Object, image *Image) string {
+	return filepath.Join(store.GetString("artifacts_path"), image.File)
+}
+
 // ensureImagesTable creates the images table if it doesn't exist.
 // This is a store-specific concern — the images table is owned by the store provider,
 // not by the generic state store.
@@ -22,10 +77,13 @@ func ensureImagesTable(ctx context.Context, db *sql.DB) error {
 		file       TEXT,
 		checksum   TEXT,
 		size       TEXT,
+		source     TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_images_store_name_ns
 		ON images(store_name, store_ns);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_images_store_image
+		ON images(store_name, store_ns, name);
 	`
 	_, err := db.ExecContext(ctx, schema)
 	if err != nil {
@@ -34,4 +92,157 @@ func ensureImagesTable(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// func GetImage(name string) {}
+// Checksum algorithms accepted in image checksums, with their digest size.
+var checksumSizes = map[string]int{
+	"sha256": 32,
+	"sha512": 64,
+}
+
+// syncImages mirrors the images attribute of a store into the images
+// table. Images keep the time they were first added.
+func syncImages(session registry.Session, store *registry.Object) error {
+	if err := ensureImagesTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	tx, err := session.DB.BeginTx(session.Ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const upsert = `
+	INSERT INTO images (store_name, store_ns, name, display, version, os_profile, file, checksum, size, source)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(store_name, store_ns, name) DO UPDATE SET
+		display = excluded.display, version = excluded.version,
+		os_profile = excluded.os_profile, file = excluded.file,
+		checksum = excluded.checksum, size = excluded.size, source = excluded.source
+	`
+	images := Images(store)
+	names := make([]any, 0, len(images)+2)
+	names = append(names, store.Name, store.Namespace)
+	for _, image := range images {
+		if _, err := tx.ExecContext(session.Ctx, upsert,
+			store.Name, store.Namespace, image.Name, image.Display, image.Version,
+			image.OSProfile, image.File, image.Checksum, image.Size, image.Source,
+		); err != nil {
+			return fmt.Errorf("save image %q: %w", image.Name, err)
+		}
+		names = append(names, image.Name)
+	}
+
+	query := `DELETE FROM images WHERE store_name = ? AND store_ns = ?`
+	if len(images) > 0 {
+		query += ` AND name NOT IN (?` + strings.Repeat(", ?", len(images)-1) + `)`
+	}
+	if _, err := tx.ExecContext(session.Ctx, query, names...); err != nil {
+		return fmt.Errorf("delete removed images of store %q: %w", store.Name, err)
+	}
+	return tx.Commit()
+}
+
+// forgetImages removes the images of a store from the images table.
+func forgetImages(session registry.Session, store *registry.Object) error {
+	if err := ensureImagesTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const query = `DELETE FROM images WHERE store_name = ? AND store_ns = ?`
+	if _, err := session.DB.ExecContext(session.Ctx, query, store.Name, store.Namespace); err != nil {
+		return fmt.Errorf("delete images of store %q: %w", store.Name, err)
+	}
+	return nil
+}
+
+// ImageAdded returns when an image was first added to a store, or the
+// zero time if it is not in the images table.
+func ImageAdded(session registry.Session, store *registry.Object, name string) (time.Time, error) {
+	if err := ensureImagesTable(session.Ctx, session.DB); err != nil {
+		return time.Time{}, err
+	}
+	const query = `
+	SELECT created_at FROM images
+	WHERE store_name = ? AND store_ns = ? AND name = ?
+	`
+	var added time.Time
+	err := session.DB.QueryRowContext(session.Ctx, query, store.Name, store.Namespace, name).Scan(&added)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get image %q: %w", name, err)
+	}
+	return added, nil
+}
+
+// attrs converts an image back to its stored form.
+func (i Image) attrs() map[string]any {
+	return map[string]any{
+		"name":       i.Name,
+		"display":    i.Display,
+		"version":    i.Version,
+		"os_profile": i.OSProfile,
+		"file":       i.File,
+		"size":       i.Size,
+		"checksum":   i.Checksum,
+		"source":     i.Source,
+	}
+}
+
+func setImages(store *registry.Object, images []Image) {
+	items := make([]map[string]any, 0, len(images))
+	for _, image := range images {
+		items = append(items, image.attrs())
+	}
+	store.Attrs["images"] = items
+}
+
+// AddImage adds an image to the catalog of a store, and saves the
+// catalog in the images table. The caller saves the store.
+func AddImage(session registry.Session, store *registry.Object, image Image) error {
+	if image.Name == "" {
+		return fmt.Errorf("image with empty name")
+	}
+	if _, err := FindImage(store, image.Name); err == nil {
+		return fmt.Errorf("store %q already has an image %q", store.Name, image.Name)
+	}
+	if image.Checksum != "" {
+		algorithm, digest, _ := strings.Cut(image.Checksum, ":")
+		decoded, err := hex.DecodeString(digest)
+		size, known := checksumSizes[algorithm]
+		if !known || err != nil || len(decoded) != size {
+			return fmt.Errorf("checksum %q: expected sha256:<hex> or sha512:<hex>", image.Checksum)
+		}
+	}
+	if image.Source != "" {
+		source, err := url.Parse(image.Source)
+		if err != nil || (source.Scheme != "http" && source.Scheme != "https" && source.Scheme != "file") {
+			return fmt.Errorf("source must be an http(s):// or file:// URL, got %q", image.Source)
+		}
+		if image.File == "" {
+			image.File = image.Name + ".qcow2"
+		}
+	}
+	if image.File == "" {
+		return fmt.Errorf("image %q needs a file or a source", image.Name)
+	}
+
+	setImages(store, append(Images(store), image))
+	return syncImages(session, store)
+}
+
+// RemoveImage removes an image from the catalog of a store. Its file is
+// left in place. It refuses while VM disks are backed by the image,
+// unless the session forces it. The caller saves the store.
+func RemoveImage(session registry.Session, store *registry.Object, name string) error {
+	if _, err := FindImage(store, name); err != nil {
+		return err
+	}
+	if err := checkDependents(session, store, name, "remove image"); err != nil {
+		return err
+	}
+	images := slices.DeleteFunc(Images(store), func(image Image) bool {
+		return image.Name == name
+	})
+	setImages(store, images)
+	return syncImages(session, store)
+}
@@ -1,7 +1,11 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
 )
 
 const (
@@ -13,7 +17,7 @@ func init() {
 		Name:      storeObjName,
 		DependsOn: []string{}, // stores have no dependencies
 		Lifecycle: &StoreLifecycle{},
-		Columns:   []string{"NAME", "NAMESPACE", "BACKEND", "ARTIFACTS", "IMAGES", "STATUS"},
+		Columns:   []string{"NAME", "NAMESPACE", "BACKEND", "ARTIFACTS", "IMAGES", "POOL", "STATUS"},
 		Format: func(s registry.Object) []string {
 			return []string{
 				s.Name,
@@ -21,6 +25,7 @@ func init() {
 				s.GetString("backend"),
 				s.GetString("artifacts_path"),
 				s.GetString("images_path"),
+				s.GetString("pool"),
 				s.Status,
 			}
 		},
@@ -38,16 +43,86 @@ func (l *StoreLifecycle) Plan(desired, current *registry.Object) (registry.Actio
 	if current != nil && desired == nil {
 		return registry.ActionDelete, nil
 	}
-	// Could add update detection here later
-	return registry.ActionNone, nil
+	// Re-applying a store is idempotent, it refreshes its images in state
+	return registry.ActionUpdate, nil
 }
 
 func (l *StoreLifecycle) Apply(session registry.Session, change registry.Change) error {
+	spec := change.Desired
+
+	// Images dropped from the store file must not back VM disks
+	if change.Current != nil {
+		for _, image := range Images(change.Current) {
+			if _, err := FindImage(spec, image.Name); err == nil {
+				continue
+			}
+			if err := checkDependents(session, change.Current, image.Name, "remove image"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Each directory of the store is managed by a libvirt dir pool: VM
+	// disks are created as volumes of the images pool, base images show
+	// up as volumes of the artifacts pool.
+	var imagesPool, artifactsPool string
+	steps := []transaction.Step{
+		{
+			Name: "images-pool",
+			Do: func() (defined string, err error) {
+				imagesPool, defined, err = ensurePool(
+					session, poolName(spec, "images"), spec.GetString("images_path"),
+				)
+				return defined, err
+			},
+			Undo: func(name string) error { return removePool(session, name) },
+		},
+		{
+			// Reuses the images pool when both paths are the same directory
+			Name: "artifacts-pool",
+			Do: func() (defined string, err error) {
+				artifactsPool, defined, err = ensurePool(
+					session, poolName(spec, "artifacts"), spec.GetString("artifacts_path"),
+				)
+				return defined, err
+			},
+			Undo: func(name string) error { return removePool(session, name) },
+		},
+		{
+			Name: "refresh",
+			Do: func() (string, error) {
+				spec.Attrs["pool"] = imagesPool
+				spec.Attrs["artifacts_pool"] = artifactsPool
+				return "", RefreshPools(session, spec)
+			},
+		},
+		{
+			Name: "images",
+			Do: func() (string, error) {
+				return "", syncImages(session, spec)
+			},
+		},
+	}
+
+	if err := transaction.NewRunner(session, spec).Run(steps); err != nil {
+		return fmt.Errorf("apply store %q: %w", spec.Name, err)
+	}
 	return nil
 }
 
 func (l *StoreLifecycle) Destroy(session registry.Session, change registry.Change) error {
-	// Images are cleaned up by ON DELETE CASCADE in the images table FK.
+	current := change.Current
+	if err := checkDependents(session, current, "", "delete store"); err != nil {
+		return err
+	}
+
+	// Only the pools kvmcli defined are removed, a pool the store reused is
+	// left alone. Files are never deleted.
+	var errs []error
+	for _, role := range []string{"images", "artifacts"} {
+		errs = append(errs, removePool(session, poolName(current, role)))
+	}
 	// The engine handles removing the state from the resources table.
-	return nil
+	errs = append(errs, forgetImages(session, current), forgetChecksums(session, current))
+	return errors.Join(errs...)
 }
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+func TestStorePlan(t *testing.T) {
+	store := &registry.Object{
+		TypeName: "store",
+		Name:     "homelab",
+		Attrs:    map[string]any{"images_path": "/images", "artifacts_path": "/artifacts"},
+	}
+
+	tests := []struct {
+		name    string
+		desired *registry.Object
+		current *registry.Object
+		want    registry.Action
+	}{
+		{name: "new", desired: store, want: registry.ActionCreate},
+		{name: "removed", current: store, want: registry.ActionDelete},
+		// Every apply ensures and refreshes the pools of the store
+		{name: "existing", desired: store, current: store, want: registry.ActionUpdate},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := (&StoreLifecycle{}).Plan(test.desired, test.current)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Plan() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// poolName returns the name of the libvirt pool kvmcli defines for one
+// directory of a store: images holds VM disks, artifacts the base images.
+// Pool names are global to libvirt, so they carry the namespace.
+func poolName(spec *registry.Object, role string) string {
+	parts := []string{"kvmcli"}
+	if spec.Namespace != "" {
+		parts = append(parts, spec.Namespace)
+	}
+	return strings.Join(append(parts, spec.Name, role), "-")
+}
+
+// ensurePool makes sure a running, autostarted dir pool manages path.
+// A pool that already manages path is reused as is. It returns the name
+// of the pool in use, and the name of the pool if this call defined it.
+func ensurePool(session registry.Session, name, path string) (string, string, error) {
+	if path == "" {
+		return "", "", nil
+	}
+	path = filepath.Clean(path)
+
+	if pool, err := session.Conn.StoragePoolLookupByName(name); err == nil {
+		target, err := poolTarget(session, pool)
+		if err != nil {
+			return "", "", err
+		}
+		if target == path {
+			return name, "", startPool(session, pool)
+		}
+		// The store moved to another directory: the files stay where they are
+		if err := removePool(session, name); err != nil {
+			return "", "", err
+		}
+	}
+	if pool, err := session.Conn.StoragePoolLookupByTargetPath(path); err == nil {
+		return pool.Name, "", startPool(session, pool)
+	}
+
+	poolXML, err := templates.NewDirPool(name, path).GenerateXML()
+	if err != nil {
+		return "", "", fmt.Errorf("generate pool XML: %w", err)
+	}
+	pool, err := session.Conn.StoragePoolDefineXML(string(poolXML), 0)
+	if err != nil {
+		return "", "", fmt.Errorf("define pool %q: %w", name, err)
+	}
+	// Building a dir pool creates its directory
+	err = session.Conn.StoragePoolBuild(pool, libvirt.StoragePoolBuildNew)
+	if err == nil {
+		err = startPool(session, pool)
+	}
+	if err == nil {
+		err = session.Conn.StoragePoolSetAutostart(pool, 1)
+	}
+	if err != nil {
+		_ = removePool(session, name)
+		return "", "", fmt.Errorf("start pool %q: %w", name, err)
+	}
+	return name, name, nil
+}
+
+func startPool(session registry.Session, pool libvirt.StoragePool) error {
+	active, err := session.Conn.StoragePoolIsActive(pool)
+	if err != nil {
+		return fmt.Errorf("get state of pool %q: %w", pool.Name, err)
+	}
+	if active == 1 {
+		return nil
+	}
+	if err := session.Conn.StoragePoolCreate(pool, 0); err != nil {
+		return fmt.Errorf("start pool %q: %w", pool.Name, err)
+	}
+	return nil
+}
+
+// removePool stops and undefines a pool. Its directory and files are
+// left alone.
+func removePool(session registry.Session, name string) error {
+	if name == "" {
+		return nil
+	}
+	pool, err := session.Conn.StoragePoolLookupByName(name)
+	if err != nil {
+		// Never defined, nothing to remove
+		return nil
+	}
+	// Ignore error — pool might not be active
+	_ = session.Conn.StoragePoolDestroy(pool)
+	if err := session.Conn.StoragePoolUndefine(pool); err != nil {
+		return fmt.Errorf("undefine pool %q: %w", name, err)
+	}
+	return nil
+}
+
+func poolTarget(session registry.Session, pool libvirt.StoragePool) (string, error) {
+	poolXML, err := session.Conn.StoragePoolGetXMLDesc(pool, 0)
+	if err != nil {
+		return "", fmt.Errorf("get XML of pool %q: %w", pool.Name, err)
+	}
+	var definition templates.StoragePool
+	if err := xml.Unmarshal([]byte(poolXML), &definition); err != nil {
+		return "", fmt.Errorf("parse XML of pool %q: %w", pool.Name, err)
+	}
+	return filepath.Clean(definition.Target.Path), nil
+}
+
+// poolOf returns the pool managing the directory of path.
+func poolOf(session registry.Session, path string) (libvirt.StoragePool, bool) {
+	pool, err := session.Conn.StoragePoolLookupByTargetPath(filepath.Dir(filepath.Clean(path)))
+	return pool, err == nil
+}
+
+// RefreshPoolOf makes the pool managing the directory of path, if any,
+// rescan it for files created or removed behind libvirt's back.
+func RefreshPoolOf(session registry.Session, path string) error {
+	pool, ok := poolOf(session, path)
+	if !ok {
+		return nil
+	}
+	if err := session.Conn.StoragePoolRefresh(pool, 0); err != nil {
+		return fmt.Errorf("refresh pool %q: %w", pool.Name, err)
+	}
+	return nil
+}
+
+// RefreshPools rescans the pools of a store, which registers its image
+// files as volumes.
+func RefreshPools(session registry.Session, store *registry.Object) error {
+	for _, key := range []string{"pool", "artifacts_pool"} {
+		name := store.GetString(key)
+		if name == "" {
+			continue
+		}
+		pool, err := session.Conn.StoragePoolLookupByName(name)
+		if err != nil {
+			return fmt.Errorf("lookup pool %q: %w", name, err)
+		}
+		if err := session.Conn.StoragePoolRefresh(pool, 0); err != nil {
+			return fmt.Errorf("refresh pool %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CreateOverlayVolume creates dest as a qcow2 volume backed by the qcow2
+// image at backing, in the pool managing the directory of dest. It
+// reports false, without error, when no pool manages that directory.
+func CreateOverlayVolume(session registry.Session, backing, dest string) (bool, error) {
+	pool, ok := poolOf(session, dest)
+	if !ok {
+		return false, nil
+	}
+	info, err := imageInfo(session.Ctx, backing, "qcow2")
+	if err != nil {
+		return true, err
+	}
+	volumeXML, err := templates.NewOverlayVolume(
+		filepath.Base(dest), backing, info.VirtualSize,
+	).GenerateXML()
+	if err != nil {
+		return true, fmt.Errorf("generate volume XML: %w", err)
+	}
+	if _, err := session.Conn.StorageVolCreateXML(pool, string(volumeXML), 0); err != nil {
+		return true, fmt.Errorf("create volume %q in pool %q: %w", filepath.Base(dest), pool.Name, err)
+	}
+	return true, nil
+}
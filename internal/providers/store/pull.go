@@ -0,0 +1,322 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var QemuImgBinary = "qemu-img"
+
+// partialSuffix marks a download in progress. A pull that fails or is
+// interrupted leaves the partial file behind, the next pull resumes it.
+const partialSuffix = ".part"
+
+// Formats qemu-img converts to qcow2 when pulling an image.
+var convertibleFormats = map[string]bool{
+	"raw":   true,
+	"vmdk":  true,
+	"vhdx":  true,
+	"vpc":   true, // vhd
+	"qcow":  true,
+	"vdi":   true,
+	"qcow2": false,
+}
+
+// Pull downloads the source of an image into dest: http(s) downloads
+// resume a previous partial one, the checksum is verified on the
+// downloaded file, and images that are not qcow2 are converted.
+// Progress is written to progress.
+//
+// The declared checksum is the one of the source. When the source was
+// converted, converted is the checksum of dest, with the same algorithm,
+// for RecordConversion.
+func Pull(ctx context.Context, image *Image, dest string, progress io.Writer) (converted string, err error) {
+	if image.Source == "" {
+		return "", fmt.Errorf("image %q has no source", image.Name)
+	}
+	source, err := url.Parse(image.Source)
+	if err != nil {
+		return "", fmt.Errorf("image %q: invalid source: %w", image.Name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", fmt.Errorf("create directory of %q: %w", dest, err)
+	}
+
+	partial := dest + partialSuffix
+	switch source.Scheme {
+	case "http", "https":
+		err = download(ctx, image.Source, partial, progress)
+	case "file":
+		err = copyFile(source.Path, partial, progress)
+	default:
+		err = fmt.Errorf("unsupported source scheme %q", source.Scheme)
+	}
+	if err != nil {
+		return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+	}
+
+	if image.Checksum != "" {
+		fmt.Fprintf(progress, "verifying %s\n", image.Checksum)
+		if err := VerifyChecksum(partial, image.Checksum); err != nil {
+			// A corrupt download must not be resumed
+			_ = os.Remove(partial)
+			return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+		}
+	}
+
+	// The format is probed once, every later qemu-img call pins it
+	info, err := imageInfo(ctx, partial, "")
+	if err != nil {
+		return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+	}
+	convert, known := convertibleFormats[info.Format]
+	if !known {
+		return "", fmt.Errorf("pull image %q: unsupported image format %q", image.Name, info.Format)
+	}
+	// A backing file would let the image read any file of the host
+	if info.BackingFilename != "" {
+		_ = os.Remove(partial)
+		return "", fmt.Errorf(
+			"pull image %q: the image has a backing file (%s), base images must be standalone",
+			image.Name, info.BackingFilename,
+		)
+	}
+	if !convert {
+		if err := os.Rename(partial, dest); err != nil {
+			return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+		}
+		return "", nil
+	}
+
+	fmt.Fprintf(progress, "converting %s to qcow2\n", info.Format)
+	convertedPath := dest + ".qcow2" + partialSuffix
+	output, err := exec.CommandContext(ctx, QemuImgBinary,
+		"convert", "-f", info.Format, "-O", "qcow2", partial, convertedPath,
+	).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(convertedPath)
+		return "", fmt.Errorf("pull image %q: convert: %w: %s", image.Name, err, output)
+	}
+	if image.Checksum != "" {
+		if converted, err = fileChecksum(convertedPath, image.Checksum); err != nil {
+			_ = os.Remove(convertedPath)
+			return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+		}
+	}
+	if err := os.Rename(convertedPath, dest); err != nil {
+		return "", fmt.Errorf("pull image %q: %w", image.Name, err)
+	}
+	return converted, os.Remove(partial)
+}
+
+// download fetches url into path. If path already holds the beginning
+// of the file, only the rest is requested.
+func download(ctx context.Context, url, path string, progress io.Writer) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		fmt.Fprintf(progress, "resuming download at %s\n", FormatBytes(offset))
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete
+		return nil
+	case response.StatusCode == http.StatusOK:
+		// No resume support: start over
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		offset = 0
+	default:
+		return fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+
+	total := int64(-1)
+	if response.ContentLength >= 0 {
+		total = offset + response.ContentLength
+	}
+	counter := &progressWriter{out: progress, done: offset, total: total}
+	_, err = io.Copy(file, io.TeeReader(response.Body, counter))
+	counter.finish()
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	return nil
+}
+
+// copyFile copies a local source to path.
+func copyFile(src, path string, progress io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	counter := &progressWriter{out: progress, total: stat.Size()}
+	_, err = io.Copy(out, io.TeeReader(in, counter))
+	counter.finish()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// VerifyChecksum checks the file at path against a checksum of the form
+// sha256:<hex> or sha512:<hex>.
+func VerifyChecksum(path, checksum string) error {
+	actual, err := fileChecksum(path, checksum)
+	if err != nil {
+		return err
+	}
+	if actual != strings.ToLower(checksum) {
+		return fmt.Errorf("checksum mismatch for %q: expected %s, got %s", path, checksum, actual)
+	}
+	return nil
+}
+
+// fileChecksum computes the checksum of a file with the algorithm of
+// the given checksum, in the same form.
+func fileChecksum(path, checksum string) (string, error) {
+	algorithm, _, _ := strings.Cut(checksum, ":")
+	var digest hash.Hash
+	switch algorithm {
+	case "sha256":
+		digest = sha256.New()
+	case "sha512":
+		digest = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", fmt.Errorf("read %q: %w", path, err)
+	}
+	return algorithm + ":" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// diskInfo is the part of `qemu-img info --output=json` we read.
+type diskInfo struct {
+	Format          string `json:"format"`
+	VirtualSize     int64  `json:"virtual-size"`
+	ActualSize      int64  `json:"actual-size"`
+	BackingFilename string `json:"backing-filename"`
+}
+
+// imageInfo runs qemu-img info on path. format pins the format of the
+// image, qemu-img probes it when empty: probing is only safe on a file
+// whose format is not known yet.
+func imageInfo(ctx context.Context, path, format string) (*diskInfo, error) {
+	// -U reads images a running VM holds locked
+	args := []string{"info", "-U", "--output=json"}
+	if format != "" {
+		args = append(args, "-f", format)
+	}
+	output, err := exec.CommandContext(ctx, QemuImgBinary, append(args, path)...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("qemu-img info %q: %w: %s", path, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("qemu-img info %q: %w", path, err)
+	}
+	var info diskInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("parse qemu-img info of %q: %w", path, err)
+	}
+	return &info, nil
+}
+
+// progressWriter counts the bytes written through it and prints the
+// progress at most once a second.
+type progressWriter struct {
+	out         io.Writer
+	done, total int64
+	last        time.Time
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.done += int64(len(data))
+	if time.Since(p.last) >= time.Second {
+		p.print()
+		p.last = time.Now()
+	}
+	return len(data), nil
+}
+
+func (p *progressWriter) print() {
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "\r%s / %s (%d%%)   ",
+			FormatBytes(p.done), FormatBytes(p.total), p.done*100/p.total)
+		return
+	}
+	fmt.Fprintf(p.out, "\r%s   ", FormatBytes(p.done))
+}
+
+func (p *progressWriter) finish() {
+	p.print()
+	fmt.Fprintln(p.out)
+}
+
+// FormatBytes prints a size with a binary unit, e.g. 1.5 GiB.
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
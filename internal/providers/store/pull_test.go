@@ -0,0 +1,154 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeQemuImg replaces qemu-img with a script printing info as the
+// output of qemu-img info.
+func fakeQemuImg(t *testing.T, info string) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "qemu-img")
+	content := "#!/bin/sh\ncat <<'EOF'\n" + info + "\nEOF\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	previous := QemuImgBinary
+	QemuImgBinary = script
+	t.Cleanup(func() { QemuImgBinary = previous })
+}
+
+func TestPull(t *testing.T) {
+	content := bytes.Repeat([]byte("kvmcli image "), 1000)
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	// serveRanges honours Range requests, serveFull ignores them
+	serveRanges := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "image.qcow2", time.Time{}, bytes.NewReader(content))
+	}
+	serveFull := func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		partial  []byte
+		checksum string
+		info     string
+		wantErr  string
+		// wantRange is the Range header the server must receive
+		wantRange string
+	}{
+		{
+			name:     "fresh download",
+			handler:  serveRanges,
+			checksum: checksum,
+		},
+		{
+			name:      "resume",
+			handler:   serveRanges,
+			partial:   content[:4000],
+			checksum:  checksum,
+			wantRange: "bytes=4000-",
+		},
+		{
+			name:      "partial already complete",
+			handler:   serveRanges,
+			partial:   content,
+			checksum:  checksum,
+			wantRange: "bytes=13000-",
+		},
+		{
+			name:      "server without range support",
+			handler:   serveFull,
+			partial:   []byte("stale bytes of another file"),
+			checksum:  checksum,
+			wantRange: "bytes=27-",
+		},
+		{
+			name:     "checksum mismatch",
+			handler:  serveRanges,
+			checksum: "sha256:" + strings.Repeat("0", 64),
+			wantErr:  "checksum mismatch",
+		},
+		{
+			name:    "backing file",
+			handler: serveRanges,
+			info:    `{"format": "qcow2", "virtual-size": 1024, "backing-filename": "/etc/shadow"}`,
+			wantErr: "has a backing file",
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "gone", http.StatusNotFound)
+			},
+			wantErr: "404",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := test.info
+			if info == "" {
+				info = `{"format": "qcow2", "virtual-size": 1024}`
+			}
+			fakeQemuImg(t, info)
+
+			var gotRange string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRange = r.Header.Get("Range")
+				test.handler(w, r)
+			}))
+			defer server.Close()
+
+			dest := filepath.Join(t.TempDir(), "image.qcow2")
+			if test.partial != nil {
+				if err := os.WriteFile(dest+partialSuffix, test.partial, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			image := &Image{Name: "image", Source: server.URL + "/image.qcow2", Checksum: test.checksum}
+			_, err := Pull(context.Background(), image, dest, io.Discard)
+
+			if gotRange != test.wantRange {
+				t.Errorf("Range = %q, want %q", gotRange, test.wantRange)
+			}
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Pull() error = %v, want %q", err, test.wantErr)
+				}
+				if _, statErr := os.Stat(dest); statErr == nil {
+					t.Errorf("%s exists after a failed pull", dest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Pull() error = %v", err)
+			}
+			got, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("pulled %d bytes, want the %d bytes of the source", len(got), len(content))
+			}
+			if _, err := os.Stat(dest + partialSuffix); err == nil {
+				t.Errorf("partial file left after a successful pull")
+			}
+		})
+	}
+}
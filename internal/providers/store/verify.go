@@ -0,0 +1,331 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// ImageCheck is what verifying the file of an image found.
+type ImageCheck struct {
+	Image       Image
+	Path        string
+	Format      string
+	VirtualSize int64
+	FileSize    int64
+	// Checksum is ok, or unchecked when the image declares none
+	Checksum string
+}
+
+// ensureImageChecksumsTable creates the image_checksums table if it
+// doesn't exist. Hashing a base image takes seconds, so the checksum of
+// each image file is cached with the size and mtime it was computed at.
+func ensureImageChecksumsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS image_checksums (
+		store_name TEXT NOT NULL,
+		store_ns   TEXT NOT NULL DEFAULT '',
+		image      TEXT NOT NULL,
+		path       TEXT NOT NULL,
+		size       INTEGER NOT NULL,
+		mtime      INTEGER NOT NULL,
+		checksum   TEXT NOT NULL,
+		checked_at DATETIME NOT NULL,
+		UNIQUE(store_name, store_ns, image)
+	);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure image_checksums table: %w", err)
+	}
+	return nil
+}
+
+// ensureImageConversionsTable creates the image_conversions table if it
+// doesn't exist. The declared checksum of an image is the one of its
+// source: once pull converted the source to qcow2, the file in the store
+// is checked against the checksum it had right after the conversion.
+func ensureImageConversionsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS image_conversions (
+		store_name      TEXT NOT NULL,
+		store_ns        TEXT NOT NULL DEFAULT '',
+		image           TEXT NOT NULL,
+		source_checksum TEXT NOT NULL,
+		checksum        TEXT NOT NULL,
+		converted_at    DATETIME NOT NULL,
+		UNIQUE(store_name, store_ns, image)
+	);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure image_conversions table: %w", err)
+	}
+	return nil
+}
+
+// RecordConversion records the checksum of the file pull converted an
+// image to, checksum being empty when the file is the source itself.
+func RecordConversion(session registry.Session, store *registry.Object, image *Image, checksum string) error {
+	if err := ensureImageConversionsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	if checksum == "" {
+		const query = `DELETE FROM image_conversions WHERE store_name = ? AND store_ns = ? AND image = ?`
+		if _, err := session.DB.ExecContext(session.Ctx, query, store.Name, store.Namespace, image.Name); err != nil {
+			return fmt.Errorf("forget conversion of image %q: %w", image.Name, err)
+		}
+		return nil
+	}
+	const upsert = `
+	INSERT INTO image_conversions (store_name, store_ns, image, source_checksum, checksum, converted_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(store_name, store_ns, image) DO UPDATE SET
+		source_checksum = excluded.source_checksum, checksum = excluded.checksum,
+		converted_at = excluded.converted_at
+	`
+	if _, err := session.DB.ExecContext(session.Ctx, upsert,
+		store.Name, store.Namespace, image.Name,
+		strings.ToLower(image.Checksum), checksum, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("record conversion of image %q: %w", image.Name, err)
+	}
+	return nil
+}
+
+// expectedChecksum returns the checksum the file of an image must have:
+// the declared one, or the one recorded when pull converted a source
+// matching the declared one.
+func expectedChecksum(session registry.Session, store *registry.Object, image *Image) (string, error) {
+	declared := strings.ToLower(image.Checksum)
+	if err := ensureImageConversionsTable(session.Ctx, session.DB); err != nil {
+		return "", err
+	}
+	const query = `
+	SELECT checksum FROM image_conversions
+	WHERE store_name = ? AND store_ns = ? AND image = ? AND source_checksum = ?
+	`
+	var converted string
+	err := session.DB.QueryRowContext(session.Ctx, query,
+		store.Name, store.Namespace, image.Name, declared,
+	).Scan(&converted)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return declared, nil
+	case err != nil:
+		return "", fmt.Errorf("get conversion of image %q: %w", image.Name, err)
+	}
+	return converted, nil
+}
+
+// VerifyImage checks that the file of an image can back overlays: it
+// exists, is a qcow2 image with a virtual size, and matches the declared
+// checksum. Errors tell how to fix the image.
+func VerifyImage(session registry.Session, store *registry.Object, image *Image) (*ImageCheck, error) {
+	check := &ImageCheck{Image: *image, Path: ImagePath(store, image), Checksum: "unchecked"}
+
+	stat, err := os.Stat(check.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if image.Source != "" {
+			return check, fmt.Errorf(
+				"image %q of store %q is missing at %s, pull it with: kvmcli image pull %s %s",
+				image.Name, store.Name, check.Path, store.Name, image.Name,
+			)
+		}
+		return check, fmt.Errorf(
+			"image %q of store %q is missing at %s, copy it there or give the image a source",
+			image.Name, store.Name, check.Path,
+		)
+	}
+	if err != nil {
+		return check, fmt.Errorf("image %q: %w", image.Name, err)
+	}
+	if stat.IsDir() {
+		return check, fmt.Errorf("image %q: %s is a directory, not an image file", image.Name, check.Path)
+	}
+	check.FileSize = stat.Size()
+
+	// Base images are read as qcow2, the format is only probed to explain
+	// why a file is not one
+	info, err := imageInfo(session.Ctx, check.Path, "qcow2")
+	if err != nil {
+		if probed, probeErr := imageInfo(session.Ctx, check.Path, ""); probeErr == nil && probed.Format != "qcow2" {
+			check.Format = probed.Format
+			return check, fmt.Errorf(
+				"image %q at %s is %s, base images must be qcow2: convert it with "+
+					"qemu-img convert -O qcow2 %s <file>.qcow2",
+				image.Name, check.Path, probed.Format, check.Path,
+			)
+		}
+		return check, fmt.Errorf("image %q: %w", image.Name, err)
+	}
+	check.Format = info.Format
+	check.VirtualSize = info.VirtualSize
+	if info.BackingFilename != "" {
+		return check, fmt.Errorf(
+			"image %q at %s has a backing file (%s), base images must be standalone",
+			image.Name, check.Path, info.BackingFilename,
+		)
+	}
+	if info.VirtualSize <= 0 {
+		return check, fmt.Errorf("image %q at %s has no virtual size, the file is corrupt", image.Name, check.Path)
+	}
+
+	if image.Checksum == "" {
+		return check, nil
+	}
+	actual, err := cachedChecksum(session, store, image, check.Path, stat)
+	if err != nil {
+		return check, fmt.Errorf("image %q: %w", image.Name, err)
+	}
+	expected, err := expectedChecksum(session, store, image)
+	if err != nil {
+		return check, err
+	}
+	if actual != expected {
+		check.Checksum = "mismatch"
+		hint := "replace the file"
+		if image.Source != "" {
+			hint = fmt.Sprintf("pull it again with: kvmcli image pull --force %s %s", store.Name, image.Name)
+		}
+		return check, fmt.Errorf(
+			"image %q at %s does not match its checksum (expected %s, got %s), %s",
+			image.Name, check.Path, expected, actual, hint,
+		)
+	}
+	check.Checksum = "ok"
+	return check, nil
+}
+
+// cachedChecksum returns the checksum of an image file, computed with the
+// algorithm of the declared checksum. The cached one is reused while the
+// size and mtime of the file are unchanged.
+func cachedChecksum(
+	session registry.Session,
+	store *registry.Object,
+	image *Image,
+	path string,
+	stat os.FileInfo,
+) (string, error) {
+	if err := ensureImageChecksumsTable(session.Ctx, session.DB); err != nil {
+		return "", err
+	}
+	algorithm, _, _ := strings.Cut(image.Checksum, ":")
+
+	const query = `
+	SELECT checksum FROM image_checksums
+	WHERE store_name = ? AND store_ns = ? AND image = ?
+	  AND path = ? AND size = ? AND mtime = ?
+	`
+	var cached string
+	err := session.DB.QueryRowContext(session.Ctx, query,
+		store.Name, store.Namespace, image.Name, path, stat.Size(), stat.ModTime().UnixNano(),
+	).Scan(&cached)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("get cached checksum: %w", err)
+	}
+	if strings.HasPrefix(cached, algorithm+":") {
+		return cached, nil
+	}
+
+	actual, err := fileChecksum(path, image.Checksum)
+	if err != nil {
+		return "", err
+	}
+	const upsert = `
+	INSERT INTO image_checksums (store_name, store_ns, image, path, size, mtime, checksum, checked_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(store_name, store_ns, image) DO UPDATE SET
+		path = excluded.path, size = excluded.size, mtime = excluded.mtime,
+		checksum = excluded.checksum, checked_at = excluded.checked_at
+	`
+	if _, err := session.DB.ExecContext(session.Ctx, upsert,
+		store.Name, store.Namespace, image.Name, path,
+		stat.Size(), stat.ModTime().UnixNano(), actual, time.Now().UTC(),
+	); err != nil {
+		return "", fmt.Errorf("cache checksum: %w", err)
+	}
+	return actual, nil
+}
+
+// forgetChecksums drops the cached and converted checksums of the images
+// of a store.
+func forgetChecksums(session registry.Session, store *registry.Object) error {
+	if err := ensureImageChecksumsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	if err := ensureImageConversionsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	for _, table := range []string{"image_checksums", "image_conversions"} {
+		query := `DELETE FROM ` + table + ` WHERE store_name = ? AND store_ns = ?`
+		if _, err := session.DB.ExecContext(session.Ctx, query, store.Name, store.Namespace); err != nil {
+			return fmt.Errorf("delete cached checksums of store %q: %w", store.Name, err)
+		}
+	}
+	return nil
+}
+
+// ChecksumStatus tells whether an image file matches its checksum from
+// the cache alone, without hashing it: ok, mismatch, missing, none when
+// no checksum is declared, or unverified when the file was never hashed
+// or changed since.
+func ChecksumStatus(session registry.Session, store *registry.Object, image *Image) (string, error) {
+	stat, err := os.Stat(ImagePath(store, image))
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("image %q: %w", image.Name, err)
+	}
+	if image.Checksum == "" {
+		return "none", nil
+	}
+	if err := ensureImageChecksumsTable(session.Ctx, session.DB); err != nil {
+		return "", err
+	}
+
+	const query = `
+	SELECT checksum FROM image_checksums
+	WHERE store_name = ? AND store_ns = ? AND image = ?
+	  AND path = ? AND size = ? AND mtime = ?
+	`
+	var cached string
+	err = session.DB.QueryRowContext(session.Ctx, query,
+		store.Name, store.Namespace, image.Name, ImagePath(store, image),
+		stat.Size(), stat.ModTime().UnixNano(),
+	).Scan(&cached)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "unverified", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get cached checksum: %w", err)
+	}
+	expected, err := expectedChecksum(session, store, image)
+	if err != nil {
+		return "", err
+	}
+	algorithm, _, _ := strings.Cut(image.Checksum, ":")
+	switch {
+	case !strings.HasPrefix(cached, algorithm+":"):
+		return "unverified", nil
+	case cached == expected:
+		return "ok", nil
+	default:
+		return "mismatch", nil
+	}
+}
+
+// BackingFile returns the backing file of a disk, or "" for a standalone
+// disk.
+func BackingFile(ctx context.Context, path string) (string, error) {
+	info, err := imageInfo(ctx, path, "")
+	if err != nil {
+		return "", err
+	}
+	return info.BackingFilename, nil
+}
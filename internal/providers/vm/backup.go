@@ -0,0 +1,457 @@
+package vm
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
+)
+
+// backupVersion is the version of the sidecar layout. Restore refuses
+// sidecars of another version.
+const backupVersion = 1
+
+// backupSuffix marks the uncompressed disk files libvirt writes during
+// the backup of a running VM. They are compressed once the job is done.
+const backupSuffix = ".backup.part"
+
+// Backup is the sidecar of a VM backup: the VM as stored in state, its
+// domain definition and the disk files of the backup, next to the sidecar.
+type Backup struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Live backups are taken while the VM runs: the disks are crash
+	// consistent, like after a power loss.
+	Live      bool            `json:"live"`
+	VM        registry.Object `json:"vm"`
+	DomainXML string          `json:"domain_xml"`
+	Disks     []BackupDisk    `json:"disks"`
+}
+
+// BackupDisk is one disk of a backup.
+type BackupDisk struct {
+	// Name of the extra disk, empty for the root disk
+	Name string `json:"name,omitempty"`
+	// Path of the disk when it was backed up
+	Path string `json:"path"`
+	// File of the backup, relative to the sidecar
+	File string `json:"file"`
+}
+
+// RestoreOptions describes the VM to create from a backup. Empty fields
+// keep the values of the backed up VM.
+type RestoreOptions struct {
+	Name      string
+	Namespace string
+	Store     string
+	// NewAddresses gives the VM new addresses and MACs, for a copy of a
+	// VM that still exists.
+	NewAddresses bool
+}
+
+// BackupVM writes a backup of a VM to dir: a compressed, standalone qcow2
+// file per disk and a JSON sidecar, <vm>-<time>.json. A running VM is
+// backed up with a libvirt push backup job, a stopped one with qemu-img.
+// It returns the path of the sidecar.
+func BackupVM(session registry.Session, spec *registry.Object, dir string) (string, error) {
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return "", fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	active, err := session.Conn.DomainIsActive(dom)
+	if err != nil {
+		return "", fmt.Errorf("get state of domain %q: %w", spec.Name, err)
+	}
+	domainXML, err := session.Conn.DomainGetXMLDesc(dom, libvirt.DomainXMLInactive)
+	if err != nil {
+		return "", fmt.Errorf("get XML of domain %q: %w", spec.Name, err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create %q: %w", dir, err)
+	}
+
+	backup := Backup{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+		Live:      active == 1,
+		VM:        *spec,
+		DomainXML: domainXML,
+	}
+	prefix := fmt.Sprintf("%s-%s", spec.Name, backup.CreatedAt.Format("20060102-150405"))
+	backup.Disks = append(backup.Disks, BackupDisk{
+		Path: spec.GetString("disk_path"),
+		File: prefix + ".qcow2",
+	})
+	for _, disk := range dataDisks(spec) {
+		backup.Disks = append(backup.Disks, BackupDisk{
+			Name: disk.Name,
+			Path: disk.Path,
+			File: fmt.Sprintf("%s-%s.qcow2", prefix, disk.Name),
+		})
+	}
+
+	if backup.Live {
+		err = backupLive(session, dom, backup.Disks, dir)
+	} else {
+		for _, disk := range backup.Disks {
+			if err = compressDisk(session.Ctx, disk.Path, filepath.Join(dir, disk.File)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		removeBackupFiles(backup.Disks, dir)
+		return "", fmt.Errorf("backup vm %q: %w", spec.Name, err)
+	}
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		removeBackupFiles(backup.Disks, dir)
+		return "", fmt.Errorf("encode backup of vm %q: %w", spec.Name, err)
+	}
+	sidecar := filepath.Join(dir, prefix+".json")
+	if err := os.WriteFile(sidecar, data, 0o644); err != nil {
+		removeBackupFiles(backup.Disks, dir)
+		return "", fmt.Errorf("write %q: %w", sidecar, err)
+	}
+	return sidecar, nil
+}
+
+// backupLive runs a push backup job of a running VM: libvirt writes the
+// current content of each disk to a file while the guest keeps running.
+// The files are then compressed.
+func backupLive(session registry.Session, dom libvirt.Domain, disks []BackupDisk, dir string) error {
+	liveXML, err := session.Conn.DomainGetXMLDesc(dom, 0)
+	if err != nil {
+		return fmt.Errorf("get XML of domain %q: %w", dom.Name, err)
+	}
+	var definition templates.Domain
+	if err := xml.Unmarshal([]byte(liveXML), &definition); err != nil {
+		return fmt.Errorf("parse XML of domain %q: %w", dom.Name, err)
+	}
+
+	files := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		files[disk.Path] = filepath.Join(dir, disk.File)
+	}
+	job := templates.NewPushBackup()
+	for _, disk := range definition.Devices.Disks {
+		// ISOs and other disks kvmcli doesn't manage are left out
+		if file, ok := files[disk.Source.File]; ok && disk.Device == "disk" {
+			job.AddDisk(disk.Target.Dev, file+backupSuffix)
+		} else {
+			job.SkipDisk(disk.Target.Dev)
+		}
+	}
+	jobXML, err := job.GenerateXML()
+	if err != nil {
+		return fmt.Errorf("generate backup XML: %w", err)
+	}
+
+	if err := session.Conn.DomainBackupBegin(dom, string(jobXML), nil, 0); err != nil {
+		return fmt.Errorf("start backup of domain %q: %w", dom.Name, err)
+	}
+	err = poll(session.Ctx, func() (bool, error) {
+		jobType, _, _, _, _, _, _, _, _, _, _, _, err := session.Conn.DomainGetJobInfo(dom)
+		if err != nil {
+			return false, fmt.Errorf("get backup job of domain %q: %w", dom.Name, err)
+		}
+		return libvirt.DomainJobType(jobType) == libvirt.DomainJobNone, nil
+	})
+	if err != nil {
+		if abortErr := session.Conn.DomainAbortJob(dom); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("abort backup of domain %q: %w", dom.Name, abortErr))
+		}
+		removeBackupParts(files)
+		return err
+	}
+	jobType, _, err := session.Conn.DomainGetJobStats(dom, libvirt.DomainJobStatsCompleted)
+	if err == nil && libvirt.DomainJobType(jobType) != libvirt.DomainJobCompleted {
+		err = fmt.Errorf("backup job of domain %q did not complete", dom.Name)
+	}
+	if err != nil {
+		removeBackupParts(files)
+		return err
+	}
+
+	defer removeBackupParts(files)
+	for _, file := range files {
+		if err := compressDisk(session.Ctx, file+backupSuffix, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeBackupParts(files map[string]string) {
+	for _, file := range files {
+		_ = os.Remove(file + backupSuffix)
+	}
+}
+
+// removeBackupFiles deletes the disk files of a failed backup.
+func removeBackupFiles(disks []BackupDisk, dir string) {
+	for _, disk := range disks {
+		_ = os.Remove(filepath.Join(dir, disk.File))
+	}
+}
+
+// ReadBackup loads the sidecar of a backup.
+func ReadBackup(path string) (*Backup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read backup: %w", err)
+	}
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("parse backup %q: %w", path, err)
+	}
+	if backup.Version != backupVersion {
+		return nil, fmt.Errorf("backup %q has version %d, expected %d", path, backup.Version, backupVersion)
+	}
+	if backup.VM.TypeName != "vm" || len(backup.Disks) == 0 {
+		return nil, fmt.Errorf("backup %q is not a VM backup", path)
+	}
+	return &backup, nil
+}
+
+// Restore creates a VM from a backup whose sidecar is in dir, and returns
+// it, ready to be saved. The disks are copied into the images_path of the
+// store, uncompressed and standalone, and the domain is defined from the
+// backed up XML. The VM keeps its addresses, with their DHCP reservations
+// and DNS records registered again, unless opts.NewAddresses is set.
+func Restore(
+	session registry.Session,
+	backup *Backup,
+	dir string,
+	opts RestoreOptions,
+) (*registry.Object, error) {
+	restored, err := restoreSpec(session, backup, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := session.Conn.DomainLookupByName(restored.Name); err == nil {
+		return nil, fmt.Errorf("a libvirt domain named %q already exists", restored.Name)
+	}
+
+	storeName := restored.GetString("store")
+	storeObj, err := database.NewDBHandler(session.DB).
+		Get(session.Ctx, "store", storeName, restored.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get store %q: %w", storeName, err)
+	}
+	if storeObj == nil {
+		return nil, fmt.Errorf("store %q not found", storeName)
+	}
+	imagesPath := storeObj.GetString("images_path")
+
+	// The backup files of the disks by name, empty for the root disk
+	files := make(map[string]string, len(backup.Disks))
+	for _, disk := range backup.Disks {
+		files[disk.Name] = filepath.Join(dir, disk.File)
+	}
+	diskPath := filepath.Join(imagesPath, restored.Name+".qcow2")
+	diskMap := map[string]string{backup.VM.GetString("disk_path"): diskPath}
+	sourceDisks := dataDisks(&backup.VM)
+	disks := dataDisks(&backup.VM)
+	for index := range disks {
+		disk := &disks[index]
+		disk.Image = ""
+		disk.Path = filepath.Join(imagesPath, dataDiskFile(restored.Name, *disk))
+		diskMap[sourceDisks[index].Path] = disk.Path
+	}
+	for _, path := range append([]string{diskPath}, diskPaths(disks)...) {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("disk %q already exists", path)
+		}
+	}
+	for _, name := range append([]string{""}, diskNames(disks)...) {
+		if _, ok := files[name]; !ok {
+			return nil, fmt.Errorf("backup has no file for disk %q", name)
+		}
+	}
+
+	if err := checkDiskFiles(session, restored); err != nil {
+		return nil, err
+	}
+	if err := recoverRun(session, restored); err != nil {
+		return nil, err
+	}
+	ifaces, err := resolveInterfaces(session, restored)
+	if err != nil {
+		return nil, fmt.Errorf("resolve host addresses for %q: %w", restored.Name, err)
+	}
+
+	var domain libvirt.Domain
+	steps := []transaction.Step{
+		allocationStep(session, restored, ifaces),
+		{
+			Name: "overlay",
+			Do: func() (string, error) {
+				return diskPath, convertDisk(session.Ctx, files[""], diskPath, "qcow2")
+			},
+			Undo: deleteOverlay,
+		},
+		{
+			Name: "data-disks",
+			Do: func() (string, error) {
+				for index, disk := range disks {
+					if err := convertDisk(session.Ctx, files[disk.Name], disk.Path, disk.Format); err != nil {
+						removeDataDisks(disks[:index], false)
+						return "", fmt.Errorf("disk %q: %w", disk.Name, err)
+					}
+				}
+				return encodeStepData(diskPaths(disks))
+			},
+			Undo: undoDataDisks,
+		},
+		{
+			Name: "domain",
+			Do: func() (string, error) {
+				domainXML, err := rewriteDomainXML(backup.DomainXML, domainIdentity{
+					Name:       restored.Name,
+					Interfaces: ifaces,
+					Disks:      diskMap,
+				})
+				if err != nil {
+					return "", err
+				}
+				domain, err = session.Conn.DomainDefineXML(domainXML)
+				if err != nil {
+					return "", fmt.Errorf("define domain %q: %w", restored.Name, err)
+				}
+				return restored.Name, nil
+			},
+			Undo: func(name string) error { return undefineDomain(session, name) },
+		},
+	}
+	steps = append(steps, addressSteps(session, ifaces)...)
+	forwardSteps, err := portForwardSteps(session, restored, ifaces)
+	if err != nil {
+		releaseAllocations(session, restored, ifaces)
+		return nil, err
+	}
+	steps = append(steps, forwardSteps...)
+	steps = append(steps, transaction.Step{
+		Name: "start",
+		Do: func() (string, error) {
+			return restored.Name, createDomain(session, domain)
+		},
+		Undo: func(name string) error { return stopDomain(session, name) },
+	})
+
+	err = transaction.NewRunner(session, restored).Run(steps)
+	refreshPool(session, diskPath)
+	if err != nil {
+		return nil, fmt.Errorf("restore vm %q: %w", restored.Name, err)
+	}
+
+	restored.Attrs["ip"] = ifaces[0].IP
+	restored.Attrs["ipv6"] = ifaces[0].IPv6
+	restored.Attrs["mac_address"] = ifaces[0].MAC
+	restored.Attrs["interfaces"] = interfaceAttrs(ifaces)
+	restored.Attrs["disk_path"] = diskPath
+	restored.Attrs["disks"] = diskAttrs(disks)
+	restored.Status = "running"
+
+	// The restored disks are standalone
+	if err := store.RecordBackings(session, restored, storeName, BackingImages(restored)); err != nil {
+		logger.Warnf("vm %q: %v", restored.Name, err)
+	}
+	return restored, nil
+}
+
+// restoreSpec copies the VM of a backup for a restore: same settings, on
+// standalone disks, with its own addresses or new ones.
+func restoreSpec(
+	session registry.Session,
+	backup *Backup,
+	opts RestoreOptions,
+) (*registry.Object, error) {
+	source := &backup.VM
+	data, err := json.Marshal(source.Attrs)
+	if err != nil {
+		return nil, fmt.Errorf("copy attributes of %q: %w", source.Name, err)
+	}
+	restored := &registry.Object{
+		TypeName:  source.TypeName,
+		Name:      source.Name,
+		Namespace: source.Namespace,
+		Labels:    source.Labels,
+	}
+	if err := json.Unmarshal(data, &restored.Attrs); err != nil {
+		return nil, fmt.Errorf("copy attributes of %q: %w", source.Name, err)
+	}
+	if opts.Name != "" {
+		restored.Name = opts.Name
+	}
+	if opts.Namespace != "" {
+		restored.Namespace = opts.Namespace
+	}
+	if opts.Store != "" {
+		restored.Attrs["store"] = opts.Store
+	}
+
+	if opts.NewAddresses {
+		if err := resetAddresses(session, restored, ""); err != nil {
+			return nil, err
+		}
+		// Host ports can only be forwarded to one VM
+		if len(PortForwards(restored)) > 0 {
+			logger.Warnf("vm %q: port forwards of the backup are not restored", restored.Name)
+			delete(restored.Attrs, "port_forwards")
+		}
+	} else if err := checkAddressesFree(session, restored); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"disk_path", "backing_files"} {
+		delete(restored.Attrs, key)
+	}
+	restored.Attrs["base_image"] = ""
+	return restored, nil
+}
+
+// checkAddressesFree fails when another VM in state holds an address of
+// a VM about to be restored with its own addresses.
+func checkAddressesFree(session registry.Session, spec *registry.Object) error {
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+	for _, iface := range Interfaces(spec) {
+		for _, object := range vms {
+			if object.Name == spec.Name && object.Namespace == spec.Namespace {
+				continue
+			}
+			for _, other := range Interfaces(&object) {
+				if (iface.IP != "" && iface.IP == other.IP) || (iface.IPv6 != "" && iface.IPv6 == other.IPv6) {
+					return fmt.Errorf(
+						"vm %s/%s holds an address of the backup, restore with new addresses",
+						object.Namespace, object.Name,
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func diskNames(disks []dataDisk) []string {
+	names := make([]string, 0, len(disks))
+	for _, disk := range disks {
+		names = append(names, disk.Name)
+	}
+	return names
+}
@@ -0,0 +1,454 @@
+package vm
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/network"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
+)
+
+// CloneOptions describes the VM to create from a source VM.
+type CloneOptions struct {
+	Name      string
+	Namespace string
+	// IP of the primary interface; allocated from the network if empty.
+	IP string
+	// Linked clones share the current disk of the source as a read-only
+	// base; full clones get a standalone copy of it.
+	Linked bool
+}
+
+// Clone creates a new VM from a stopped source VM and returns it, ready
+// to be saved. The clone gets new addresses and MACs, its own DHCP
+// reservations, and a domain defined from the source XML.
+//
+// A linked clone turns the current disk of the source into a base shared
+// by both VMs: the source gets a fresh overlay on top of it, at its old
+// path. The bases a VM depends on are recorded in its backing_files, the
+// source is updated in place. Extra disks are always copied.
+func Clone(session registry.Session, source *registry.Object, opts CloneOptions) (*registry.Object, error) {
+	dom, err := session.Conn.DomainLookupByName(source.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup domain %q: %w", source.Name, err)
+	}
+	state, _, err := session.Conn.DomainGetState(dom, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get state of domain %q: %w", source.Name, err)
+	}
+	if libvirt.DomainState(state) != libvirt.DomainShutoff {
+		return nil, fmt.Errorf(
+			"vm %q is %s, stop it before cloning it",
+			source.Name,
+			domainStateName(libvirt.DomainState(state)),
+		)
+	}
+	if _, err := session.Conn.DomainLookupByName(opts.Name); err == nil {
+		return nil, fmt.Errorf("a libvirt domain named %q already exists", opts.Name)
+	}
+	if opts.Linked {
+		// Internal snapshots live in the disk that becomes the base
+		snaps, _, err := session.Conn.DomainListAllSnapshots(dom, 1, 0)
+		if err == nil && len(snaps) > 0 {
+			return nil, fmt.Errorf(
+				"vm %q has snapshots, make a full clone or delete them first",
+				source.Name,
+			)
+		}
+	}
+	sourceXML, err := session.Conn.DomainGetXMLDesc(dom, libvirt.DomainXMLInactive)
+	if err != nil {
+		return nil, fmt.Errorf("get XML of domain %q: %w", source.Name, err)
+	}
+
+	clone, err := cloneSpec(session, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDiskFiles(session, clone); err != nil {
+		return nil, err
+	}
+	if err := recoverRun(session, clone); err != nil {
+		return nil, err
+	}
+	ifaces, err := resolveInterfaces(session, clone)
+	if err != nil {
+		return nil, fmt.Errorf("resolve host addresses for %q: %w", clone.Name, err)
+	}
+
+	sourceDisk := source.GetString("disk_path")
+	imagesPath := filepath.Dir(sourceDisk)
+	diskPath := filepath.Join(imagesPath, clone.Name+".qcow2")
+	basePath := filepath.Join(
+		imagesPath,
+		fmt.Sprintf("%s-base-%s.qcow2", source.Name, time.Now().Format("20060102-150405")),
+	)
+
+	sourceDisks := dataDisks(source)
+	disks := dataDisks(source)
+	diskMap := map[string]string{sourceDisk: diskPath}
+	for index := range disks {
+		disk := &disks[index]
+		disk.Path = filepath.Join(imagesPath, dataDiskFile(clone.Name, *disk))
+		diskMap[sourceDisks[index].Path] = disk.Path
+	}
+
+	steps := []transaction.Step{allocationStep(session, clone, ifaces)}
+	if opts.Linked {
+		steps = append(steps,
+			transaction.Step{
+				// The current disk of the source becomes the shared base
+				Name: "base",
+				Do: func() (string, error) {
+					if err := os.Rename(sourceDisk, basePath); err != nil {
+						return "", fmt.Errorf("move disk of %q: %w", source.Name, err)
+					}
+					return encodeStepData([2]string{sourceDisk, basePath})
+				},
+				Undo: undoRename,
+			},
+			transaction.Step{
+				// The source keeps its disk path, on top of the base
+				Name: "source-overlay",
+				Do: func() (string, error) {
+					return sourceDisk, createOverlay(session, basePath, sourceDisk)
+				},
+				Undo: deleteOverlay,
+			},
+			transaction.Step{
+				Name: "overlay",
+				Do: func() (string, error) {
+					return diskPath, createOverlay(session, basePath, diskPath)
+				},
+				Undo: deleteOverlay,
+			},
+		)
+	} else {
+		steps = append(steps, transaction.Step{
+			Name: "overlay",
+			Do: func() (string, error) {
+				return diskPath, convertDisk(session.Ctx, sourceDisk, diskPath, "qcow2")
+			},
+			Undo: deleteOverlay,
+		})
+	}
+
+	var domain libvirt.Domain
+	steps = append(steps,
+		transaction.Step{
+			Name: "data-disks",
+			Do: func() (string, error) {
+				for index, disk := range disks {
+					original := sourceDisks[index].Path
+					if err := convertDisk(session.Ctx, original, disk.Path, disk.Format); err != nil {
+						removeDataDisks(disks[:index], false)
+						return "", fmt.Errorf("disk %q: %w", disk.Name, err)
+					}
+				}
+				return encodeStepData(diskPaths(disks))
+			},
+			Undo: undoDataDisks,
+		},
+		transaction.Step{
+			Name: "domain",
+			Do: func() (string, error) {
+				domainXML, err := rewriteDomainXML(sourceXML, domainIdentity{
+					Name:       clone.Name,
+					Interfaces: ifaces,
+					Disks:      diskMap,
+				})
+				if err != nil {
+					return "", err
+				}
+				domain, err = session.Conn.DomainDefineXML(domainXML)
+				if err != nil {
+					return "", fmt.Errorf("define domain %q: %w", clone.Name, err)
+				}
+				return clone.Name, nil
+			},
+			Undo: func(name string) error { return undefineDomain(session, name) },
+		},
+	)
+	steps = append(steps, addressSteps(session, ifaces)...)
+	steps = append(steps, transaction.Step{
+		Name: "start",
+		Do: func() (string, error) {
+			return clone.Name, createDomain(session, domain)
+		},
+		Undo: func(name string) error { return stopDomain(session, name) },
+	})
+
+	err = transaction.NewRunner(session, clone).Run(steps)
+	refreshPool(session, diskPath)
+	if err != nil {
+		return nil, fmt.Errorf("clone vm %q to %q: %w", source.Name, clone.Name, err)
+	}
+
+	clone.Attrs["ip"] = ifaces[0].IP
+	clone.Attrs["ipv6"] = ifaces[0].IPv6
+	clone.Attrs["mac_address"] = ifaces[0].MAC
+	clone.Attrs["interfaces"] = interfaceAttrs(ifaces)
+	clone.Attrs["disk_path"] = diskPath
+	clone.Attrs["disks"] = diskAttrs(disks)
+	if opts.Linked {
+		// Bases stack up when a source is cloned again
+		bases := append(registry.AsStrings(source.Attrs["backing_files"]), basePath)
+		clone.Attrs["backing_files"] = bases
+		source.Attrs["backing_files"] = bases
+	}
+	clone.Status = "running"
+
+	// A linked clone reads through the image of the source
+	if err := store.RecordBackings(session, clone, clone.GetString("store"), BackingImages(clone)); err != nil {
+		logger.Warnf("vm %q: %v", clone.Name, err)
+	}
+	return clone, nil
+}
+
+// cloneSpec copies the object of the source VM for a clone: same
+// settings, no addresses, disks or port forwards of its own yet. MACs
+// are derived from the new IPs; interfaces without an IPv4 to derive one
+// from get a random MAC.
+func cloneSpec(
+	session registry.Session,
+	source *registry.Object,
+	opts CloneOptions,
+) (*registry.Object, error) {
+	data, err := json.Marshal(source.Attrs)
+	if err != nil {
+		return nil, fmt.Errorf("copy attributes of %q: %w", source.Name, err)
+	}
+	clone := &registry.Object{
+		TypeName:  source.TypeName,
+		Name:      opts.Name,
+		Namespace: opts.Namespace,
+		Labels:    source.Labels,
+	}
+	if err := json.Unmarshal(data, &clone.Attrs); err != nil {
+		return nil, fmt.Errorf("copy attributes of %q: %w", source.Name, err)
+	}
+
+	if err := resetAddresses(session, clone, opts.IP); err != nil {
+		return nil, err
+	}
+
+	// Host ports can only be forwarded to one VM
+	if len(PortForwards(source)) > 0 {
+		logger.Warnf("vm %q: port forwards of %q are not cloned", opts.Name, source.Name)
+	}
+	for _, key := range []string{"port_forwards", "disk_path", "backing_files"} {
+		delete(clone.Attrs, key)
+	}
+	clone.Attrs["cloned_from"] = source.Namespace + "/" + source.Name
+	return clone, nil
+}
+
+// resetAddresses drops the addresses and MACs of the interfaces of a
+// copy of a VM. The primary interface gets ip, if set. Interfaces left
+// without an IPv4 the network can allocate get a random MAC.
+func resetAddresses(session registry.Session, spec *registry.Object, ip string) error {
+	ifaces := Interfaces(spec)
+	for index := range ifaces {
+		iface := &ifaces[index]
+		iface.IP, iface.IPv6, iface.MAC = "", "", ""
+		if index == 0 {
+			iface.IP = ip
+		}
+		netObj, err := network.Lookup(session, iface.Network, spec.Namespace)
+		if err != nil {
+			return fmt.Errorf("interface %d: %w", index, err)
+		}
+		if iface.IP == "" && !(network.ManagesAddresses(netObj) && network.HasIPv4(netObj)) {
+			mac, err := network.RandomMAC()
+			if err != nil {
+				return err
+			}
+			iface.MAC = mac.String()
+		}
+	}
+	spec.Attrs["interfaces"] = interfaceAttrs(ifaces)
+	return nil
+}
+
+// releaseBackingFiles deletes the bases linked clones shared, once the
+// last VM depending on them is destroyed.
+func releaseBackingFiles(session registry.Session, spec *registry.Object) error {
+	bases := registry.AsStrings(spec.Attrs["backing_files"])
+	if len(bases) == 0 {
+		return nil
+	}
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+	inUse := make(map[string]bool)
+	for _, object := range vms {
+		if object.Name == spec.Name && object.Namespace == spec.Namespace {
+			continue
+		}
+		for _, base := range registry.AsStrings(object.Attrs["backing_files"]) {
+			inUse[base] = true
+		}
+	}
+
+	var errs []error
+	for _, base := range bases {
+		if !inUse[base] {
+			errs = append(errs, deleteOverlay(base))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func undoRename(data string) error {
+	var paths [2]string
+	if err := json.Unmarshal([]byte(data), &paths); err != nil {
+		return fmt.Errorf("decode paths: %w", err)
+	}
+	if err := os.Rename(paths[1], paths[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("move %q back: %w", paths[1], err)
+	}
+	return nil
+}
+
+// domainIdentity is what a clone changes in the XML of its source
+// domain: its name, the MAC (and filter IP) of each interface, in order,
+// and the files of its disks.
+type domainIdentity struct {
+	Name       string
+	Interfaces []Interface
+	Disks      map[string]string
+}
+
+// rewriteDomainXML rewrites the identity of a domain XML. The UUID,
+// NVRAM file and interface device names are dropped, libvirt generates
+// new ones. Everything else of the source domain is kept as is.
+func rewriteDomainXML(source string, id domainIdentity) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(source))
+	var out strings.Builder
+	encoder := xml.NewEncoder(&out)
+
+	var path []string
+	skip := 0   // > 0 inside a dropped element
+	iface := -1 // index of the current <interface>
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("parse domain XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			t = rawStart(t)
+			path = append(path, t.Name.Local)
+			if skip > 0 {
+				skip++
+				continue
+			}
+			switch strings.Join(path, "/") {
+			case "domain/uuid", "domain/os/nvram", "domain/devices/interface/target":
+				skip = 1
+				continue
+			case "domain/devices/interface":
+				iface++
+			case "domain/devices/interface/mac":
+				if iface < len(id.Interfaces) {
+					setAttr(&t, "address", id.Interfaces[iface].MAC)
+				}
+			case "domain/devices/interface/filterref/parameter":
+				if iface < len(id.Interfaces) && attr(t, "name") == "IP" &&
+					id.Interfaces[iface].IP != "" {
+					setAttr(&t, "value", id.Interfaces[iface].IP)
+				}
+			case "domain/devices/disk/source":
+				if file, ok := id.Disks[attr(t, "file")]; ok {
+					setAttr(&t, "file", file)
+				}
+			}
+			token = t
+		case xml.EndElement:
+			t.Name = xml.Name{Local: rawName(t.Name)}
+			path = path[:len(path)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			token = t
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			if strings.Join(path, "/") == "domain/name" {
+				token = xml.CharData(id.Name)
+			}
+		case xml.ProcInst:
+			// The header is written again with the document
+			continue
+		default:
+			if skip > 0 {
+				continue
+			}
+		}
+		if err := encoder.EncodeToken(token); err != nil {
+			return "", fmt.Errorf("write domain XML: %w", err)
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return "", fmt.Errorf("write domain XML: %w", err)
+	}
+	return xml.Header + out.String(), nil
+}
+
+// rawName keeps the namespace prefix of a raw token (qemu:commandline)
+// in the name, so it is written back unchanged.
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func rawStart(t xml.StartElement) xml.StartElement {
+	start := xml.StartElement{Name: xml.Name{Local: rawName(t.Name)}}
+	for _, a := range t.Attr {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: rawName(a.Name)},
+			Value: a.Value,
+		})
+	}
+	return start
+}
+
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func setAttr(t *xml.StartElement, name, value string) {
+	for index := range t.Attr {
+		if t.Attr[index].Name.Local == name {
+			t.Attr[index].Value = value
+			return
+		}
+	}
+	t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
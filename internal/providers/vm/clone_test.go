@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteDomainXML(t *testing.T) {
+	const source = `<domain type="kvm">
+  <name>web</name>
+  <uuid>0b2b4a3c-7c39-4d3e-9a51-55d8f1f0d0a1</uuid>
+  <os>
+    <type arch="x86_64">hvm</type>
+    <nvram>/var/lib/libvirt/qemu/nvram/web_VARS.fd</nvram>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="/images/web.qcow2"/>
+      <target dev="vda" bus="virtio"/>
+    </disk>
+    <disk type="file" device="disk">
+      <source file="/images/web-data.qcow2"/>
+      <target dev="vdb" bus="virtio"/>
+    </disk>
+    <disk type="file" device="cdrom">
+      <source file="/images/seed.iso"/>
+    </disk>
+    <interface type="network">
+      <mac address="02:aa:c0:a8:0a:02"/>
+      <source network="lab"/>
+      <target dev="vnet0"/>
+      <filterref filter="clean-traffic">
+        <parameter name="IP" value="192.168.10.2"/>
+      </filterref>
+    </interface>
+    <interface type="network">
+      <mac address="02:aa:0a:00:00:02"/>
+      <source network="backend"/>
+    </interface>
+  </devices>
+  <qemu:commandline xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0">
+    <qemu:arg value="-no-hpet"/>
+  </qemu:commandline>
+</domain>`
+
+	// domain holds what rewriteDomainXML changes, and what it must keep
+	type domain struct {
+		Name  string `xml:"name"`
+		UUID  string `xml:"uuid"`
+		NVRAM string `xml:"os>nvram"`
+		Disks []struct {
+			Source struct {
+				File string `xml:"file,attr"`
+			} `xml:"source"`
+		} `xml:"devices>disk"`
+		Interfaces []struct {
+			MAC struct {
+				Address string `xml:"address,attr"`
+			} `xml:"mac"`
+			Target *struct{} `xml:"target"`
+			Filter []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value,attr"`
+			} `xml:"filterref>parameter"`
+		} `xml:"devices>interface"`
+	}
+
+	tests := []struct {
+		name string
+		id   domainIdentity
+		// files are the disk sources expected in the rewritten XML
+		files []string
+	}{
+		{
+			name: "new identity",
+			id: domainIdentity{
+				Name: "web-clone",
+				Interfaces: []Interface{
+					{MAC: "02:aa:c0:a8:0a:03", IP: "192.168.10.3"},
+					{MAC: "02:aa:0a:00:00:03", IP: "10.0.0.3"},
+				},
+				Disks: map[string]string{
+					"/images/web.qcow2":      "/images/web-clone.qcow2",
+					"/images/web-data.qcow2": "/images/web-clone-data.qcow2",
+				},
+			},
+			files: []string{"/images/web-clone.qcow2", "/images/web-clone-data.qcow2", "/images/seed.iso"},
+		},
+		{
+			name: "interface without IPv4 keeps the filter IP",
+			id: domainIdentity{
+				Name: "web-clone",
+				Interfaces: []Interface{
+					{MAC: "02:aa:c0:a8:0a:03"},
+					{MAC: "02:aa:0a:00:00:03"},
+				},
+			},
+			files: []string{"/images/web.qcow2", "/images/web-data.qcow2", "/images/seed.iso"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := rewriteDomainXML(source, test.id)
+			if err != nil {
+				t.Fatalf("rewriteDomainXML() error = %v", err)
+			}
+			var got domain
+			if err := xml.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("rewritten XML does not parse: %v\n%s", err, out)
+			}
+
+			if got.Name != test.id.Name {
+				t.Errorf("name = %q, want %q", got.Name, test.id.Name)
+			}
+			if got.UUID != "" || got.NVRAM != "" {
+				t.Errorf("uuid %q and nvram %q kept, want them dropped", got.UUID, got.NVRAM)
+			}
+			var files []string
+			for _, disk := range got.Disks {
+				files = append(files, disk.Source.File)
+			}
+			if !reflect.DeepEqual(files, test.files) {
+				t.Errorf("disk files = %v, want %v", files, test.files)
+			}
+			if len(got.Interfaces) != len(test.id.Interfaces) {
+				t.Fatalf("%d interfaces, want %d", len(got.Interfaces), len(test.id.Interfaces))
+			}
+			for index, iface := range got.Interfaces {
+				if iface.MAC.Address != test.id.Interfaces[index].MAC {
+					t.Errorf("interface %d: mac = %s, want %s", index, iface.MAC.Address, test.id.Interfaces[index].MAC)
+				}
+				if iface.Target != nil {
+					t.Errorf("interface %d: target device kept, want it dropped", index)
+				}
+			}
+			wantIP := test.id.Interfaces[0].IP
+			if wantIP == "" {
+				wantIP = "192.168.10.2"
+			}
+			if filter := got.Interfaces[0].Filter; len(filter) != 1 || filter[0].Value != wantIP {
+				t.Errorf("filter parameters = %+v, want IP %s", filter, wantIP)
+			}
+			if !strings.Contains(out, `<qemu:arg value="-no-hpet">`) {
+				t.Errorf("qemu:commandline not kept as is:\n%s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Address discovery sources, in the order they are queried.
+var discoverySources = []struct {
+	name   string
+	source libvirt.DomainInterfaceAddressesSource
+}{
+	{"lease", libvirt.DomainInterfaceAddressesSrcLease},
+	{"arp", libvirt.DomainInterfaceAddressesSrcArp},
+	{"agent", libvirt.DomainInterfaceAddressesSrcAgent},
+}
+
+// DiscoveredAddress is an address a guest actually uses, as seen by
+// libvirt through DHCP leases, the host ARP table or the guest agent.
+type DiscoveredAddress struct {
+	MAC     string
+	IP      string
+	Sources []string
+}
+
+// DiscoverAddresses queries every discovery source for the addresses of
+// a running VM. Sources that are not available (no guest agent, VM not
+// started ...) are skipped. Loopback and link-local addresses are left out.
+func DiscoverAddresses(session registry.Session, name string) ([]DiscoveredAddress, error) {
+	dom, err := session.Conn.DomainLookupByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup domain %q: %w", name, err)
+	}
+
+	var found []DiscoveredAddress
+	index := make(map[string]int)
+	for _, source := range discoverySources {
+		ifaces, err := session.Conn.DomainInterfaceAddresses(dom, uint32(source.source), 0)
+		if err != nil {
+			continue
+		}
+		for _, iface := range ifaces {
+			mac := ""
+			if len(iface.Hwaddr) > 0 {
+				mac = strings.ToLower(iface.Hwaddr[0])
+			}
+			for _, addr := range iface.Addrs {
+				ip := net.ParseIP(addr.Addr)
+				if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+					continue
+				}
+				key := mac + "/" + ip.String()
+				if position, ok := index[key]; ok {
+					found[position].Sources = append(found[position].Sources, source.name)
+					continue
+				}
+				index[key] = len(found)
+				found = append(found, DiscoveredAddress{
+					MAC:     mac,
+					IP:      ip.String(),
+					Sources: []string{source.name},
+				})
+			}
+		}
+	}
+	return found, nil
+}
+
+// Mismatches compares the addresses reserved for the interfaces of a VM
+// with the discovered ones. An interface whose MAC was seen with
+// addresses of a family, none of them the reserved one, is reported.
+func Mismatches(spec *registry.Object, discovered []DiscoveredAddress) []string {
+	var mismatches []string
+	for index, iface := range Interfaces(spec) {
+		for _, reserved := range []string{iface.IP, iface.IPv6} {
+			if reserved == "" {
+				continue
+			}
+			reservedIP := net.ParseIP(reserved)
+			if reservedIP == nil {
+				continue
+			}
+			reserved = reservedIP.String()
+			wantIPv4 := reservedIP.To4() != nil
+
+			var got []string
+			for _, addr := range discovered {
+				if addr.MAC != strings.ToLower(iface.MAC) {
+					continue
+				}
+				if (net.ParseIP(addr.IP).To4() != nil) == wantIPv4 {
+					got = append(got, addr.IP)
+				}
+			}
+			if len(got) > 0 && !slices.Contains(got, reserved) {
+				mismatches = append(mismatches, fmt.Sprintf(
+					"interface %d: reserved %s, got %s",
+					index,
+					reserved,
+					strings.Join(got, ","),
+				))
+			}
+		}
+	}
+	return mismatches
+}
+
+// wideFormat shows the addresses the guest actually uses next to the
+// reserved ones, as "ip (sources)".
+func wideFormat(session registry.Session, object registry.Object) []string {
+	discovered, err := DiscoverAddresses(session, object.Name)
+	if err != nil {
+		return []string{object.GetString("mac_address"), "-", "-"}
+	}
+
+	addresses := make([]string, 0, len(discovered))
+	for _, addr := range discovered {
+		addresses = append(addresses, fmt.Sprintf("%s (%s)", addr.IP, strings.Join(addr.Sources, ",")))
+	}
+	mismatch := strings.Join(Mismatches(&object, discovered), "; ")
+	if len(addresses) == 0 {
+		addresses = append(addresses, "-")
+	}
+	if mismatch == "" {
+		mismatch = "-"
+	}
+	return []string{object.GetString("mac_address"), strings.Join(addresses, " "), mismatch}
+}
@@ -2,25 +2,57 @@ package vm
 
 import (
 	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/zakariakebairia/kvmcli/internal/config"
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
 )
 
 var QemuImgBinary = "qemu-img"
 
-func createOverlay(ctx context.Context, src, dest string) error {
+// DiskDefaults holds the global [disk] settings used for extra disks
+// that don't set their own bus or format. The session overrides it with
+// the loaded kvmcli.toml values.
+var DiskDefaults = config.DefaultGlobalConfig().Disk
+
+// dataDisk is an extra disk attached to a VM, next to the root overlay.
+type dataDisk struct {
+	Name   string
+	Size   string
+	Bus    string
+	Format string
+	Cache  string
+	Image  string
+	Keep   bool
+	// Computed at provisioning time
+	Path   string
+	Target string
+}
+
+// createOverlay creates dest as a qcow2 overlay of the image at src. In a
+// store directory managed by a libvirt pool it is created as a volume of
+// the pool, anywhere else with qemu-img.
+func createOverlay(session registry.Session, src, dest string) error {
+	if inPool, err := store.CreateOverlayVolume(session, src, dest); inPool {
+		return err
+	}
 	args := []string{
 		"create",
 		"-f", "qcow2",
 		"-o", fmt.Sprintf("backing_file=%s,backing_fmt=qcow2", src),
 		dest,
 	}
-	output, err := exec.CommandContext(ctx, QemuImgBinary, args...).CombinedOutput()
+	output, err := exec.CommandContext(session.Ctx, QemuImgBinary, args...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("create overlay failed: %w, %s ", err, output)
 	}
@@ -43,6 +75,85 @@ func resizeOverlay(ctx context.Context, dest, size string) error {
 	return nil
 }
 
+// convertDisk copies src to a standalone disk of the given format: the
+// backing chain of src is flattened into it.
+func convertDisk(ctx context.Context, src, dest, format string) error {
+	args := []string{
+		"convert",
+		"-O", format,
+		src,
+		dest,
+	}
+	output, err := exec.CommandContext(ctx, QemuImgBinary, args...).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(dest)
+		return fmt.Errorf("convert disk %q: %w: %s", src, err, output)
+	}
+	return nil
+}
+
+// compressDisk copies src to a standalone, compressed qcow2 disk. The
+// backing chain of src is flattened into it.
+func compressDisk(ctx context.Context, src, dest string) error {
+	args := []string{
+		"convert",
+		"-c",
+		"-O", "qcow2",
+		src,
+		dest,
+	}
+	output, err := exec.CommandContext(ctx, QemuImgBinary, args...).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(dest)
+		return fmt.Errorf("compress disk %q: %w: %s", src, err, output)
+	}
+	return nil
+}
+
+// rebaseDisk moves a qcow2 disk onto another backing image. The rebase
+// is safe: whatever differs between the old and new chains is copied
+// into the disk, so the guest sees the same data.
+func rebaseDisk(ctx context.Context, path, backing string) error {
+	args := []string{
+		"rebase",
+		"-f", "qcow2",
+		"-F", "qcow2",
+		"-b", backing,
+		path,
+	}
+	output, err := exec.CommandContext(ctx, QemuImgBinary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rebase disk %q: %w: %s", path, err, output)
+	}
+	return nil
+}
+
+func createBlankDisk(ctx context.Context, dest, format, size string) error {
+	args := []string{
+		"create",
+		"-f", format,
+		dest,
+		size,
+	}
+	output, err := exec.CommandContext(ctx, QemuImgBinary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("create disk failed: %w, %s ", err, output)
+	}
+	return nil
+}
+
+// refreshPool rescans the pool holding path, once disks were created or
+// removed as plain files. A failed refresh only leaves the volume list of
+// libvirt stale.
+func refreshPool(session registry.Session, path string) {
+	if path == "" {
+		return
+	}
+	if err := store.RefreshPoolOf(session, path); err != nil {
+		logger.Warnf("%v", err)
+	}
+}
+
 func deleteOverlay(dest string) error {
 	// if file exist but remove process returns error, return that error
 	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
@@ -66,9 +177,265 @@ func provisionDisk(session registry.Session, spec *registry.Object) (string, err
 	src := filepath.Join(image.ArtifactsPath, image.ImageFile)
 	diskPath := filepath.Join(image.ImagesPath, spec.Name+".qcow2")
 
-	if err = createOverlay(session.Ctx, src, diskPath); err != nil {
+	if err = createOverlay(session, src, diskPath); err != nil {
 		return "", fmt.Errorf("create disk overlay: %w", err)
 	}
 
 	return diskPath, nil
 }
+
+// targetDev returns the guest device name for the disk at index,
+// e.g. prefix "vd" and index 1 gives "vdb".
+func targetDev(prefix string, index int) (string, error) {
+	if index < 0 || index > 'z'-'a' {
+		return "", fmt.Errorf("too many disks: no target name for index %d", index)
+	}
+	return prefix + string(rune('a'+index)), nil
+}
+
+// targetPrefix returns the prefix of the guest device names of a bus:
+// virtio disks use the configured target_prefix, libvirt names the
+// disks of the other buses after the kind of device they emulate.
+func targetPrefix(bus string) string {
+	switch bus {
+	case "sata", "scsi", "usb":
+		return "sd"
+	case "ide":
+		return "hd"
+	}
+	return DiskDefaults.TargetPrefix
+}
+
+// targetAllocator hands out the guest device names of the disks of a
+// VM, in order for each bus.
+type targetAllocator struct {
+	used map[string]bool
+	next map[string]int
+}
+
+// newTargetAllocator returns an allocator for the disks after the root
+// overlay, which is vda.
+func newTargetAllocator() *targetAllocator {
+	return &targetAllocator{
+		used: map[string]bool{templates.TargetDevVDA: true},
+		next: make(map[string]int),
+	}
+}
+
+// target returns the next free device name of the bus.
+func (a *targetAllocator) target(bus string) (string, error) {
+	prefix := targetPrefix(bus)
+	for {
+		target, err := targetDev(prefix, a.next[prefix])
+		if err != nil {
+			return "", err
+		}
+		a.next[prefix]++
+		if !a.used[target] {
+			a.used[target] = true
+			return target, nil
+		}
+	}
+}
+
+// dataDiskFile returns the file name of an extra disk, next to the root
+// overlay <vm>.qcow2 in the images_path of the store.
+func dataDiskFile(vmName string, disk dataDisk) string {
+	return fmt.Sprintf("%s-%s.%s", vmName, disk.Name, disk.Format)
+}
+
+// checkDiskFiles fails when the files of the disks of a VM and of a VM
+// in state share a name: the data disk "data" of VM "web" is
+// web-data.qcow2, the root overlay of a VM named "web-data".
+func checkDiskFiles(session registry.Session, spec *registry.Object) error {
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+	for _, object := range vms {
+		if object.Name == spec.Name && object.Namespace == spec.Namespace {
+			continue
+		}
+		for _, disk := range dataDisks(spec) {
+			if object.Name == spec.Name+"-"+disk.Name {
+				return fmt.Errorf(
+					"vm %q: the file of disk %q would be the disk of vm %s/%s, rename the disk",
+					spec.Name, disk.Name, object.Namespace, object.Name,
+				)
+			}
+		}
+		for _, disk := range dataDisks(&object) {
+			if spec.Name == object.Name+"-"+disk.Name {
+				return fmt.Errorf(
+					"vm %q: its disk would be the file of disk %q of vm %s/%s, rename the VM",
+					spec.Name, disk.Name, object.Namespace, object.Name,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// dataDisks reads the extra disks stored in the object attributes.
+func dataDisks(spec *registry.Object) []dataDisk {
+	items := spec.GetList("disks")
+	disks := make([]dataDisk, 0, len(items))
+	for _, item := range items {
+		disk := dataDisk{}
+		disk.Name, _ = item["name"].(string)
+		disk.Size, _ = item["size"].(string)
+		disk.Bus, _ = item["bus"].(string)
+		disk.Format, _ = item["format"].(string)
+		disk.Cache, _ = item["cache"].(string)
+		disk.Image, _ = item["image"].(string)
+		disk.Keep, _ = item["keep"].(bool)
+		disk.Path, _ = item["path"].(string)
+		disk.Target, _ = item["target"].(string)
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+// attrs converts a disk back to its stored form.
+func (d dataDisk) attrs() map[string]any {
+	return map[string]any{
+		"name":   d.Name,
+		"size":   d.Size,
+		"bus":    d.Bus,
+		"format": d.Format,
+		"cache":  d.Cache,
+		"image":  d.Image,
+		"keep":   d.Keep,
+		"path":   d.Path,
+		"target": d.Target,
+	}
+}
+
+// diskAttrs converts disks to their stored form.
+func diskAttrs(disks []dataDisk) []map[string]any {
+	items := make([]map[string]any, 0, len(disks))
+	for _, disk := range disks {
+		items = append(items, disk.attrs())
+	}
+	return items
+}
+
+// provisionDataDisks creates the extra disks of a VM next to its root
+// overlay. Disks with an image are qcow2 overlays backed by that image,
+// the others are blank volumes. On failure, disks created so far are removed.
+func provisionDataDisks(
+	session registry.Session,
+	spec *registry.Object,
+	imagesPath string,
+) ([]dataDisk, error) {
+	disks := dataDisks(spec)
+	targets := newTargetAllocator()
+	for index := range disks {
+		disk := &disks[index]
+		if disk.Bus == "" {
+			disk.Bus = DiskDefaults.Bus
+		}
+		if disk.Format == "" {
+			disk.Format = DiskDefaults.Format
+		}
+
+		// vda is the root overlay, extra virtio disks start at vdb
+		target, err := targets.target(disk.Bus)
+		if err != nil {
+			removeDataDisks(disks[:index], false)
+			return nil, fmt.Errorf("disk %q: %w", disk.Name, err)
+		}
+		disk.Target = target
+		disk.Path = filepath.Join(imagesPath, dataDiskFile(spec.Name, *disk))
+
+		if err := createDataDisk(session, spec, disk); err != nil {
+			removeDataDisks(disks[:index], false)
+			return nil, fmt.Errorf("disk %q: %w", disk.Name, err)
+		}
+	}
+	return disks, nil
+}
+
+func createDataDisk(session registry.Session, spec *registry.Object, disk *dataDisk) error {
+	if disk.Image == "" {
+		if disk.Size == "" {
+			return fmt.Errorf("size is required for a blank disk")
+		}
+		return createBlankDisk(session.Ctx, disk.Path, disk.Format, disk.Size)
+	}
+
+	if disk.Format != "qcow2" {
+		return fmt.Errorf("disks backed by an image must be qcow2, got %q", disk.Format)
+	}
+	image, err := getImage(session, spec.GetString("store"), disk.Image, spec.Namespace)
+	if err != nil {
+		return fmt.Errorf("lookup image: %w", err)
+	}
+	src := filepath.Join(image.ArtifactsPath, image.ImageFile)
+	if err := createOverlay(session, src, disk.Path); err != nil {
+		return err
+	}
+	if disk.Size != "" {
+		if err := resizeOverlay(session.Ctx, disk.Path, disk.Size); err != nil {
+			_ = deleteOverlay(disk.Path)
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDataDisks deletes the given disk files. When honorKeep is true,
+// disks marked with keep = true are left on disk.
+func removeDataDisks(disks []dataDisk, honorKeep bool) error {
+	var errs []error
+	for _, disk := range disks {
+		if disk.Path == "" || (honorKeep && disk.Keep) {
+			continue
+		}
+		if err := deleteOverlay(disk.Path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DiskFiles returns the files the disks of a VM in state use: its root
+// overlay, its extra disks and the bases of a linked clone.
+func DiskFiles(spec *registry.Object) []string {
+	var files []string
+	if diskPath := spec.GetString("disk_path"); diskPath != "" {
+		files = append(files, diskPath)
+	}
+	for _, disk := range dataDisks(spec) {
+		if disk.Path != "" {
+			files = append(files, disk.Path)
+		}
+	}
+	return append(files, registry.AsStrings(spec.Attrs["backing_files"])...)
+}
+
+// DomainDiskFiles returns the files the disks of every libvirt domain
+// use, including domains kvmcli doesn't manage.
+func DomainDiskFiles(session registry.Session) ([]string, error) {
+	domains, _, err := session.Conn.ConnectListAllDomains(1, 0)
+	if err != nil {
+		return nil, fmt.Errorf("list domains: %w", err)
+	}
+	var files []string
+	for _, dom := range domains {
+		domainXML, err := session.Conn.DomainGetXMLDesc(dom, 0)
+		if err != nil {
+			return nil, fmt.Errorf("get XML of domain %q: %w", dom.Name, err)
+		}
+		var definition templates.Domain
+		if err := xml.Unmarshal([]byte(domainXML), &definition); err != nil {
+			return nil, fmt.Errorf("parse XML of domain %q: %w", dom.Name, err)
+		}
+		for _, disk := range definition.Devices.Disks {
+			if disk.Source.File != "" {
+				files = append(files, disk.Source.File)
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTargetAllocator(t *testing.T) {
+	tests := []struct {
+		name  string
+		buses []string
+		want  []string
+	}{
+		{
+			name:  "virtio disks follow the root overlay",
+			buses: []string{"virtio", "virtio"},
+			want:  []string{"vdb", "vdc"},
+		},
+		{
+			name:  "sata and scsi disks share the sd names",
+			buses: []string{"sata", "scsi", "sata"},
+			want:  []string{"sda", "sdb", "sdc"},
+		},
+		{
+			name:  "ide disks",
+			buses: []string{"ide"},
+			want:  []string{"hda"},
+		},
+		{
+			name:  "each bus counts on its own",
+			buses: []string{"virtio", "sata", "virtio", "scsi"},
+			want:  []string{"vdb", "sda", "vdc", "sdb"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := newTargetAllocator()
+			var got []string
+			for _, bus := range test.buses {
+				target, err := targets.target(bus)
+				if err != nil {
+					t.Fatalf("target(%q) error = %v", bus, err)
+				}
+				got = append(got, target)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("targets = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTargetAllocatorRunsOut(t *testing.T) {
+	targets := newTargetAllocator()
+	// vda is the root overlay: vdb to vdz are left
+	for range 25 {
+		if _, err := targets.target("virtio"); err != nil {
+			t.Fatalf("target() error = %v", err)
+		}
+	}
+	if target, err := targets.target("virtio"); err == nil {
+		t.Errorf("target() = %q, want an error once vdz is used", target)
+	}
+}
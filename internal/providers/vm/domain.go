@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/digitalocean/go-libvirt"
-	"github.com/zakariakebairia/kvmcli/internal/providers/network"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
 	"github.com/zakariakebairia/kvmcli/internal/templates"
 )
@@ -37,20 +36,30 @@ func Stop(conn *libvirt.Libvirt, name string) error {
 // buildDomainXML generates the libvirt XML for a VM domain.
 func buildDomainXML(
 	spec *registry.Object,
-	diskPath, netName, macAddress, osProfile string,
+	diskPath, osProfile string,
+	disks []dataDisk,
+	ifaces []Interface,
 ) (string, error) {
 	cpu := spec.GetInt("cpu")
 	memory := spec.GetInt("memory")
 
+	primary := ifaces[0]
 	domain := templates.NewDomain(
 		spec.Name,
 		memory,
 		cpu,
 		diskPath,
-		netName,
-		macAddress,
+		primary.Network,
+		primary.MAC,
 		osProfile,
 	)
+	domain.Devices.Interfaces[0] = primary.device()
+	for _, iface := range ifaces[1:] {
+		domain.Devices.Interfaces = append(domain.Devices.Interfaces, iface.device())
+	}
+	for _, disk := range disks {
+		domain.AddDisk(disk.Path, disk.Target, disk.Bus, disk.Format, disk.Cache)
+	}
 
 	xmlConfig, err := domain.GenerateXML()
 	if err != nil {
@@ -64,17 +73,16 @@ func defineDomain(
 	session registry.Session,
 	spec *registry.Object,
 	diskPath string,
-	hostAddr *network.HostAddr,
+	disks []dataDisk,
+	ifaces []Interface,
 ) (domain libvirt.Domain, err error) {
-	// Get network name
-	networkName := spec.GetString("network")
 	// Build xml
 	xml, err := buildDomainXML(
 		spec,
 		diskPath,
-		networkName,
-		hostAddr.MAC.String(),
 		"https://rockylinux.org/rocky/9",
+		disks,
+		ifaces,
 	)
 	if err != nil {
 		return domain, fmt.Errorf("build XML: %w", err)
@@ -0,0 +1,175 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Flatten makes the disks of a VM standalone: their backing chains are
+// merged into them, so the VM no longer depends on an image of its store
+// or on the bases of a linked clone. A running VM is flattened with a
+// libvirt block pull, a stopped one with qemu-img convert. The disks keep
+// their paths. The VM is updated in place, ready to be saved.
+func Flatten(session registry.Session, spec *registry.Object) error {
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	if err := checkNoSnapshots(session, dom, "flatten"); err != nil {
+		return err
+	}
+	active, err := session.Conn.DomainIsActive(dom)
+	if err != nil {
+		return fmt.Errorf("get state of domain %q: %w", spec.Name, err)
+	}
+
+	disks := dataDisks(spec)
+	paths := []string{spec.GetString("disk_path")}
+	for _, disk := range disks {
+		paths = append(paths, disk.Path)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		backing, err := store.BackingFile(session.Ctx, path)
+		if err != nil {
+			return fmt.Errorf("vm %q: %w", spec.Name, err)
+		}
+		if backing == "" {
+			continue
+		}
+		if active == 1 {
+			err = blockPull(session, dom, path)
+		} else {
+			err = flattenDisk(session, path)
+		}
+		if err != nil {
+			return fmt.Errorf("vm %q: %w", spec.Name, err)
+		}
+	}
+
+	// The bases of a linked clone are released like on destroy
+	if err := releaseBackingFiles(session, spec); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+	delete(spec.Attrs, "backing_files")
+	for index := range disks {
+		disks[index].Image = ""
+	}
+	spec.Attrs["disks"] = diskAttrs(disks)
+	spec.Attrs["base_image"] = ""
+
+	if err := store.RecordBackings(session, spec, spec.GetString("store"), BackingImages(spec)); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+	refreshPool(session, spec.GetString("disk_path"))
+	return nil
+}
+
+// Rebase moves the root disk of a stopped VM onto another image of its
+// store. The rebase is safe: the data that differs between the old and
+// new backing chains is copied into the disk, so the guest sees the same
+// disk. The image of the manifest is left as is, the image the disk is
+// now an overlay of is recorded in base_image. The VM is updated in
+// place, ready to be saved.
+func Rebase(session registry.Session, spec *registry.Object, imageName string) error {
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	if err := checkNoSnapshots(session, dom, "rebase"); err != nil {
+		return err
+	}
+	active, err := session.Conn.DomainIsActive(dom)
+	if err != nil {
+		return fmt.Errorf("get state of domain %q: %w", spec.Name, err)
+	}
+	// libvirt block jobs can only pull or commit a chain, not replace
+	// its base with another image
+	if active == 1 {
+		return fmt.Errorf("vm %q is running, stop it before rebasing its disk", spec.Name)
+	}
+
+	diskPath := spec.GetString("disk_path")
+	if diskPath == "" {
+		return fmt.Errorf("vm %q has no disk", spec.Name)
+	}
+	image, err := getImage(session, spec.GetString("store"), imageName, spec.Namespace)
+	if err != nil {
+		return fmt.Errorf("lookup image: %w", err)
+	}
+	if err := rebaseDisk(session.Ctx, diskPath, filepath.Join(image.ArtifactsPath, image.ImageFile)); err != nil {
+		return fmt.Errorf("vm %q: %w", spec.Name, err)
+	}
+
+	if err := releaseBackingFiles(session, spec); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+	delete(spec.Attrs, "backing_files")
+	spec.Attrs["base_image"] = imageName
+
+	if err := store.RecordBackings(session, spec, spec.GetString("store"), BackingImages(spec)); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+	refreshPool(session, diskPath)
+	return nil
+}
+
+// checkNoSnapshots refuses to rewrite the disks of a VM with snapshots:
+// internal snapshots live in the disks.
+func checkNoSnapshots(session registry.Session, dom libvirt.Domain, action string) error {
+	snaps, _, err := session.Conn.DomainListAllSnapshots(dom, 1, 0)
+	if err != nil {
+		return fmt.Errorf("list snapshots of domain %q: %w", dom.Name, err)
+	}
+	if len(snaps) > 0 {
+		return fmt.Errorf("vm %q has snapshots, delete them before you %s its disks", dom.Name, action)
+	}
+	return nil
+}
+
+// flattenDisk replaces the disk at path, of a stopped VM, with a
+// standalone copy of it.
+func flattenDisk(session registry.Session, path string) error {
+	partial := path + store.FlattenSuffix
+	if err := convertDisk(session.Ctx, path, partial, "qcow2"); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("replace disk %q: %w", path, err)
+	}
+	return nil
+}
+
+// blockPull merges the backing chain of the disk at path into it while
+// the VM runs, and waits for the block job to complete. An interrupted
+// wait aborts the job: the disk stays valid, still backed by its chain.
+func blockPull(session registry.Session, dom libvirt.Domain, path string) error {
+	if err := session.Conn.DomainBlockPull(dom, path, 0, 0); err != nil {
+		return fmt.Errorf("start block pull of %q: %w", path, err)
+	}
+	err := poll(session.Ctx, func() (bool, error) {
+		found, _, _, _, _, err := session.Conn.DomainGetBlockJobInfo(dom, path, 0)
+		if err != nil {
+			return false, fmt.Errorf("get block job of %q: %w", path, err)
+		}
+		// The job is gone once the chain is merged
+		return found == 0, nil
+	})
+	if err != nil {
+		if abortErr := session.Conn.DomainBlockJobAbort(dom, path, 0); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("abort block pull of %q: %w", path, abortErr))
+		}
+		return fmt.Errorf("block pull of %q: %w", path, err)
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package vm
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
 )
 
@@ -19,29 +21,81 @@ type Image struct {
 //
 // FIX: What if the store and other object are in different namespaces
 
+// getImage looks up an image of a store and verifies its file can back
+// an overlay.
 func getImage(session registry.Session, storeName, imageName, nameSpace string) (*Image, error) {
 	dbHandler := database.NewDBHandler(session.DB)
-	store, err := dbHandler.Get(session.Ctx, "store", storeName, nameSpace)
+	object, err := dbHandler.Get(session.Ctx, "store", storeName, nameSpace)
 	if err != nil {
 		return nil, fmt.Errorf("list stores: %w", err)
 	}
+	if object == nil {
+		return nil, fmt.Errorf("store %q not found", storeName)
+	}
 
-	images := store.Attrs["images"].([]any)
-	for _, raw := range images {
+	image, err := store.FindImage(object, imageName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := store.VerifyImage(session, object, image); err != nil {
+		return nil, err
+	}
+	return &Image{
+		ArtifactsPath: object.GetString("artifacts_path"),
+		ImagesPath:    object.GetString("images_path"),
+		ImageFile:     image.File,
+		OsProfile:     image.OSProfile,
+	}, nil
+}
 
-		img, ok := raw.(map[string]any)
-		if !ok {
-			continue
+// BackingImages returns the images of its store the disks of a VM are
+// overlays of. The disks of a full clone are standalone copies, those of
+// a linked clone share the root image through their base. Once the root
+// disk was flattened or rebased, base_image holds its image, empty for a
+// standalone disk.
+func BackingImages(spec *registry.Object) []string {
+	cloned := spec.GetString("cloned_from") != ""
+	linked := len(registry.AsStrings(spec.Attrs["backing_files"])) > 0
+
+	var images []string
+	if base, ok := spec.Attrs["base_image"].(string); ok {
+		if base != "" {
+			images = append(images, base)
 		}
-		if img["name"] == imageName {
-			return &Image{
-				ArtifactsPath: store.Attrs["artifacts_path"].(string),
-				ImagesPath:    store.Attrs["images_path"].(string),
-				ImageFile:     img["file"].(string),
-				OsProfile:     img["os_profile"].(string),
-			}, nil
+	} else if image := spec.GetString("image"); image != "" && (!cloned || linked) {
+		images = append(images, image)
+	}
+	if cloned {
+		return images
+	}
+	for _, disk := range dataDisks(spec) {
+		if disk.Image != "" && !slices.Contains(images, disk.Image) {
+			images = append(images, disk.Image)
 		}
 	}
+	return images
+}
 
-	return nil, fmt.Errorf("image %q not found in any store", imageName)
+// RecordMissingBackings records the image backings of the VMs in state
+// that have none recorded, like VMs created before backings were tracked.
+func RecordMissingBackings(session registry.Session) error {
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+	tracked, err := store.TrackedVMs(session)
+	if err != nil {
+		return err
+	}
+	for index := range vms {
+		spec := &vms[index]
+		images := BackingImages(spec)
+		if tracked[spec.Namespace+"/"+spec.Name] || len(images) == 0 {
+			continue
+		}
+		if err := store.RecordBackings(session, spec, spec.GetString("store"), images); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,319 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/zakariakebairia/kvmcli/internal/config"
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/providers/network"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// NetworkDefaults holds the global [network] settings used for interfaces
+// that don't set their own model. The session overrides it with the
+// loaded kvmcli.toml values.
+var NetworkDefaults = config.DefaultGlobalConfig().Network
+
+// Interface is one network interface of a VM.
+type Interface struct {
+	Network string
+	IP      string
+	IPv6    string
+	MAC     string
+	Model   string
+	// MTU is 0 and Bandwidth nil when unset.
+	MTU       int
+	Bandwidth *templates.Bandwidth
+	// Filter is the libvirt nwfilter attached to the interface, its IP
+	// parameter defaults to the interface address.
+	Filter       string
+	FilterParams map[string]string
+	// Computed by resolveInterfaces
+	addr     *network.HostAddr
+	managed  bool
+	hostname string
+	// allocated is set when IPAM allocated the IP for this apply
+	allocated bool
+}
+
+// Interfaces reads the interfaces stored in the object attributes.
+// Objects saved before interface blocks existed only carry the
+// network/ip/mac_address attributes, which form a single interface.
+func Interfaces(spec *registry.Object) []Interface {
+	items := spec.GetList("interfaces")
+	if len(items) == 0 && spec.GetString("network") != "" {
+		return []Interface{{
+			Network: spec.GetString("network"),
+			IP:      spec.GetString("ip"),
+			MAC:     spec.GetString("mac_address"),
+		}}
+	}
+
+	ifaces := make([]Interface, 0, len(items))
+	for _, item := range items {
+		ifaces = append(ifaces, interfaceFromAttrs(item))
+	}
+	return ifaces
+}
+
+// interfaceFromAttrs reads one interface from its stored form.
+func interfaceFromAttrs(item map[string]any) Interface {
+	iface := Interface{}
+	iface.Network, _ = item["network"].(string)
+	iface.IP, _ = item["ip"].(string)
+	iface.IPv6, _ = item["ipv6"].(string)
+	iface.MAC, _ = item["mac"].(string)
+	iface.Model, _ = item["model"].(string)
+	iface.MTU = registry.AsInt(item["mtu"])
+	iface.Bandwidth = network.BandwidthFromAttrs(item["bandwidth"])
+	iface.Filter, _ = item["filter"].(string)
+	if params, ok := item["filter_params"].(map[string]any); ok {
+		iface.FilterParams = make(map[string]string, len(params))
+		for name, value := range params {
+			iface.FilterParams[name], _ = value.(string)
+		}
+	} else if params, ok := item["filter_params"].(map[string]string); ok {
+		iface.FilterParams = params
+	}
+	return iface
+}
+
+// attrs converts an interface back to its stored form.
+func (i Interface) attrs() map[string]any {
+	attrs := map[string]any{
+		"network": i.Network,
+		"ip":      i.IP,
+		"ipv6":    i.IPv6,
+		"mac":     i.MAC,
+		"model":   i.Model,
+	}
+	if i.MTU != 0 {
+		attrs["mtu"] = i.MTU
+	}
+	if i.Bandwidth != nil {
+		attrs["bandwidth"] = network.BandwidthAttrs(i.Bandwidth)
+	}
+	if i.Filter != "" {
+		attrs["filter"] = i.Filter
+	}
+	if len(i.FilterParams) > 0 {
+		attrs["filter_params"] = i.FilterParams
+	}
+	return attrs
+}
+
+// device returns the libvirt <interface> of an interface.
+func (i Interface) device() templates.Interface {
+	device := templates.NewInterface(i.Network, i.MAC, i.Model)
+	if i.MTU != 0 {
+		device.MTU = &templates.MTU{Size: i.MTU}
+	}
+	device.Bandwidth = i.Bandwidth
+	if i.Filter != "" {
+		device.FilterRef = i.filterRef()
+	}
+	return device
+}
+
+// filterRef returns the <filterref> of the interface. Filters such as
+// clean-traffic use the IP variable, it is set to the interface address
+// unless filter_params sets it.
+func (i Interface) filterRef() *templates.FilterRef {
+	params := maps.Clone(i.FilterParams)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	if _, ok := params["IP"]; !ok && i.IP != "" {
+		params["IP"] = i.IP
+	}
+
+	ref := &templates.FilterRef{Filter: i.Filter}
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		ref.Parameters = append(ref.Parameters, templates.FilterParameter{
+			Name:  name,
+			Value: params[name],
+		})
+	}
+	return ref
+}
+
+// resolveInterfaces resolves the L2/L3 identity of every interface.
+// Interfaces without an IP get one from the network IPAM when the network
+// has an IPv4 subnet. If no MAC is provided, one is derived
+// deterministically from the IPv4 address, or else from the IPv6 one.
+//
+// Bridge and macvtap networks have no libvirt managed subnet: their
+// addresses are neither allocated, validated nor reserved.
+func resolveInterfaces(session registry.Session, spec *registry.Object) ([]Interface, error) {
+	ifaces := Interfaces(spec)
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("no network interface defined")
+	}
+
+	netObjs := make([]*registry.Object, len(ifaces))
+	for index := range ifaces {
+		iface := &ifaces[index]
+		netObj, err := network.Lookup(session, iface.Network, spec.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("interface %d: %w", index, err)
+		}
+		netObjs[index] = netObj
+		iface.managed = network.ManagesAddresses(netObj)
+		if iface.managed {
+			iface.hostname = network.Hostname(netObj, spec.Name)
+		}
+	}
+
+	if err := allocateAddresses(session, spec, ifaces, netObjs); err != nil {
+		releaseAllocations(session, spec, ifaces)
+		return nil, err
+	}
+	for index := range ifaces {
+		iface := &ifaces[index]
+		addr, err := resolveAddr(*iface)
+		if err != nil {
+			releaseAllocations(session, spec, ifaces)
+			return nil, fmt.Errorf("interface %d on %q: %w", index, iface.Network, err)
+		}
+		if iface.managed && addr.IP != nil {
+			if err := network.ValidateHostIP(netObjs[index], addr.IP); err != nil {
+				releaseAllocations(session, spec, ifaces)
+				return nil, fmt.Errorf("interface %d: %w", index, err)
+			}
+		}
+		if iface.managed && addr.IPv6 != nil {
+			if err := network.ValidateHostIPv6(netObjs[index], addr.IPv6); err != nil {
+				releaseAllocations(session, spec, ifaces)
+				return nil, fmt.Errorf("interface %d: %w", index, err)
+			}
+		}
+		if iface.Model == "" {
+			iface.Model = NetworkDefaults.Model
+		}
+		// DHCPv6 reservations carry the host name
+		addr.Name = spec.Name
+		iface.MAC = addr.MAC.String()
+		iface.addr = addr
+	}
+	return ifaces, nil
+}
+
+// resolveAddr returns the L2/L3 identity of an interface: its IPv4 and/or
+// IPv6 address and its MAC. An interface without any address (unmanaged
+// network, or IPv6 left to SLAAC) needs a MAC.
+func resolveAddr(iface Interface) (*network.HostAddr, error) {
+	var addr *network.HostAddr
+	var err error
+	switch {
+	case iface.IP != "":
+		addr, err = network.ResolveL2L3Pair(iface.IP, iface.MAC)
+		if err == nil && addr.IP == nil {
+			return nil, fmt.Errorf("ip %q is not an IPv4 address, use ipv6", iface.IP)
+		}
+	case iface.IPv6 != "":
+		addr, err = network.ResolveL2L3Pair(iface.IPv6, iface.MAC)
+	case iface.MAC != "":
+		var mac net.HardwareAddr
+		mac, err = net.ParseMAC(iface.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address: %w", err)
+		}
+		addr = &network.HostAddr{MAC: mac}
+	default:
+		return nil, fmt.Errorf("set ip, ipv6 or mac on network %q", iface.Network)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if iface.IPv6 != "" {
+		ipv6 := net.ParseIP(iface.IPv6)
+		if ipv6 == nil || ipv6.To4() != nil {
+			return nil, fmt.Errorf("ipv6 %q is not an IPv6 address", iface.IPv6)
+		}
+		addr.IPv6 = ipv6
+	}
+	return addr, nil
+}
+
+// allocateAddresses fills in the IP of interfaces that don't set one.
+func allocateAddresses(
+	session registry.Session,
+	spec *registry.Object,
+	ifaces []Interface,
+	netObjs []*registry.Object,
+) error {
+	var taken map[string]bool
+	for index := range ifaces {
+		iface := &ifaces[index]
+		if iface.IP != "" || !iface.managed || !network.HasIPv4(netObjs[index]) {
+			continue
+		}
+
+		if taken == nil {
+			var err error
+			if taken, err = addressesInUse(session, spec, ifaces); err != nil {
+				return err
+			}
+		}
+		ip, allocated, err := network.AllocateIP(
+			session,
+			netObjs[index],
+			spec.Name,
+			spec.Namespace,
+			index,
+			taken,
+		)
+		if err != nil {
+			return fmt.Errorf("interface %d: allocate address: %w", index, err)
+		}
+		iface.IP = ip.String()
+		iface.allocated = allocated
+		taken[iface.IP] = true
+	}
+	return nil
+}
+
+// addressesInUse returns the IPs held by other VMs in state and the
+// static IPs of this VM.
+func addressesInUse(
+	session registry.Session,
+	spec *registry.Object,
+	ifaces []Interface,
+) (map[string]bool, error) {
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return nil, fmt.Errorf("list vms: %w", err)
+	}
+
+	taken := make(map[string]bool)
+	for _, object := range vms {
+		if object.Name == spec.Name && object.Namespace == spec.Namespace {
+			continue
+		}
+		for _, iface := range Interfaces(&object) {
+			if iface.IP != "" {
+				taken[iface.IP] = true
+			}
+		}
+	}
+	for _, iface := range ifaces {
+		if iface.IP != "" {
+			taken[iface.IP] = true
+		}
+	}
+	return taken, nil
+}
+
+// interfaceAttrs converts interfaces to their stored form.
+func interfaceAttrs(ifaces []Interface) []map[string]any {
+	items := make([]map[string]any, 0, len(ifaces))
+	for _, iface := range ifaces {
+		items = append(items, iface.attrs())
+	}
+	return items
+}
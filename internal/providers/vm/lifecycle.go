@@ -2,9 +2,14 @@ package vm
 
 import (
 	"fmt"
+	"path/filepath"
 
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
 	"github.com/zakariakebairia/kvmcli/internal/providers/network"
+	"github.com/zakariakebairia/kvmcli/internal/providers/store"
 	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
 )
 
 // type MACAddress = net.HardwareAddr
@@ -12,7 +17,7 @@ import (
 func init() {
 	registry.Register(&registry.ResourceType{
 		Name:      "vm",
-		DependsOn: []string{"network", "store"},
+		DependsOn: []string{"network", "store", "firewall"},
 		Lifecycle: &VMLifecycle{},
 		Columns:   []string{"NAME", "NAMESPACE", "CPU", "RAM", "IP", "IMAGE", "STATUS"},
 		Format: func(object registry.Object) []string {
@@ -26,6 +31,8 @@ func init() {
 				object.Status,
 			}
 		},
+		WideColumns: []string{"MAC", "ADDRESSES", "MISMATCH"},
+		WideFormat:  wideFormat,
 	})
 }
 
@@ -41,64 +48,123 @@ func (l *VMLifecycle) Plan(desired, current *registry.Object) (registry.Action,
 	}
 
 	if current != nil && desired != nil {
+		if !vmChanged(current, desired) {
+			return registry.ActionNone, nil
+		}
 		return registry.ActionUpdate, nil
 	}
 	return registry.ActionNone, nil
 }
 
-func (vm *VMLifecycle) Apply(session registry.Session, change registry.Change) (err error) {
-	// rollback is a LIFO stack of cleanup functions.
-	// It runs automatically on any error via the deferred func below.
-	var rollback []func()
-	defer func() {
-		if err != nil {
-			for i := len(rollback) - 1; i >= 0; i-- {
-				rollback[i]()
-			}
-		}
-	}()
-
+func (vm *VMLifecycle) Apply(session registry.Session, change registry.Change) error {
 	spec := change.Desired
+	if change.Action == registry.ActionUpdate {
+		return updateInPlace(session, change)
+	}
 
-	// Resolve the host's L2/L3 identity (IP + MAC).
-	// If no MAC is provided, one is derived deterministically from the IP.
-	hostAddr, err := network.ResolveL2L3Pair(
-		spec.GetString("ip"),
-		spec.GetString("mac_address"),
-	)
-	if err != nil {
-		return fmt.Errorf("resolve host addresses for %q: %w", spec.Name, err)
+	if err := checkDiskFiles(session, spec); err != nil {
+		return err
+	}
+	if err := recoverRun(session, spec); err != nil {
+		return err
 	}
 
-	// Provision a qcow2 overlay disk backed by the specified image.
-	diskPath, err := provisionDisk(session, spec)
+	// Resolve the L2/L3 identity (IP + MAC) of every interface.
+	// Missing IPs are allocated from the network, missing MACs derived from the IP.
+	ifaces, err := resolveInterfaces(session, spec)
 	if err != nil {
-		rollback = append(rollback, func() { deleteOverlay(diskPath) })
-		return fmt.Errorf("provision disk: %w", err)
+		return fmt.Errorf("resolve host addresses for %q: %w", spec.Name, err)
 	}
 
-	// Define the libvirt domain (registers the VM, does not start it).
-	domain, err := defineDomain(session, spec, diskPath, hostAddr)
-	if err != nil {
-		rollback = append(rollback, func() { session.Conn.DomainUndefineFlags(domain, 0) })
-		return fmt.Errorf("define domain %q: %w", spec.Name, err)
+	var (
+		diskPath string
+		disks    []dataDisk
+		domain   libvirt.Domain
+	)
+
+	// Each step registers its compensation as soon as it succeeds,
+	// the runner undoes completed steps in reverse order on failure.
+	steps := []transaction.Step{
+		allocationStep(session, spec, ifaces),
+		{
+			// Provision a qcow2 overlay disk backed by the specified image.
+			Name: "overlay",
+			Do: func() (string, error) {
+				diskPath, err = provisionDisk(session, spec)
+				return diskPath, err
+			},
+			Undo: deleteOverlay,
+		},
+		{
+			// Provision the extra disks declared with disk "<name>" {} blocks.
+			Name: "data-disks",
+			Do: func() (string, error) {
+				disks, err = provisionDataDisks(session, spec, filepath.Dir(diskPath))
+				if err != nil {
+					return "", err
+				}
+				return encodeStepData(diskPaths(disks))
+			},
+			Undo: undoDataDisks,
+		},
+		{
+			// Define the libvirt domain (registers the VM, does not start it).
+			Name: "domain",
+			Do: func() (string, error) {
+				domain, err = defineDomain(session, spec, diskPath, disks, ifaces)
+				return spec.Name, err
+			},
+			Undo: func(name string) error { return undefineDomain(session, name) },
+		},
 	}
 
-	// Register a static DHCP mapping so the VM always gets the same IP.
-	if err = network.SetStaticMapping(session, spec, hostAddr); err != nil {
-		return fmt.Errorf("set static DHCP mapping for %q: %w", spec.Name, err)
+	steps = append(steps, addressSteps(session, ifaces)...)
+
+	// Expose guest ports on the host through the primary interface.
+	forwardSteps, err := portForwardSteps(session, spec, ifaces)
+	if err != nil {
+		releaseAllocations(session, spec, ifaces)
+		return err
 	}
-	// rollback = append(rollback, func() { network.RemoveStaticMapping(session, hostAddr) })
+	steps = append(steps, forwardSteps...)
 
 	// Start the domain (boots the VM).
-	if err = createDomain(session, domain); err != nil {
-		return fmt.Errorf("start domain %q: %w", spec.Name, err)
+	steps = append(steps, transaction.Step{
+		Name: "start",
+		Do: func() (string, error) {
+			return spec.Name, createDomain(session, domain)
+		},
+		Undo: func(name string) error { return stopDomain(session, name) },
+	})
+
+	err = transaction.NewRunner(session, spec).Run(steps)
+	refreshPool(session, diskPath)
+	if err != nil {
+		return fmt.Errorf("apply vm %q: %w", spec.Name, err)
 	}
 
 	// Persist computed values back into the spec so the engine can save them.
-	spec.Attrs["mac_address"] = hostAddr.MAC.String()
+	spec.Attrs["ip"] = ifaces[0].IP
+	spec.Attrs["ipv6"] = ifaces[0].IPv6
+	spec.Attrs["mac_address"] = ifaces[0].MAC
+	spec.Attrs["interfaces"] = interfaceAttrs(ifaces)
 	spec.Attrs["disk_path"] = diskPath
+	spec.Attrs["disks"] = diskAttrs(disks)
 	spec.Status = "running"
+
+	// Protects the images the disks are overlays of from removal
+	if err := store.RecordBackings(session, spec, spec.GetString("store"), BackingImages(spec)); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+
+	// The VM exists from here on: not reaching readiness is only a warning
+	if conditions, timeout, ok := WaitSpec(spec); ok {
+		if err := Wait(session, spec, conditions, timeout); err != nil {
+			logger.Warnf("%v", err)
+			return nil
+		}
+		spec.Status = "ready"
+	}
 	return nil
 }
 
@@ -123,7 +189,15 @@ func (l *VMLifecycle) Destroy(session registry.Session, change registry.Change)
 	// Ignore error — VM might already be stopped
 	_ = session.Conn.DomainDestroy(dom)
 
-	if err := session.Conn.DomainUndefineFlags(dom, 0); err != nil {
+	// libvirt refuses to undefine a domain that still has snapshots
+	if err := removeSnapshots(session, dom, spec); err != nil {
+		return err
+	}
+
+	if err := session.Conn.DomainUndefineFlags(
+		dom,
+		libvirt.DomainUndefineSnapshotsMetadata,
+	); err != nil {
 		return fmt.Errorf("undefine domain %q: %w", spec.Name, err)
 	}
 
@@ -139,5 +213,54 @@ func (l *VMLifecycle) Destroy(session registry.Session, change registry.Change)
 	if err := deleteOverlay(diskPath); err != nil {
 		return err
 	}
+	if err := releaseBackingFiles(session, spec); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+	refreshPool(session, diskPath)
+	if err := store.ForgetBackings(session, spec); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+
+	// Release the DHCP reservation and DNS records of every interface. The
+	// domain is already gone, so a failure here is reported but not fatal.
+	for _, iface := range Interfaces(spec) {
+		netObj, err := network.Lookup(session, iface.Network, spec.Namespace)
+		if err == nil && !network.ManagesAddresses(netObj) {
+			continue
+		}
+		addr, err := resolveAddr(iface)
+		if err != nil {
+			logger.Warnf("vm %q: skip DHCP cleanup on %q: %v", spec.Name, iface.Network, err)
+			continue
+		}
+		if err := network.RemoveStaticMapping(session, iface.Network, addr); err != nil {
+			logger.Warnf("vm %q: %v", spec.Name, err)
+		}
+		if netObj == nil {
+			continue
+		}
+		if hostname := network.Hostname(netObj, spec.Name); hostname != "" {
+			err := network.RemoveDNSHost(session, iface.Network, hostname, addr.Addresses())
+			if err != nil {
+				logger.Warnf("vm %q: %v", spec.Name, err)
+			}
+		}
+	}
+
+	if len(PortForwards(spec)) > 0 {
+		if err := removePortForwards(session.Ctx, spec.Name, spec.Namespace); err != nil {
+			logger.Warnf("vm %q: remove port forwards: %v", spec.Name, err)
+		}
+	}
+
+	// Give the allocated addresses back to the network IPAM
+	if err := network.ReleaseIPs(session, spec.Name, spec.Namespace); err != nil {
+		logger.Warnf("vm %q: %v", spec.Name, err)
+	}
+
+	// Extra disks marked with keep = true survive the VM
+	if err := removeDataDisks(dataDisks(spec), true); err != nil {
+		return fmt.Errorf("delete data disks of %q: %w", spec.Name, err)
+	}
 	return nil
 }
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// stored returns object as read back from the state DB.
+func stored(t *testing.T, object *registry.Object) *registry.Object {
+	t.Helper()
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded registry.Object
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestVMPlan(t *testing.T) {
+	// desired is a VM as built from its file
+	desired := func() *registry.Object {
+		return &registry.Object{
+			TypeName: "vm",
+			Name:     "web",
+			Labels:   map[string]string{"tier": "front"},
+			Attrs: map[string]any{
+				"cpu":    2,
+				"memory": 2048,
+				"disk":   "20G",
+				"image":  "rocky-9.5",
+				"store":  "homelab",
+				"interfaces": []map[string]any{
+					{"network": "lab", "ip": "", "ipv6": "", "mac": "", "model": "", "mtu": 9000},
+				},
+				"disks": []map[string]any{
+					{"name": "data", "size": "10G", "bus": "", "format": "", "cache": "", "image": "", "keep": false},
+				},
+				"port_forwards": []map[string]any{
+					{"host_port": 8080, "guest_port": 80, "protocol": "tcp", "host_address": ""},
+				},
+				"snapshot_policy": map[string]any{"schedule": "@daily", "keep_last": 7},
+			},
+		}
+	}
+	// current is the VM saved by the apply that created it, with its
+	// computed addresses and disk paths
+	current := func() *registry.Object {
+		object := desired()
+		object.Attrs["ip"] = "192.168.10.2"
+		object.Attrs["mac_address"] = "02:aa:c0:a8:0a:02"
+		object.Attrs["disk_path"] = "/images/web.qcow2"
+		object.Attrs["interfaces"] = []map[string]any{{
+			"network": "lab",
+			"ip":      "192.168.10.2",
+			"ipv6":    "",
+			"mac":     "02:aa:c0:a8:0a:02",
+			"model":   "virtio",
+			"mtu":     9000,
+		}}
+		object.Attrs["disks"] = diskAttrs([]dataDisk{{
+			Name:   "data",
+			Size:   "10G",
+			Bus:    DiskDefaults.Bus,
+			Format: DiskDefaults.Format,
+			Path:   "/images/web-data.qcow2",
+			Target: "vdb",
+		}})
+		object.Status = "running"
+		return object
+	}
+
+	tests := []struct {
+		name    string
+		desired *registry.Object
+		current *registry.Object
+		want    registry.Action
+	}{
+		{name: "new", desired: desired(), want: registry.ActionCreate},
+		{name: "removed", current: current(), want: registry.ActionDelete},
+		{name: "unchanged", desired: desired(), current: current(), want: registry.ActionNone},
+		{
+			name: "computed values written out",
+			desired: func() *registry.Object {
+				object := desired()
+				object.GetList("interfaces")[0]["ip"] = "192.168.10.2"
+				object.GetList("interfaces")[0]["model"] = "virtio"
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionNone,
+		},
+		{
+			name: "mtu",
+			desired: func() *registry.Object {
+				object := desired()
+				object.GetList("interfaces")[0]["mtu"] = 1500
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name: "keep",
+			desired: func() *registry.Object {
+				object := desired()
+				object.GetList("disks")[0]["keep"] = true
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name: "port forwards",
+			desired: func() *registry.Object {
+				object := desired()
+				object.Attrs["port_forwards"] = []map[string]any{}
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name: "snapshot policy",
+			desired: func() *registry.Object {
+				object := desired()
+				delete(object.Attrs, "snapshot_policy")
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+		{
+			name: "labels",
+			desired: func() *registry.Object {
+				object := desired()
+				object.Labels = map[string]string{"tier": "back"}
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+		{
+			// Apply refuses it, with the reason
+			name: "memory",
+			desired: func() *registry.Object {
+				object := desired()
+				object.Attrs["memory"] = 4096
+				return object
+			}(),
+			current: current(),
+			want:    registry.ActionUpdate,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := test.current
+			if current != nil {
+				current = stored(t, current)
+			}
+			got, err := (&VMLifecycle{}).Plan(test.desired, current)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Plan() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
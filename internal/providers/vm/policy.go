@@ -0,0 +1,249 @@
+package vm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/schedule"
+)
+
+// Snapshots taken by a snapshot policy are named with this prefix and
+// the time they were taken. Retention only ever deletes those.
+const policySnapshotPrefix = "auto-"
+
+// SnapshotPolicy takes snapshots of a VM on a schedule and keeps the
+// last ones, the newest of the last days and of the last weeks. Without
+// any keep_* rule, every snapshot is kept.
+type SnapshotPolicy struct {
+	Schedule   string
+	KeepLast   int
+	KeepDaily  int
+	KeepWeekly int
+	Quiesce    bool
+}
+
+// SnapshotPolicyOf reads the snapshot_policy attribute of a VM.
+func SnapshotPolicyOf(spec *registry.Object) (*SnapshotPolicy, bool) {
+	item, ok := spec.Attrs["snapshot_policy"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	policy := &SnapshotPolicy{
+		KeepLast:   registry.AsInt(item["keep_last"]),
+		KeepDaily:  registry.AsInt(item["keep_daily"]),
+		KeepWeekly: registry.AsInt(item["keep_weekly"]),
+	}
+	policy.Schedule, _ = item["schedule"].(string)
+	policy.Quiesce, _ = item["quiesce"].(bool)
+	return policy, true
+}
+
+// SnapshotRun is the outcome of a policy run for one VM.
+type SnapshotRun struct {
+	VM        string
+	Namespace string
+	StartedAt time.Time
+	Snapshot  string
+	Pruned    []string
+	Outcome   string // ok or failed
+	Error     string
+}
+
+// ensureSnapshotRunsTable creates the snapshot_runs table if it doesn't
+// exist. Each policy run of a VM is recorded there; the last one tells
+// when the policy is due again.
+func ensureSnapshotRunsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS snapshot_runs (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		vm         TEXT NOT NULL,
+		vm_ns      TEXT NOT NULL DEFAULT '',
+		started_at DATETIME NOT NULL,
+		snapshot   TEXT NOT NULL DEFAULT '',
+		pruned     TEXT NOT NULL DEFAULT '',
+		outcome    TEXT NOT NULL,
+		error      TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_snapshot_runs_vm_ns
+		ON snapshot_runs(vm, vm_ns);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure snapshot_runs table: %w", err)
+	}
+	return nil
+}
+
+// lastSnapshotRun returns when the policy of a VM last ran, or the zero
+// time if it never did.
+func lastSnapshotRun(session registry.Session, spec *registry.Object) (time.Time, error) {
+	const query = `
+	SELECT started_at FROM snapshot_runs
+	WHERE vm = ? AND vm_ns = ?
+	ORDER BY started_at DESC LIMIT 1
+	`
+	var last time.Time
+	err := session.DB.QueryRowContext(session.Ctx, query, spec.Name, spec.Namespace).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get last snapshot run of %q: %w", spec.Name, err)
+	}
+	return last, nil
+}
+
+// SnapshotDue reports whether the policy of a VM is due at now: a
+// scheduled time passed since its last run. A policy that never ran is
+// due right away. Schedules are in local time.
+func SnapshotDue(session registry.Session, spec *registry.Object, now time.Time) (bool, error) {
+	policy, ok := SnapshotPolicyOf(spec)
+	if !ok {
+		return false, nil
+	}
+	cron, err := schedule.Parse(policy.Schedule)
+	if err != nil {
+		return false, fmt.Errorf("vm %q: snapshot_policy: %w", spec.Name, err)
+	}
+	if err := ensureSnapshotRunsTable(session.Ctx, session.DB); err != nil {
+		return false, err
+	}
+	last, err := lastSnapshotRun(session, spec)
+	if err != nil {
+		return false, err
+	}
+	if last.IsZero() {
+		return true, nil
+	}
+	next := cron.Next(last.Local())
+	return !next.IsZero() && !next.After(now), nil
+}
+
+// RunSnapshotPolicy takes the scheduled snapshot of a VM, prunes the
+// snapshots the policy no longer keeps and records the run. The returned
+// run is recorded even when it failed.
+func RunSnapshotPolicy(session registry.Session, spec *registry.Object, now time.Time) (SnapshotRun, error) {
+	run := SnapshotRun{
+		VM:        spec.Name,
+		Namespace: spec.Namespace,
+		StartedAt: now.UTC(),
+		Outcome:   "ok",
+	}
+	policy, ok := SnapshotPolicyOf(spec)
+	if !ok {
+		return run, fmt.Errorf("vm %q has no snapshot_policy", spec.Name)
+	}
+
+	runErr := func() error {
+		name := policySnapshotPrefix + now.Local().Format("20060102-1504")
+		description := fmt.Sprintf("snapshot_policy %q", policy.Schedule)
+		if err := CreateSnapshot(session, spec, name, description, policy.Quiesce); err != nil {
+			return err
+		}
+		run.Snapshot = name
+
+		pruned, err := pruneSnapshots(session, spec, policy)
+		run.Pruned = pruned
+		return err
+	}()
+	if runErr != nil {
+		run.Outcome = "failed"
+		run.Error = runErr.Error()
+	}
+
+	if err := recordSnapshotRun(session, run); err != nil {
+		return run, errors.Join(runErr, err)
+	}
+	return run, runErr
+}
+
+func recordSnapshotRun(session registry.Session, run SnapshotRun) error {
+	if err := ensureSnapshotRunsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const query = `
+	INSERT INTO snapshot_runs (vm, vm_ns, started_at, snapshot, pruned, outcome, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err := session.DB.ExecContext(session.Ctx, query,
+		run.VM, run.Namespace, run.StartedAt, run.Snapshot,
+		strings.Join(run.Pruned, ","), run.Outcome, run.Error,
+	); err != nil {
+		return fmt.Errorf("record snapshot run of %q: %w", run.VM, err)
+	}
+	return nil
+}
+
+// pruneSnapshots deletes the policy snapshots of a VM the retention
+// rules don't keep, and returns their names.
+func pruneSnapshots(
+	session registry.Session,
+	spec *registry.Object,
+	policy *SnapshotPolicy,
+) ([]string, error) {
+	if policy.KeepLast == 0 && policy.KeepDaily == 0 && policy.KeepWeekly == 0 {
+		return nil, nil
+	}
+	snapshots, err := Snapshots(session, spec.Name, spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var auto []Snapshot
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, policySnapshotPrefix) {
+			auto = append(auto, snapshot)
+		}
+	}
+	// Newest first
+	slices.Reverse(auto)
+	keep := retained(auto, policy)
+
+	var pruned []string
+	for _, snapshot := range auto {
+		if keep[snapshot.Name] {
+			continue
+		}
+		if err := DeleteSnapshot(session, spec, snapshot.Name); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, snapshot.Name)
+	}
+	return pruned, nil
+}
+
+// retained returns the snapshots the policy keeps, out of snapshots
+// sorted newest first: the keep_last newest, and the newest of each of
+// the keep_daily last days and keep_weekly last weeks with a snapshot.
+func retained(snapshots []Snapshot, policy *SnapshotPolicy) map[string]bool {
+	keep := make(map[string]bool)
+	for _, snapshot := range snapshots[:min(policy.KeepLast, len(snapshots))] {
+		keep[snapshot.Name] = true
+	}
+
+	keepNewestPer := func(count int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, snapshot := range snapshots {
+			if len(seen) == count {
+				return
+			}
+			key := period(snapshot.CreatedAt.Local())
+			if !seen[key] {
+				seen[key] = true
+				keep[snapshot.Name] = true
+			}
+		}
+	}
+	keepNewestPer(policy.KeepDaily, func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	})
+	keepNewestPer(policy.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	return keep
+}
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetained(t *testing.T) {
+	// 2026-01-05 is a Monday, snapshots are taken at 02:00 and 14:00
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, time.January, day, hour, 0, 0, 0, time.Local)
+	}
+	// snapshotsAt returns snapshots named after their day and hour,
+	// newest first as retained expects
+	snapshotsAt := func(times ...time.Time) []Snapshot {
+		snapshots := make([]Snapshot, 0, len(times))
+		for _, created := range times {
+			snapshots = append(snapshots, Snapshot{
+				Name:      fmt.Sprintf("%02d-%02d", created.Day(), created.Hour()),
+				CreatedAt: created,
+			})
+		}
+		slices.SortFunc(snapshots, func(a, b Snapshot) int { return b.CreatedAt.Compare(a.CreatedAt) })
+		return snapshots
+	}
+	twiceADay := snapshotsAt(
+		at(5, 2), at(5, 14), at(6, 2), at(6, 14), at(7, 2), at(7, 14),
+		at(12, 2), at(12, 14), at(19, 2), at(19, 14),
+	)
+
+	tests := []struct {
+		name      string
+		snapshots []Snapshot
+		policy    SnapshotPolicy
+		want      []string
+	}{
+		{
+			name:      "no snapshot",
+			snapshots: nil,
+			policy:    SnapshotPolicy{KeepLast: 3, KeepDaily: 3, KeepWeekly: 3},
+			want:      nil,
+		},
+		{
+			name:      "keep nothing",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{},
+			want:      nil,
+		},
+		{
+			name:      "keep last",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{KeepLast: 3},
+			want:      []string{"19-14", "19-02", "12-14"},
+		},
+		{
+			name:      "keep last more than there are",
+			snapshots: snapshotsAt(at(5, 2), at(6, 2)),
+			policy:    SnapshotPolicy{KeepLast: 5},
+			want:      []string{"06-02", "05-02"},
+		},
+		{
+			name:      "keep the newest of each day",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{KeepDaily: 3},
+			want:      []string{"19-14", "12-14", "07-14"},
+		},
+		{
+			name:      "days without a snapshot don't count",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{KeepDaily: 5},
+			want:      []string{"19-14", "12-14", "07-14", "06-14", "05-14"},
+		},
+		{
+			name:      "keep the newest of each week",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{KeepWeekly: 2},
+			want:      []string{"19-14", "12-14"},
+		},
+		{
+			name:      "weeks start on Monday",
+			snapshots: snapshotsAt(at(4, 2), at(5, 2), at(11, 2)),
+			policy:    SnapshotPolicy{KeepWeekly: 3},
+			want:      []string{"11-02", "04-02"},
+		},
+		{
+			name:      "rules add up",
+			snapshots: twiceADay,
+			policy:    SnapshotPolicy{KeepLast: 2, KeepDaily: 2, KeepWeekly: 3},
+			want:      []string{"19-14", "19-02", "12-14", "07-14"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := slices.Sorted(maps.Keys(retained(test.snapshots, &test.policy)))
+			want := slices.Clone(test.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("retained() = %v, want %v", got, want)
+			}
+		})
+	}
+}
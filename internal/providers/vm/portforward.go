@@ -0,0 +1,295 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/zakariakebairia/kvmcli/internal/database"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
+)
+
+var NftBinary = "nft"
+
+// Port forwards live in their own nftables table, so they never touch the
+// rules libvirt or the host firewall manage. Each rule carries a comment
+// naming its VM, which is how a VM's rules are found and removed.
+const nftTable = "kvmcli"
+
+// forwardMark marks the packets DNATed by port forwards. An accept in the
+// kvmcli table can't override the reject libvirt puts on new inbound
+// connections to NAT networks: nftables evaluates every table on a hook,
+// and a reject in any of them is final. libvirt's own chain has to accept
+// the marked packets instead.
+const forwardMark = "0x6b766d"
+
+// ForwardHookPath is the libvirt network hook kvmcli installs. libvirt
+// rebuilds its firewall rules when a network starts, the hook inserts the
+// accept of marked packets again. libvirtd reads hooks at startup: it
+// only runs a newly installed hook once restarted.
+var ForwardHookPath = "/etc/libvirt/hooks/network.d/kvmcli-port-forwards"
+
+// forwardHook accepts marked packets in the chain libvirt rejects new
+// inbound connections from: guest_input with the nftables backend,
+// LIBVIRT_FWI with the iptables one. It is idempotent. libvirt calls it
+// with the network name and the operation.
+const forwardHook = `#!/bin/sh
+# Managed by kvmcli: accepts the port forwards of kvmcli in the firewall
+# rules libvirt (re)installs when a network starts.
+case "$2" in
+started|port-created) ;;
+*) exit 0 ;;
+esac
+mark=` + forwardMark + `
+comment=kvmcli-port-forward
+if nft list chain ip libvirt_network guest_input >/dev/null 2>&1; then
+	nft list chain ip libvirt_network guest_input | grep -q "\"$comment\"" ||
+		nft insert rule ip libvirt_network guest_input meta mark "$mark" accept comment "\"$comment\""
+fi
+if iptables -w -n -L LIBVIRT_FWI >/dev/null 2>&1; then
+	iptables -w -C LIBVIRT_FWI -m mark --mark "$mark" -m comment --comment "$comment" -j ACCEPT 2>/dev/null ||
+		iptables -w -I LIBVIRT_FWI 1 -m mark --mark "$mark" -m comment --comment "$comment" -j ACCEPT
+fi
+exit 0
+`
+
+// PortForward exposes a guest port on the host.
+type PortForward struct {
+	HostPort    int
+	GuestPort   int
+	Protocol    string
+	HostAddress string
+}
+
+// PortForwards reads the port forwards stored in the object attributes.
+func PortForwards(spec *registry.Object) []PortForward {
+	items := spec.GetList("port_forwards")
+	forwards := make([]PortForward, 0, len(items))
+	for _, item := range items {
+		forward := PortForward{
+			HostPort:  registry.AsInt(item["host_port"]),
+			GuestPort: registry.AsInt(item["guest_port"]),
+		}
+		forward.Protocol, _ = item["protocol"].(string)
+		forward.HostAddress, _ = item["host_address"].(string)
+		forwards = append(forwards, forward)
+	}
+	return forwards
+}
+
+// forwardComment tags the rules of a VM.
+func forwardComment(name, namespace string) string {
+	return fmt.Sprintf("kvmcli:%s/%s", namespace, name)
+}
+
+func runNft(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, NftBinary, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("nft %v: %w: %s", args, err, output)
+	}
+	return output, nil
+}
+
+// ensureNftTable creates the kvmcli table and its chains. nft "add" is a
+// no-op for objects that already exist.
+func ensureNftTable(ctx context.Context) error {
+	commands := [][]string{
+		{"add", "table", "ip", nftTable},
+		{"add", "chain", "ip", nftTable, "prerouting",
+			"{ type nat hook prerouting priority dstnat; policy accept; }"},
+		// Connections from the host itself to its own address
+		{"add", "chain", "ip", nftTable, "output",
+			"{ type nat hook output priority -100; policy accept; }"},
+	}
+	for _, args := range commands {
+		if _, err := runNft(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureLibvirtAccept makes libvirt's firewall accept the marked packets,
+// now and whenever libvirt reinstalls its rules.
+func ensureLibvirtAccept(ctx context.Context) error {
+	if err := installForwardHook(); err != nil {
+		logger.Warnf("port forwards stop working when libvirt restarts a network: %v", err)
+	}
+	output, err := exec.CommandContext(
+		ctx, "sh", "-c", forwardHook, "kvmcli-port-forwards", "kvmcli", "started", "begin", "-",
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("accept port forwards in libvirt firewall rules: %w: %s", err, output)
+	}
+	return nil
+}
+
+// installForwardHook writes the libvirt network hook, if it changed.
+func installForwardHook() error {
+	if current, err := os.ReadFile(ForwardHookPath); err == nil && string(current) == forwardHook {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(ForwardHookPath), 0o755); err != nil {
+		return fmt.Errorf("install libvirt hook: %w", err)
+	}
+	if err := os.WriteFile(ForwardHookPath, []byte(forwardHook), 0o755); err != nil {
+		return fmt.Errorf("install libvirt hook: %w", err)
+	}
+	logger.Warnf("installed libvirt hook %s, restart libvirtd once so it runs it", ForwardHookPath)
+	return nil
+}
+
+// addPortForwards installs the DNAT rules of a VM whose
+// primary interface has guestIP. Rules left by a previous apply are
+// replaced, and on failure none of the VM's rules are kept.
+func addPortForwards(
+	ctx context.Context,
+	name, namespace, guestIP string,
+	forwards []PortForward,
+) error {
+	if err := ensureNftTable(ctx); err != nil {
+		return err
+	}
+	if err := ensureLibvirtAccept(ctx); err != nil {
+		return err
+	}
+	if err := removePortForwards(ctx, name, namespace); err != nil {
+		return err
+	}
+	if err := addForwardRules(ctx, forwardComment(name, namespace), guestIP, forwards); err != nil {
+		_ = removePortForwards(ctx, name, namespace)
+		return err
+	}
+	return nil
+}
+
+func addForwardRules(ctx context.Context, tag, guestIP string, forwards []PortForward) error {
+	comment := strconv.Quote(tag)
+	for _, forward := range forwards {
+		// Without host_address, match any local address of the host
+		match := []string{"fib", "daddr", "type", "local"}
+		if forward.HostAddress != "" {
+			match = []string{"ip", "daddr", forward.HostAddress}
+		}
+		// The mark lets the connection through libvirt's forward rules
+		dnat := append(match,
+			forward.Protocol, "dport", strconv.Itoa(forward.HostPort),
+			"meta", "mark", "set", forwardMark,
+			"dnat", "to", fmt.Sprintf("%s:%d", guestIP, forward.GuestPort),
+			"comment", comment,
+		)
+		for _, chain := range []string{"prerouting", "output"} {
+			args := append([]string{"add", "rule", "ip", nftTable, chain}, dnat...)
+			if _, err := runNft(ctx, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nftRuleset is the part of `nft -j list table` output we read.
+type nftRuleset struct {
+	Nftables []struct {
+		Rule *struct {
+			Chain   string `json:"chain"`
+			Handle  int    `json:"handle"`
+			Comment string `json:"comment"`
+		} `json:"rule"`
+	} `json:"nftables"`
+}
+
+// portForwardSteps installs the port forwards of a VM, if any, through
+// its primary interface.
+func portForwardSteps(session registry.Session, spec *registry.Object, ifaces []Interface) ([]transaction.Step, error) {
+	forwards := PortForwards(spec)
+	if len(forwards) == 0 {
+		return nil, nil
+	}
+	if ifaces[0].IP == "" {
+		return nil, fmt.Errorf("vm %q: port_forward requires an IPv4 address on the first interface", spec.Name)
+	}
+	if err := checkForwardClashes(session, spec, forwards); err != nil {
+		return nil, err
+	}
+	return []transaction.Step{{
+		Name: "port-forward",
+		Do: func() (string, error) {
+			comment := forwardComment(spec.Name, spec.Namespace)
+			return comment, addPortForwards(
+				session.Ctx, spec.Name, spec.Namespace, ifaces[0].IP, forwards,
+			)
+		},
+		Undo: func(comment string) error { return removeTaggedRules(session.Ctx, comment) },
+	}}, nil
+}
+
+// checkForwardClashes fails when a port forward listens on a host port
+// another VM in state already forwards. Clashes within one file are
+// caught when it is loaded.
+func checkForwardClashes(session registry.Session, spec *registry.Object, forwards []PortForward) error {
+	vms, err := database.NewDBHandler(session.DB).List(session.Ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("list vms: %w", err)
+	}
+	for _, object := range vms {
+		if object.Name == spec.Name && object.Namespace == spec.Namespace {
+			continue
+		}
+		for _, other := range PortForwards(&object) {
+			for _, forward := range forwards {
+				// A forward without host_address listens on every host address
+				sameAddress := forward.HostAddress == "" || other.HostAddress == "" ||
+					forward.HostAddress == other.HostAddress
+				if forward.Protocol == other.Protocol && forward.HostPort == other.HostPort && sameAddress {
+					return fmt.Errorf(
+						"vm %q: %s port %d is already forwarded to vm %s/%s",
+						spec.Name, forward.Protocol, forward.HostPort, object.Namespace, object.Name,
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removePortForwards deletes every rule of a VM from the kvmcli table.
+func removePortForwards(ctx context.Context, name, namespace string) error {
+	return removeTaggedRules(ctx, forwardComment(name, namespace))
+}
+
+// removeTaggedRules deletes the rules of the kvmcli table with comment.
+func removeTaggedRules(ctx context.Context, comment string) error {
+	output, err := exec.CommandContext(
+		ctx, NftBinary, "-j", "-a", "list", "table", "ip", nftTable,
+	).Output()
+	if err != nil {
+		// No table, no rules
+		return nil
+	}
+	var ruleset nftRuleset
+	if err := json.Unmarshal(output, &ruleset); err != nil {
+		return fmt.Errorf("parse nft ruleset: %w", err)
+	}
+
+	var errs []error
+	for _, item := range ruleset.Nftables {
+		if item.Rule == nil || item.Rule.Comment != comment {
+			continue
+		}
+		if _, err := runNft(ctx,
+			"delete", "rule", "ip", nftTable, item.Rule.Chain,
+			"handle", strconv.Itoa(item.Rule.Handle),
+		); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
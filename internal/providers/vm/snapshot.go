@@ -0,0 +1,320 @@
+package vm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/templates"
+)
+
+// Snapshot is the metadata kvmcli keeps about a VM snapshot. The
+// snapshot itself is a libvirt internal snapshot: the disk state lives in
+// the qcow2 files of the VM, and the memory state too when the VM was
+// running.
+type Snapshot struct {
+	VM          string
+	Namespace   string
+	Name        string
+	Parent      string
+	Description string
+	VMState     string
+	CreatedAt   time.Time
+}
+
+// ensureSnapshotsTable creates the snapshots table if it doesn't exist.
+// Snapshots are owned by the vm provider: rows are removed with their VM.
+func ensureSnapshotsTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS snapshots (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		vm          TEXT NOT NULL,
+		vm_ns       TEXT NOT NULL DEFAULT '',
+		name        TEXT NOT NULL,
+		parent      TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		vm_state    TEXT NOT NULL DEFAULT '',
+		created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_snapshots_vm_ns_name
+		ON snapshots(vm, vm_ns, name);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure snapshots table: %w", err)
+	}
+	return nil
+}
+
+// CreateSnapshot takes a snapshot of a VM and records it. The parent is
+// the current snapshot of the VM, if any. Disks that are not qcow2
+// cannot hold internal snapshots and are left out. With quiesce, the
+// file systems of a running VM are frozen by the guest agent during the
+// snapshot.
+func CreateSnapshot(
+	session registry.Session,
+	spec *registry.Object,
+	name, description string,
+	quiesce bool,
+) error {
+	if err := ensureSnapshotsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	state, _, err := session.Conn.DomainGetState(dom, 0)
+	if err != nil {
+		return fmt.Errorf("get state of domain %q: %w", spec.Name, err)
+	}
+
+	parent := ""
+	if current, err := session.Conn.DomainSnapshotCurrent(dom, 0); err == nil {
+		parent = current.Name
+	}
+
+	definition := templates.NewDomainSnapshot(name, description)
+	for _, disk := range dataDisks(spec) {
+		if disk.Format != "qcow2" {
+			definition.ExcludeDisk(disk.Target)
+		}
+	}
+	snapshotXML, err := definition.GenerateXML()
+	if err != nil {
+		return fmt.Errorf("generate snapshot XML: %w", err)
+	}
+	if quiesce && libvirt.DomainState(state) == libvirt.DomainRunning {
+		if _, err := session.Conn.QEMUDomainAgentCommand(
+			dom, `{"execute":"guest-fsfreeze-freeze"}`, agentTimeout, 0,
+		); err != nil {
+			return fmt.Errorf("quiesce vm %q (is the guest agent running?): %w", spec.Name, err)
+		}
+		defer thawFilesystems(session, dom, spec.Name)
+	}
+	snap, err := session.Conn.DomainSnapshotCreateXML(dom, string(snapshotXML), 0)
+	if err != nil {
+		return fmt.Errorf("create snapshot %q of vm %q: %w", name, spec.Name, err)
+	}
+
+	const query = `
+	INSERT INTO snapshots (vm, vm_ns, name, parent, description, vm_state)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	if _, err := session.DB.ExecContext(
+		session.Ctx, query,
+		spec.Name, spec.Namespace, name, parent, description,
+		domainStateName(libvirt.DomainState(state)),
+	); err != nil {
+		// Don't leave a snapshot kvmcli doesn't know about
+		if undoErr := session.Conn.DomainSnapshotDelete(snap, 0); undoErr != nil {
+			logger.Warnf("vm %q: delete snapshot %q: %v", spec.Name, name, undoErr)
+		}
+		return fmt.Errorf("record snapshot %q of vm %q: %w", name, spec.Name, err)
+	}
+	return nil
+}
+
+// Snapshots lists the recorded snapshots of a VM, oldest first. An
+// empty vm lists the snapshots of every VM.
+func Snapshots(session registry.Session, vm, namespace string) ([]Snapshot, error) {
+	if err := ensureSnapshotsTable(session.Ctx, session.DB); err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT vm, vm_ns, name, parent, description, vm_state, created_at
+	FROM snapshots
+	`
+	var args []any
+	if vm != "" {
+		query += ` WHERE vm = ? AND vm_ns = ?`
+		args = append(args, vm, namespace)
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := session.DB.QueryContext(session.Ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var snapshot Snapshot
+		if err := rows.Scan(
+			&snapshot.VM,
+			&snapshot.Namespace,
+			&snapshot.Name,
+			&snapshot.Parent,
+			&snapshot.Description,
+			&snapshot.VMState,
+			&snapshot.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// findSnapshot returns the recorded snapshot name of a VM.
+func findSnapshot(session registry.Session, spec *registry.Object, name string) (*Snapshot, error) {
+	snapshots, err := Snapshots(session, spec.Name, spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for index := range snapshots {
+		if snapshots[index].Name == name {
+			return &snapshots[index], nil
+		}
+	}
+	return nil, fmt.Errorf("vm %q has no snapshot %q", spec.Name, name)
+}
+
+// RevertSnapshot brings a VM back to a snapshot: its disks, and its
+// memory when the snapshot was taken while it ran. The reverted snapshot
+// is returned, its VMState is the state the VM is in afterwards.
+func RevertSnapshot(session registry.Session, spec *registry.Object, name string) (*Snapshot, error) {
+	snapshot, err := findSnapshot(session, spec, name)
+	if err != nil {
+		return nil, err
+	}
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	snap, err := session.Conn.DomainSnapshotLookupByName(dom, name, 0)
+	if err != nil {
+		return nil, fmt.Errorf("lookup snapshot %q of vm %q: %w", name, spec.Name, err)
+	}
+	if err := session.Conn.DomainRevertToSnapshot(snap, 0); err != nil {
+		return nil, fmt.Errorf("revert vm %q to snapshot %q: %w", spec.Name, name, err)
+	}
+	// A quiesced snapshot resumes with frozen file systems. Thawing is a
+	// no-op otherwise, and without a guest agent it fails: ignore it.
+	if snapshot.VMState == "running" {
+		_, _ = session.Conn.QEMUDomainAgentCommand(
+			dom, `{"execute":"guest-fsfreeze-thaw"}`, 0, 0,
+		)
+	}
+	return snapshot, nil
+}
+
+// agentTimeout is how long guest agent commands may take, in seconds.
+const agentTimeout = 10
+
+// thawFilesystems undoes guest-fsfreeze-freeze.
+func thawFilesystems(session registry.Session, dom libvirt.Domain, name string) {
+	if _, err := session.Conn.QEMUDomainAgentCommand(
+		dom, `{"execute":"guest-fsfreeze-thaw"}`, agentTimeout, 0,
+	); err != nil {
+		logger.Warnf("vm %q: thaw file systems: %v", name, err)
+	}
+}
+
+// DeleteSnapshot deletes a snapshot of a VM. Its children are attached
+// to its parent, as libvirt does.
+func DeleteSnapshot(session registry.Session, spec *registry.Object, name string) error {
+	snapshot, err := findSnapshot(session, spec, name)
+	if err != nil {
+		return err
+	}
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+	snap, err := session.Conn.DomainSnapshotLookupByName(dom, name, 0)
+	if err == nil {
+		err = session.Conn.DomainSnapshotDelete(snap, 0)
+	}
+	if err != nil && !isNoSnapshot(err) {
+		return fmt.Errorf("delete snapshot %q of vm %q: %w", name, spec.Name, err)
+	}
+	return forgetSnapshot(session, snapshot)
+}
+
+// forgetSnapshot removes the row of a snapshot and reparents its children.
+func forgetSnapshot(session registry.Session, snapshot *Snapshot) error {
+	tx, err := session.DB.BeginTx(session.Ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(session.Ctx,
+		`UPDATE snapshots SET parent = ? WHERE vm = ? AND vm_ns = ? AND parent = ?`,
+		snapshot.Parent, snapshot.VM, snapshot.Namespace, snapshot.Name,
+	); err != nil {
+		return fmt.Errorf("reparent children of snapshot %q: %w", snapshot.Name, err)
+	}
+	if _, err := tx.ExecContext(session.Ctx,
+		`DELETE FROM snapshots WHERE vm = ? AND vm_ns = ? AND name = ?`,
+		snapshot.VM, snapshot.Namespace, snapshot.Name,
+	); err != nil {
+		return fmt.Errorf("remove snapshot %q: %w", snapshot.Name, err)
+	}
+	return tx.Commit()
+}
+
+// removeSnapshots deletes every snapshot of a VM being destroyed, in
+// libvirt and in state. The disks of the VM are deleted next, so failing
+// to delete a snapshot is only a warning.
+func removeSnapshots(session registry.Session, dom libvirt.Domain, spec *registry.Object) error {
+	snaps, _, err := session.Conn.DomainListAllSnapshots(dom, 1, 0)
+	if err == nil {
+		for _, snap := range snaps {
+			if err := session.Conn.DomainSnapshotDelete(snap, 0); err != nil {
+				logger.Warnf("vm %q: delete snapshot %q: %v", spec.Name, snap.Name, err)
+			}
+		}
+	}
+
+	if err := ensureSnapshotsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const query = `DELETE FROM snapshots WHERE vm = ? AND vm_ns = ?`
+	if _, err := session.DB.ExecContext(session.Ctx, query, spec.Name, spec.Namespace); err != nil {
+		return fmt.Errorf("remove snapshots of %q: %w", spec.Name, err)
+	}
+
+	// A VM recreated with the same name starts a new policy history
+	if err := ensureSnapshotRunsTable(session.Ctx, session.DB); err != nil {
+		return err
+	}
+	const runsQuery = `DELETE FROM snapshot_runs WHERE vm = ? AND vm_ns = ?`
+	if _, err := session.DB.ExecContext(session.Ctx, runsQuery, spec.Name, spec.Namespace); err != nil {
+		return fmt.Errorf("remove snapshot runs of %q: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// isNoSnapshot reports whether err is libvirt's "snapshot not found".
+func isNoSnapshot(err error) bool {
+	var libvirtErr libvirt.Error
+	return errors.As(err, &libvirtErr) &&
+		libvirtErr.Code == uint32(libvirt.ErrNoDomainSnapshot)
+}
+
+// domainStateName is the status word of a libvirt domain state.
+func domainStateName(state libvirt.DomainState) string {
+	switch state {
+	case libvirt.DomainRunning:
+		return "running"
+	case libvirt.DomainPaused:
+		return "paused"
+	case libvirt.DomainShutdown:
+		return "shutting-down"
+	case libvirt.DomainShutoff:
+		return "stopped"
+	case libvirt.DomainCrashed:
+		return "crashed"
+	case libvirt.DomainPmsuspended:
+		return "suspended"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,214 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/providers/network"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+	"github.com/zakariakebairia/kvmcli/internal/transaction"
+)
+
+// Compensations of the apply steps. They only rely on the data journaled
+// by the step, so a later run can undo the work of an interrupted one.
+
+func encodeStepData(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("encode step data: %w", err)
+	}
+	return string(data), nil
+}
+
+func diskPaths(disks []dataDisk) []string {
+	paths := make([]string, 0, len(disks))
+	for _, disk := range disks {
+		paths = append(paths, disk.Path)
+	}
+	return paths
+}
+
+func undoDataDisks(data string) error {
+	var paths []string
+	if err := json.Unmarshal([]byte(data), &paths); err != nil {
+		return fmt.Errorf("decode disk paths: %w", err)
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := deleteOverlay(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func undefineDomain(session registry.Session, name string) error {
+	dom, err := session.Conn.DomainLookupByName(name)
+	if err != nil {
+		// Never defined, nothing to undo
+		return nil
+	}
+	// Ignore error — VM might not be running
+	_ = session.Conn.DomainDestroy(dom)
+	if err := session.Conn.DomainUndefineFlags(dom, 0); err != nil {
+		return fmt.Errorf("undefine domain %q: %w", name, err)
+	}
+	return nil
+}
+
+func stopDomain(session registry.Session, name string) error {
+	dom, err := session.Conn.DomainLookupByName(name)
+	if err != nil {
+		return nil
+	}
+	_ = session.Conn.DomainDestroy(dom)
+	return nil
+}
+
+// dnsRecord is the journaled form of the DNS records of an interface.
+type dnsRecord struct {
+	Network  string   `json:"network"`
+	Hostname string   `json:"hostname"`
+	IPs      []net.IP `json:"ips"`
+}
+
+func undoDNSRecord(session registry.Session, data string) error {
+	var record dnsRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return fmt.Errorf("decode dns record: %w", err)
+	}
+	return network.RemoveDNSHost(session, record.Network, record.Hostname, record.IPs)
+}
+
+func undoStaticMapping(session registry.Session, data string) error {
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(data), &attrs); err != nil {
+		return fmt.Errorf("decode interface: %w", err)
+	}
+	iface := interfaceFromAttrs(attrs)
+	addr, err := resolveAddr(iface)
+	if err != nil {
+		return err
+	}
+	return network.RemoveStaticMapping(session, iface.Network, addr)
+}
+
+// allocatedIP is the journaled form of an address IPAM allocated.
+type allocatedIP struct {
+	Network   string `json:"network"`
+	IP        string `json:"ip"`
+	Owner     string `json:"owner"`
+	Namespace string `json:"namespace"`
+}
+
+// allocationStep journals the addresses IPAM allocated for this apply,
+// so a rollback gives them back to the network. It comes first: the
+// addresses are allocated while resolving the interfaces, before the
+// runner starts.
+func allocationStep(session registry.Session, spec *registry.Object, ifaces []Interface) transaction.Step {
+	return transaction.Step{
+		Name: "ip-allocation",
+		Do: func() (string, error) {
+			var allocated []allocatedIP
+			for _, iface := range ifaces {
+				if iface.allocated {
+					allocated = append(allocated, allocatedIP{iface.Network, iface.IP, spec.Name, spec.Namespace})
+				}
+			}
+			return encodeStepData(allocated)
+		},
+		Undo: func(data string) error { return undoAllocations(session, data) },
+	}
+}
+
+func undoAllocations(session registry.Session, data string) error {
+	var allocated []allocatedIP
+	if err := json.Unmarshal([]byte(data), &allocated); err != nil {
+		return fmt.Errorf("decode allocations: %w", err)
+	}
+	var errs []error
+	for _, allocation := range allocated {
+		errs = append(errs, network.ReleaseIP(
+			session, allocation.Network, allocation.IP, allocation.Owner, allocation.Namespace,
+		))
+	}
+	return errors.Join(errs...)
+}
+
+// recoverRun compensates the steps journaled by an interrupted create,
+// clone or restore of object. It runs before the interfaces are resolved:
+// resolving reuses the addresses still allocated to the VM, and undoing
+// the interrupted run afterwards would release them from under it.
+func recoverRun(session registry.Session, object *registry.Object) error {
+	steps := []transaction.Step{
+		{Name: "ip-allocation", Undo: func(data string) error { return undoAllocations(session, data) }},
+		{Name: "base", Undo: undoRename},
+		{Name: "source-overlay", Undo: deleteOverlay},
+		{Name: "overlay", Undo: deleteOverlay},
+		{Name: "data-disks", Undo: undoDataDisks},
+		{Name: "domain", Undo: func(name string) error { return undefineDomain(session, name) }},
+		{Name: "dhcp", Undo: func(data string) error { return undoStaticMapping(session, data) }},
+		{Name: "dns", Undo: func(data string) error { return undoDNSRecord(session, data) }},
+		{Name: "port-forward", Undo: func(comment string) error { return removeTaggedRules(session.Ctx, comment) }},
+		{Name: "start", Undo: func(name string) error { return stopDomain(session, name) }},
+	}
+	return transaction.NewRunner(session, object).Recover(steps)
+}
+
+// releaseAllocations gives back the addresses allocated for an apply
+// that fails before its runner starts.
+func releaseAllocations(session registry.Session, spec *registry.Object, ifaces []Interface) {
+	for _, iface := range ifaces {
+		if !iface.allocated {
+			continue
+		}
+		if err := network.ReleaseIP(
+			session, iface.Network, iface.IP, spec.Name, spec.Namespace,
+		); err != nil {
+			logger.Warnf("%v", err)
+		}
+	}
+}
+
+// addressSteps registers the addresses of the interfaces on their
+// networks: a static DHCP mapping per interface, so the VM always gets
+// the same IPs, and <vm>.<domain> on networks that have a DNS domain.
+func addressSteps(session registry.Session, ifaces []Interface) []transaction.Step {
+	var steps []transaction.Step
+	for _, iface := range ifaces {
+		if !iface.managed {
+			continue
+		}
+		steps = append(steps, transaction.Step{
+			Name: "dhcp",
+			Do: func() (string, error) {
+				if err := network.SetStaticMapping(session, iface.Network, iface.addr); err != nil {
+					return "", err
+				}
+				return encodeStepData(iface.attrs())
+			},
+			Undo: func(data string) error { return undoStaticMapping(session, data) },
+		})
+	}
+
+	for _, iface := range ifaces {
+		if iface.hostname == "" || len(iface.addr.Addresses()) == 0 {
+			continue
+		}
+		steps = append(steps, transaction.Step{
+			Name: "dns",
+			Do: func() (string, error) {
+				ips := iface.addr.Addresses()
+				if err := network.SetDNSHost(session, iface.Network, iface.hostname, ips); err != nil {
+					return "", err
+				}
+				return encodeStepData(dnsRecord{iface.Network, iface.hostname, ips})
+			},
+			Undo: func(data string) error { return undoDNSRecord(session, data) },
+		})
+	}
+	return steps
+}
@@ -0,0 +1,264 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Attributes fixed when the VM is created: changing them means
+// destroying and recreating it.
+var recreateAttrs = []string{"cpu", "memory", "image", "disk", "store"}
+
+// Attributes kvmcli acts upon later (after apply, on run-due ...): they
+// are taken as is from the desired VM.
+var settingAttrs = []string{"wait_for", "snapshot_policy"}
+
+// vmChanged reports whether applying desired would change the VM saved
+// as current. Computed state, like allocated addresses, derived MACs and
+// disk paths, is not a change.
+func vmChanged(current, desired *registry.Object) bool {
+	for _, key := range slices.Concat(recreateAttrs, settingAttrs) {
+		if !registry.SameAttr(desired.Attrs[key], current.Attrs[key]) {
+			return true
+		}
+	}
+	if !maps.Equal(desired.Labels, current.Labels) {
+		return true
+	}
+
+	disks, err := updateDisks(current, desired)
+	if err != nil {
+		return true
+	}
+	for index, disk := range dataDisks(current) {
+		if disks[index].Keep != disk.Keep {
+			return true
+		}
+	}
+
+	ifaces := Interfaces(current)
+	wanted := Interfaces(desired)
+	if len(wanted) != len(ifaces) {
+		return true
+	}
+	for index, want := range wanted {
+		have := ifaces[index]
+		if !sameIdentity(want, have) || want.MTU != have.MTU || !sameLiveSettings(want, have) {
+			return true
+		}
+	}
+	return !reflect.DeepEqual(PortForwards(desired), PortForwards(current))
+}
+
+// sameIdentity reports whether the wanted interface is the one the VM
+// has. Addresses, MAC and model left unset match the computed ones.
+func sameIdentity(want, have Interface) bool {
+	return want.Network == have.Network &&
+		(want.IP == "" || want.IP == have.IP) &&
+		want.IPv6 == have.IPv6 &&
+		(want.MAC == "" || want.MAC == have.MAC) &&
+		(want.Model == "" || want.Model == have.Model)
+}
+
+// sameLiveSettings reports whether the settings of an interface libvirt
+// changes on a running VM, its bandwidth and filter, are the same.
+func sameLiveSettings(want, have Interface) bool {
+	return reflect.DeepEqual(want.Bandwidth, have.Bandwidth) &&
+		want.Filter == have.Filter &&
+		maps.Equal(want.FilterParams, have.FilterParams)
+}
+
+// updateInPlace applies the changes of an existing VM that libvirt can
+// make without recreating it: the mtu, bandwidth and filter of its interfaces,
+// its port forwards, the keep flag of its disks, and its wait_for and
+// snapshot_policy settings. Any other change fails. The computed state
+// (addresses, disk paths ...) of the VM is kept.
+func updateInPlace(session registry.Session, change registry.Change) error {
+	desired, current := change.Desired, change.Current
+
+	for _, key := range recreateAttrs {
+		if !registry.SameAttr(desired.Attrs[key], current.Attrs[key]) {
+			return fmt.Errorf(
+				"vm %q: %s changed, recreate the VM to apply it",
+				desired.Name,
+				key,
+			)
+		}
+	}
+
+	disks, err := updateDisks(current, desired)
+	if err != nil {
+		return fmt.Errorf("vm %q: %w", desired.Name, err)
+	}
+
+	ifaces := Interfaces(current)
+	wanted := Interfaces(desired)
+	if len(wanted) != len(ifaces) {
+		return fmt.Errorf("vm %q: interfaces changed, recreate the VM to apply it", desired.Name)
+	}
+	for index, want := range wanted {
+		if !sameIdentity(want, ifaces[index]) {
+			return fmt.Errorf(
+				"vm %q: interface %d: only mtu, bandwidth and filter can change in place",
+				desired.Name,
+				index,
+			)
+		}
+	}
+
+	dom, err := session.Conn.DomainLookupByName(desired.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", desired.Name, err)
+	}
+	active, err := session.Conn.DomainIsActive(dom)
+	if err != nil {
+		return fmt.Errorf("get state of domain %q: %w", desired.Name, err)
+	}
+
+	for index, want := range wanted {
+		if err := updateInterface(session, dom, active == 1, &ifaces[index], want); err != nil {
+			return fmt.Errorf("vm %q: interface %d: %w", desired.Name, index, err)
+		}
+	}
+
+	if err := updatePortForwards(session, current, desired, ifaces); err != nil {
+		return err
+	}
+
+	// Keep the computed state, only what can change in place is updated
+	attrs := maps.Clone(current.Attrs)
+	attrs["interfaces"] = interfaceAttrs(ifaces)
+	attrs["disks"] = diskAttrs(disks)
+	attrs["port_forwards"] = desired.Attrs["port_forwards"]
+	for _, key := range settingAttrs {
+		if value, ok := desired.Attrs[key]; ok {
+			attrs[key] = value
+		} else {
+			delete(attrs, key)
+		}
+	}
+	desired.Attrs = attrs
+	desired.Status = current.Status
+	return nil
+}
+
+// updateDisks returns the disks of the current VM with the keep flag of
+// the desired ones. Any other change of the disk blocks needs a new VM.
+// Disks flattened or rebased since, i.e. with a base_image, no longer
+// match the image they were created from: their image is not compared.
+func updateDisks(current, desired *registry.Object) ([]dataDisk, error) {
+	disks := dataDisks(current)
+	wanted := dataDisks(desired)
+	if len(wanted) != len(disks) {
+		return nil, fmt.Errorf("disks changed, recreate the VM to apply it")
+	}
+	_, rebased := current.Attrs["base_image"]
+	for index, want := range wanted {
+		have := disks[index]
+		if want.Bus == "" {
+			want.Bus = DiskDefaults.Bus
+		}
+		if want.Format == "" {
+			want.Format = DiskDefaults.Format
+		}
+		if rebased {
+			want.Image = have.Image
+		}
+		if want.Name != have.Name || want.Size != have.Size || want.Bus != have.Bus ||
+			want.Format != have.Format || want.Cache != have.Cache || want.Image != have.Image {
+			return nil, fmt.Errorf(
+				"disk %q: only keep can change in place, recreate the VM to apply it",
+				want.Name,
+			)
+		}
+		disks[index].Keep = want.Keep
+	}
+	return disks, nil
+}
+
+// updatePortForwards replaces the port forwards of the VM with the
+// desired ones, if they changed.
+func updatePortForwards(
+	session registry.Session,
+	current, desired *registry.Object,
+	ifaces []Interface,
+) error {
+	forwards := PortForwards(desired)
+	if reflect.DeepEqual(forwards, PortForwards(current)) {
+		return nil
+	}
+	if len(forwards) == 0 {
+		if err := removePortForwards(session.Ctx, desired.Name, desired.Namespace); err != nil {
+			return fmt.Errorf("vm %q: remove port forwards: %w", desired.Name, err)
+		}
+		return nil
+	}
+	if ifaces[0].IP == "" {
+		return fmt.Errorf("vm %q: port_forward requires an IPv4 address on the first interface", desired.Name)
+	}
+	if err := checkForwardClashes(session, desired, forwards); err != nil {
+		return err
+	}
+	err := addPortForwards(session.Ctx, desired.Name, desired.Namespace, ifaces[0].IP, forwards)
+	if err != nil {
+		return fmt.Errorf("vm %q: port forwards: %w", desired.Name, err)
+	}
+	return nil
+}
+
+// updateInterface changes the mtu, bandwidth and filter of iface to the
+// wanted ones. Bandwidth and filter changes apply to a running VM
+// immediately, libvirt cannot change the mtu of a live interface: it
+// applies on next boot.
+func updateInterface(
+	session registry.Session,
+	dom libvirt.Domain,
+	active bool,
+	iface *Interface,
+	want Interface,
+) error {
+	mtuChanged := want.MTU != iface.MTU
+	liveChanged := !sameLiveSettings(want, *iface)
+	if !mtuChanged && !liveChanged {
+		return nil
+	}
+
+	if active && liveChanged {
+		live := *iface
+		live.Bandwidth = want.Bandwidth
+		live.Filter, live.FilterParams = want.Filter, want.FilterParams
+		if err := updateDevice(session, dom, live, libvirt.DomainDeviceModifyLive); err != nil {
+			return err
+		}
+	}
+	if active && mtuChanged {
+		logger.Warnf("mtu of interface %s changes on the next boot of the VM", iface.MAC)
+	}
+
+	iface.MTU = want.MTU
+	iface.Bandwidth = want.Bandwidth
+	iface.Filter, iface.FilterParams = want.Filter, want.FilterParams
+	return updateDevice(session, dom, *iface, libvirt.DomainDeviceModifyConfig)
+}
+
+func updateDevice(
+	session registry.Session,
+	dom libvirt.Domain,
+	iface Interface,
+	flags libvirt.DomainDeviceModifyFlags,
+) error {
+	deviceXML, err := iface.device().GenerateXML()
+	if err != nil {
+		return fmt.Errorf("generate interface XML: %w", err)
+	}
+	if err := session.Conn.DomainUpdateDeviceFlags(dom, string(deviceXML), flags); err != nil {
+		return fmt.Errorf("update interface %s: %w", iface.MAC, err)
+	}
+	return nil
+}
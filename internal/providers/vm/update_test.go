@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+func TestUpdateDisks(t *testing.T) {
+	// provisioned is a disk as stored after provisioning, with its
+	// defaults filled in and its computed path and target
+	provisioned := func(name string, keep bool) map[string]any {
+		return dataDisk{
+			Name:   name,
+			Size:   "10G",
+			Bus:    DiskDefaults.Bus,
+			Format: DiskDefaults.Format,
+			Image:  "rocky-9.5",
+			Keep:   keep,
+			Path:   "/var/lib/kvmcli/web-" + name + ".qcow2",
+			Target: "vdb",
+		}.attrs()
+	}
+	// declared is a disk as read from the file, defaults left empty
+	declared := func(name string, keep bool) map[string]any {
+		return map[string]any{"name": name, "size": "10G", "image": "rocky-9.5", "keep": keep}
+	}
+	with := func(disk map[string]any, key string, value any) map[string]any {
+		disk[key] = value
+		return disk
+	}
+
+	tests := []struct {
+		name    string
+		current []map[string]any
+		desired []map[string]any
+		// rebased marks a VM flattened or rebased since its creation
+		rebased  bool
+		wantKeep []bool
+		wantErr  bool
+	}{
+		{
+			name:     "no disk",
+			wantKeep: []bool{},
+		},
+		{
+			name:     "unchanged",
+			current:  []map[string]any{provisioned("data", false)},
+			desired:  []map[string]any{declared("data", false)},
+			wantKeep: []bool{false},
+		},
+		{
+			name:     "keep changed",
+			current:  []map[string]any{provisioned("data", false), provisioned("logs", true)},
+			desired:  []map[string]any{declared("data", true), declared("logs", false)},
+			wantKeep: []bool{true, false},
+		},
+		{
+			name:     "defaults spelled out",
+			current:  []map[string]any{provisioned("data", false)},
+			desired:  []map[string]any{with(declared("data", false), "bus", DiskDefaults.Bus)},
+			wantKeep: []bool{false},
+		},
+		{
+			name:     "image of a rebased VM",
+			current:  []map[string]any{provisioned("data", false)},
+			desired:  []map[string]any{with(declared("data", false), "image", "rocky-10")},
+			rebased:  true,
+			wantKeep: []bool{false},
+		},
+		{
+			name:    "disk added",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{declared("data", false), declared("logs", false)},
+			wantErr: true,
+		},
+		{
+			name:    "disk removed",
+			current: []map[string]any{provisioned("data", false)},
+			wantErr: true,
+		},
+		{
+			name:    "disk renamed",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{declared("logs", false)},
+			wantErr: true,
+		},
+		{
+			name:    "size changed",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{with(declared("data", false), "size", "20G")},
+			wantErr: true,
+		},
+		{
+			name:    "bus changed",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{with(declared("data", false), "bus", "sata")},
+			wantErr: true,
+		},
+		{
+			name:    "cache changed",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{with(declared("data", false), "cache", "none")},
+			wantErr: true,
+		},
+		{
+			name:    "image changed",
+			current: []map[string]any{provisioned("data", false)},
+			desired: []map[string]any{with(declared("data", false), "image", "rocky-10")},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := &registry.Object{Name: "web", Attrs: map[string]any{"disks": test.current}}
+			if test.rebased {
+				current.Attrs["base_image"] = "rocky-9.5"
+			}
+			desired := &registry.Object{Name: "web", Attrs: map[string]any{"disks": test.desired}}
+
+			disks, err := updateDisks(current, desired)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("updateDisks() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("updateDisks() error = %v", err)
+			}
+			if len(disks) != len(test.wantKeep) {
+				t.Fatalf("updateDisks() returned %d disks, want %d", len(disks), len(test.wantKeep))
+			}
+			for index, disk := range disks {
+				// The provisioned disk is kept, only its keep flag changes
+				want := dataDisks(current)[index]
+				want.Keep = test.wantKeep[index]
+				if disk != want {
+					t.Errorf("disk %d = %+v, want %+v", index, disk, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Conditions a VM can be waited for.
+const (
+	WaitIP       = "ip"
+	WaitSSH      = "ssh"
+	WaitAgent    = "agent"
+	WaitShutdown = "shutdown"
+)
+
+// WaitConditions lists the valid conditions, for flags and errors.
+var WaitConditions = []string{WaitIP, WaitSSH, WaitAgent, WaitShutdown}
+
+// Polling backoff: the first check is immediate, then the delay doubles
+// up to maxWaitDelay.
+const (
+	minWaitDelay = time.Second
+	maxWaitDelay = 10 * time.Second
+	dialTimeout  = 3 * time.Second
+)
+
+// ErrWaitTimeout is returned when a condition is not reached in time.
+var ErrWaitTimeout = errors.New("timed out")
+
+// WaitSpec reads the wait_for attribute of a VM: the conditions to wait
+// for after creation and their timeout. ok is false without wait_for.
+func WaitSpec(spec *registry.Object) (conditions []string, timeout time.Duration, ok bool) {
+	wait, ok := spec.Attrs["wait_for"].(map[string]any)
+	if !ok {
+		return nil, 0, false
+	}
+	// In checking order: an address comes before ssh
+	for _, field := range []struct{ key, condition string }{
+		{"ip", WaitIP},
+		{"ssh", WaitSSH},
+		{"guest_agent", WaitAgent},
+	} {
+		if enabled, _ := wait[field.key].(bool); enabled {
+			conditions = append(conditions, field.condition)
+		}
+	}
+
+	timeoutStr, _ := wait["timeout"].(string)
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		timeout = 5 * time.Minute
+	}
+	return conditions, timeout, len(conditions) > 0
+}
+
+// Wait blocks until the VM reaches every condition, or timeout expires.
+// Conditions are checked one after the other, with a shared deadline.
+func Wait(
+	session registry.Session,
+	spec *registry.Object,
+	conditions []string,
+	timeout time.Duration,
+) error {
+	ctx, cancel := context.WithTimeout(session.Ctx, timeout)
+	defer cancel()
+
+	dom, err := session.Conn.DomainLookupByName(spec.Name)
+	if err != nil {
+		return fmt.Errorf("lookup domain %q: %w", spec.Name, err)
+	}
+
+	for _, condition := range conditions {
+		var check func() (bool, error)
+		switch condition {
+		case WaitIP:
+			check = func() (bool, error) {
+				ip, err := guestAddress(session, spec)
+				return ip != "", err
+			}
+		case WaitSSH:
+			check = func() (bool, error) {
+				ip, err := guestAddress(session, spec)
+				if ip == "" || err != nil {
+					return false, err
+				}
+				return sshReady(ctx, ip), nil
+			}
+		case WaitAgent:
+			check = func() (bool, error) {
+				_, err := session.Conn.QEMUDomainAgentCommand(
+					dom, `{"execute":"guest-ping"}`, agentTimeout, 0,
+				)
+				return err == nil, nil
+			}
+		case WaitShutdown:
+			check = func() (bool, error) {
+				state, _, err := session.Conn.DomainGetState(dom, 0)
+				if err != nil {
+					return false, fmt.Errorf("get state of domain %q: %w", spec.Name, err)
+				}
+				return libvirt.DomainState(state) == libvirt.DomainShutoff, nil
+			}
+		default:
+			return fmt.Errorf(
+				"unknown condition %q, expected one of %s",
+				condition,
+				strings.Join(WaitConditions, ", "),
+			)
+		}
+
+		if err := poll(ctx, check); err != nil {
+			return fmt.Errorf("wait for %s of vm %q: %w", condition, spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// poll runs check with exponential backoff until it succeeds, fails or
+// the context is done.
+func poll(ctx context.Context, check func() (bool, error)) error {
+	delay := minWaitDelay
+	for {
+		ok, err := check()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrWaitTimeout
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay = min(delay*2, maxWaitDelay)
+	}
+}
+
+// guestAddress returns the address the primary interface of the VM
+// uses, as discovered through leases, ARP or the guest agent. IPv4 is
+// preferred. An empty address means none was seen yet.
+func guestAddress(session registry.Session, spec *registry.Object) (string, error) {
+	ifaces := Interfaces(spec)
+	if len(ifaces) == 0 {
+		return "", fmt.Errorf("vm %q has no network interface", spec.Name)
+	}
+	mac := strings.ToLower(ifaces[0].MAC)
+
+	discovered, err := DiscoverAddresses(session, spec.Name)
+	if err != nil {
+		return "", err
+	}
+	address := ""
+	for _, addr := range discovered {
+		if addr.MAC != mac {
+			continue
+		}
+		if net.ParseIP(addr.IP).To4() != nil {
+			return addr.IP, nil
+		}
+		if address == "" {
+			address = addr.IP
+		}
+	}
+	return address, nil
+}
+
+// sshReady reports whether an SSH server answers on ip: the port must
+// accept connections and send the SSH banner.
+func sshReady(ctx context.Context, ip string) bool {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, "22"))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	return err == nil && strings.HasPrefix(banner, "SSH-")
+}
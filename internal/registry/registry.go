@@ -11,6 +11,10 @@ type ResourceType struct {
 	Lifecycle ObjectLifecycle
 	Columns   []string
 	Format    func(Object) []string
+	// WideColumns and WideFormat add the columns of the wide output
+	// (-o wide). They may query libvirt for live information.
+	WideColumns []string
+	WideFormat  func(Session, Object) []string
 }
 
 // TODO: will be changed later to "ObjectLifeCycle"
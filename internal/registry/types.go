@@ -1,8 +1,10 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 
 	"github.com/digitalocean/go-libvirt"
 )
@@ -12,6 +14,9 @@ type Session struct {
 	Ctx  context.Context
 	DB   *sql.DB
 	Conn *libvirt.Libvirt
+	// Force lets a store be deleted, or an image leave its catalog, while
+	// VM disks still use the image files as backing files. Those VMs break.
+	Force bool
 }
 
 type Action int
@@ -55,11 +60,75 @@ func (o *Object) GetString(key string) string {
 }
 
 func (o *Object) GetInt(key string) int {
-	value, _ := o.Attrs[key].(int)
-	return value
+	return AsInt(o.Attrs[key])
+}
+
+// AsInt converts a numeric attribute to int. Attributes decoded back
+// from the database are float64.
+func AsInt(value any) int {
+	switch number := value.(type) {
+	case int:
+		return number
+	case int64:
+		return int(number)
+	case float64:
+		return int(number)
+	}
+	return 0
 }
 
 func (o *Object) GetBool(key string) bool {
 	value, _ := o.Attrs[key].(bool)
 	return value
 }
+
+// GetList returns a list of nested blocks (disks, interfaces ...etc).
+func (o *Object) GetList(key string) []map[string]any {
+	return AsList(o.Attrs[key])
+}
+
+// AsList converts a list of nested blocks. It accepts both the freshly
+// built form ([]map[string]any) and the form decoded back from the
+// database ([]any).
+func AsList(value any) []map[string]any {
+	switch list := value.(type) {
+	case []map[string]any:
+		return list
+	case []any:
+		items := make([]map[string]any, 0, len(list))
+		for _, raw := range list {
+			if item, ok := raw.(map[string]any); ok {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// AsStrings converts a list of strings, freshly built ([]string) or
+// decoded back from the database ([]any).
+func AsStrings(value any) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []any:
+		items := make([]string, 0, len(list))
+		for _, raw := range list {
+			if item, ok := raw.(string); ok {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// SameAttr reports whether two attribute values are equal once stored:
+// a freshly built value (int, []string ...) equals its form decoded back
+// from the database (float64, []any ...).
+func SameAttr(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
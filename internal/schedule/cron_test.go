@@ -0,0 +1,151 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"too few fields", "* * * *"},
+		{"too many fields", "* * * * * *"},
+		{"unknown macro", "@sometimes"},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "* 24 * * *"},
+		{"day of month zero", "* * 0 * *"},
+		{"month out of range", "* * * 13 *"},
+		{"day of week out of range", "* * * * 8"},
+		{"reversed range", "5-1 * * * *"},
+		{"zero step", "*/0 * * * *"},
+		{"invalid step", "*/x * * * *"},
+		{"invalid value", "a * * * *"},
+		{"invalid range end", "1-b * * * *"},
+		{"empty list item", "1,,2 * * * *"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.expr); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", test.expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2026-01-01 is a Thursday
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every 15 minutes",
+			expr: "*/15 * * * *",
+			from: at(time.January, 1, 10, 7),
+			want: at(time.January, 1, 10, 15),
+		},
+		{
+			name: "strictly after a matching time",
+			expr: "0 0 * * *",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.January, 2, 0, 0),
+		},
+		{
+			name: "seconds are ignored",
+			expr: "@hourly",
+			from: at(time.January, 1, 10, 59).Add(30 * time.Second),
+			want: at(time.January, 1, 11, 0),
+		},
+		{
+			name: "step from a start value",
+			expr: "5/20 * * * *",
+			from: at(time.January, 1, 10, 46),
+			want: at(time.January, 1, 11, 5),
+		},
+		{
+			name: "range with a step",
+			expr: "0 9-17/4 * * *",
+			from: at(time.January, 1, 10, 0),
+			want: at(time.January, 1, 13, 0),
+		},
+		{
+			name: "Sunday as 0",
+			expr: "30 2 * * 0",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.January, 4, 2, 30),
+		},
+		{
+			name: "Sunday as 7",
+			expr: "30 2 * * 7",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.January, 4, 2, 30),
+		},
+		{
+			name: "weekdays skip the weekend",
+			expr: "0 0 * * 1-5",
+			from: at(time.January, 2, 12, 0),
+			want: at(time.January, 5, 0, 0),
+		},
+		{
+			name: "day of month alone",
+			expr: "0 0 13 * *",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.January, 13, 0, 0),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 13 * 5",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.January, 2, 0, 0),
+		},
+		{
+			name: "day of month or day of week, the day of month first",
+			expr: "0 0 13 * 5",
+			from: at(time.January, 10, 0, 0),
+			want: at(time.January, 13, 0, 0),
+		},
+		{
+			name: "month list",
+			expr: "0 0 1 1,7 *",
+			from: at(time.January, 1, 0, 0),
+			want: at(time.July, 1, 0, 0),
+		},
+		{
+			name: "next month",
+			expr: "0 0 * 2 *",
+			from: at(time.January, 31, 23, 0),
+			want: at(time.February, 1, 0, 0),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: at(time.January, 1, 0, 0),
+			want: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day that never comes",
+			expr: "0 0 30 2 *",
+			from: at(time.January, 1, 0, 0),
+			want: time.Time{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := Parse(test.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expr, err)
+			}
+			if got := schedule.Next(test.from); !got.Equal(test.want) {
+				t.Errorf("Next(%s) = %s, want %s", test.from, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package templates
+
+import (
+	"encoding/xml"
+)
+
+// DomainBackup represents a libvirt backup job (<domainbackup>). kvmcli
+// only runs push mode backups: libvirt writes each disk to a file.
+type DomainBackup struct {
+	XMLName xml.Name     `xml:"domainbackup"`
+	Mode    string       `xml:"mode,attr"`
+	Disks   []BackupDisk `xml:"disks>disk"`
+}
+
+// BackupDisk selects a disk of the domain, by target device name, and
+// the file its backup is written to.
+type BackupDisk struct {
+	Name   string        `xml:"name,attr"`
+	Backup string        `xml:"backup,attr"`
+	Type   string        `xml:"type,attr,omitempty"`
+	Target *BackupTarget `xml:"target,omitempty"`
+	Driver *BackupDriver `xml:"driver,omitempty"`
+}
+
+// BackupTarget is the file a disk backup is written to.
+type BackupTarget struct {
+	File string `xml:"file,attr"`
+}
+
+// BackupDriver sets the format of a backup file.
+type BackupDriver struct {
+	Type string `xml:"type,attr"`
+}
+
+// NewPushBackup returns a push mode backup with no disk selected yet.
+func NewPushBackup() *DomainBackup {
+	return &DomainBackup{Mode: "push"}
+}
+
+// AddDisk backs up the disk with target device name to a qcow2 file.
+func (b *DomainBackup) AddDisk(name, file string) {
+	b.Disks = append(b.Disks, BackupDisk{
+		Name:   name,
+		Backup: "yes",
+		Type:   "file",
+		Target: &BackupTarget{File: file},
+		Driver: &BackupDriver{Type: "qcow2"},
+	})
+}
+
+// SkipDisk leaves the disk with target device name out of the backup.
+func (b *DomainBackup) SkipDisk(name string) {
+	b.Disks = append(b.Disks, BackupDisk{Name: name, Backup: "no"})
+}
+
+// GenerateXML returns the XML representation of the backup.
+func (b *DomainBackup) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(b, "", "  ")
+}
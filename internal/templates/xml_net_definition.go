@@ -11,25 +11,150 @@ type Network struct {
 	Name    string   `xml:"name"`
 	Bridge  *Bridge  `xml:"bridge,omitempty"`
 	Forward *Forward `xml:"forward,omitempty"`
-	IP      IP       `xml:"ip"`
+	// MTU and Bandwidth are omitted if nil.
+	MTU       *MTU       `xml:"mtu,omitempty"`
+	Bandwidth *Bandwidth `xml:"bandwidth,omitempty"`
+	// Domain and DNS are omitted if nil.
+	Domain *NetworkDomain `xml:"domain,omitempty"`
+	DNS    *DNS           `xml:"dns,omitempty"`
+	// IPs is empty for networks without a libvirt managed subnet (bridge, macvtap).
+	IPs    []IP    `xml:"ip,omitempty"`
+	Routes []Route `xml:"route,omitempty"`
 }
 
+// Forward modes, as written in HCL. Isolated networks have no <forward>,
+// macvtap is a libvirt "bridge" forward onto a host device.
+const (
+	ForwardNAT      = "nat"
+	ForwardRoute    = "route"
+	ForwardIsolated = "isolated"
+	ForwardOpen     = "open"
+	ForwardBridge   = "bridge"
+	ForwardMacvtap  = "macvtap"
+)
+
 // Bridge represents the <bridge> element.
 type Bridge struct {
 	Name string `xml:"name,attr"`
 }
 type Forward struct {
-	Mode string `xml:"mode,attr"`
+	Mode       string             `xml:"mode,attr"`
+	Dev        string             `xml:"dev,attr,omitempty"`
+	NAT        *NAT               `xml:"nat,omitempty"`
+	Interfaces []ForwardInterface `xml:"interface,omitempty"`
+}
+
+// NAT represents the <nat> element of a NAT forward.
+type NAT struct {
+	Port *PortRange `xml:"port,omitempty"`
+}
+
+// PortRange is the range of source ports used for NAT.
+type PortRange struct {
+	Start int `xml:"start,attr"`
+	End   int `xml:"end,attr"`
+}
+
+// ForwardInterface is a host device used by a macvtap network.
+type ForwardInterface struct {
+	Dev string `xml:"dev,attr"`
+}
+
+// MTU represents the <mtu> element of networks and interfaces.
+type MTU struct {
+	Size int `xml:"size,attr"`
+}
+
+// Bandwidth represents the <bandwidth> element of networks and interfaces.
+// Average and peak are in kilobytes per second, burst in kilobytes.
+type Bandwidth struct {
+	Inbound  *Rate `xml:"inbound,omitempty"`
+	Outbound *Rate `xml:"outbound,omitempty"`
+}
+
+// Rate is the traffic shaping of one direction.
+type Rate struct {
+	Average int `xml:"average,attr"`
+	Peak    int `xml:"peak,attr,omitempty"`
+	Burst   int `xml:"burst,attr,omitempty"`
+}
+
+// NetworkDomain is the DNS domain of a network. Guests get it through
+// DHCP and names under it are resolved by dnsmasq only (localOnly).
+type NetworkDomain struct {
+	Name      string `xml:"name,attr"`
+	LocalOnly string `xml:"localOnly,attr,omitempty"`
+}
+
+// DNS represents the <dns> element served by the network dnsmasq.
+type DNS struct {
+	Forwarders []DNSForwarder `xml:"forwarder,omitempty"`
+	TXTs       []DNSTXT       `xml:"txt,omitempty"`
+	Hosts      []DNSHost      `xml:"host,omitempty"`
+	SRVs       []DNSSRV       `xml:"srv,omitempty"`
+}
+
+// DNSForwarder is an upstream DNS server.
+type DNSForwarder struct {
+	Addr string `xml:"addr,attr"`
+}
+
+// DNSTXT is a TXT record.
+type DNSTXT struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// DNSHost gives A/AAAA records to one address.
+type DNSHost struct {
+	IP        string   `xml:"ip,attr"`
+	Hostnames []string `xml:"hostname"`
+}
+
+// DNSSRV is a SRV record (_service._protocol.domain).
+type DNSSRV struct {
+	Service  string `xml:"service,attr"`
+	Protocol string `xml:"protocol,attr"`
+	Domain   string `xml:"domain,attr,omitempty"`
+	Target   string `xml:"target,attr,omitempty"`
+	Port     int    `xml:"port,attr,omitempty"`
+	Priority int    `xml:"priority,attr,omitempty"`
+	Weight   int    `xml:"weight,attr,omitempty"`
+}
+
+// Route is a static route added when the network starts.
+type Route struct {
+	Address string `xml:"address,attr"`
+	Prefix  int    `xml:"prefix,attr"`
+	Gateway string `xml:"gateway,attr"`
 }
 type IP struct {
+	Family  string `xml:"family,attr,omitempty"`
 	Address string `xml:"address,attr"`
-	Netmask string `xml:"netmask,attr"`
+	Netmask string `xml:"netmask,attr,omitempty"`
+	Prefix  int    `xml:"prefix,attr,omitempty"`
 	// DHCP is omitted if nil.
 	DHCP *DHCP `xml:"dhcp,omitempty"`
 }
 
+// IP families of the <ip> element.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
 type DHCP struct {
-	Range Range `xml:"range"`
+	Range Range      `xml:"range"`
+	Hosts []DHCPHost `xml:"host,omitempty"`
+}
+
+// DHCPHost is a static reservation inside <dhcp>. IPv4 hosts are keyed
+// by MAC, DHCPv6 hosts by client DUID (id) and/or name.
+type DHCPHost struct {
+	MAC  string `xml:"mac,attr,omitempty"`
+	ID   string `xml:"id,attr,omitempty"`
+	Name string `xml:"name,attr,omitempty"`
+	IP   string `xml:"ip,attr"`
 }
 
 type Range struct {
@@ -53,7 +178,10 @@ func WithBridge(bridgeName string) NetworkOption {
 
 func WithDHCP(start, end string) NetworkOption {
 	return func(n *Network) {
-		n.IP.DHCP = &DHCP{
+		if len(n.IPs) == 0 {
+			return
+		}
+		n.IPs[0].DHCP = &DHCP{
 			Range: Range{
 				Start: start,
 				End:   end,
@@ -62,8 +190,87 @@ func WithDHCP(start, end string) NetworkOption {
 	}
 }
 
+// WithIP adds an extra <ip> element, e.g. an IPv6 prefix next to the
+// primary IPv4 subnet. Empty start/end leave DHCP off; on IPv6 prefixes
+// libvirt sends router advertisements either way.
+func WithIP(family, address string, prefix int, start, end string) NetworkOption {
+	return func(n *Network) {
+		ip := IP{Family: family, Address: address, Prefix: prefix}
+		if start != "" && end != "" {
+			ip.DHCP = &DHCP{Range: Range{Start: start, End: end}}
+		}
+		n.IPs = append(n.IPs, ip)
+	}
+}
+
+// WithMTU sets the MTU of the network bridge.
+func WithMTU(size int) NetworkOption {
+	return func(n *Network) {
+		n.MTU = &MTU{Size: size}
+	}
+}
+
+// WithBandwidth limits the overall traffic of the network.
+func WithBandwidth(bandwidth *Bandwidth) NetworkOption {
+	return func(n *Network) {
+		n.Bandwidth = bandwidth
+	}
+}
+
+// WithDomain sets the DNS domain of the network.
+func WithDomain(name string) NetworkOption {
+	return func(n *Network) {
+		n.Domain = &NetworkDomain{Name: name, LocalOnly: "yes"}
+	}
+}
+
+// WithDNS sets the <dns> element. Empty records leave it out.
+func WithDNS(dns DNS) NetworkOption {
+	return func(n *Network) {
+		if len(dns.Forwarders)+len(dns.TXTs)+len(dns.Hosts)+len(dns.SRVs) == 0 {
+			return
+		}
+		n.DNS = &dns
+	}
+}
+
+// WithForwardDev sets the host device traffic leaves through. For macvtap
+// networks (a "bridge" forward without <bridge>) it is the device guests
+// attach to, for the others it restricts the forward to that device.
+func WithForwardDev(dev string) NetworkOption {
+	return func(n *Network) {
+		if n.Forward == nil {
+			return
+		}
+		if n.Forward.Mode == ForwardBridge {
+			n.Forward.Interfaces = append(n.Forward.Interfaces, ForwardInterface{Dev: dev})
+			return
+		}
+		n.Forward.Dev = dev
+	}
+}
+
+// WithNATPorts restricts the source ports of a NAT network.
+func WithNATPorts(start, end int) NetworkOption {
+	return func(n *Network) {
+		if n.Forward == nil || n.Forward.Mode != ForwardNAT {
+			return
+		}
+		n.Forward.NAT = &NAT{Port: &PortRange{Start: start, End: end}}
+	}
+}
+
+// WithRoute adds a static route to address/prefix via gateway.
+func WithRoute(address string, prefix int, gateway string) NetworkOption {
+	return func(n *Network) {
+		n.Routes = append(n.Routes, Route{Address: address, Prefix: prefix, Gateway: gateway})
+	}
+}
+
 // NewNetwork is the constructor that creates a new Network instance.
 // it takes required parameters: name, forwardMode, ipAddress, and netmask.
+// An empty or "isolated" mode produces no <forward>, an empty ipAddress
+// produces no <ip> (bridge and macvtap networks).
 // Addtional optional configurations can be provided using variadic options.
 
 func NewNetwork(
@@ -74,14 +281,20 @@ func NewNetwork(
 	// Create the network with required fields.
 	network := &Network{
 		Name: name,
-		Forward: &Forward{
-			Mode: forwardMode,
-		},
-		// DHCP is nil by default, meaning it will be omitted unless enabled.
-		IP: IP{
+	}
+	switch forwardMode {
+	case "", ForwardIsolated:
+	case ForwardMacvtap:
+		network.Forward = &Forward{Mode: ForwardBridge}
+	default:
+		network.Forward = &Forward{Mode: forwardMode}
+	}
+	// DHCP is nil by default, meaning it will be omitted unless enabled.
+	if ipAddress != "" {
+		network.IPs = []IP{{
 			Address: ipAddress,
 			Netmask: netmask,
-		},
+		}}
 	}
 	for _, opt := range opts {
 		opt(network)
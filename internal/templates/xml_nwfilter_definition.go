@@ -0,0 +1,73 @@
+package templates
+
+import (
+	"encoding/xml"
+)
+
+// Filter represents a libvirt network filter (<filter>).
+type Filter struct {
+	XMLName    xml.Name     `xml:"filter"`
+	Name       string       `xml:"name,attr"`
+	Chain      string       `xml:"chain,attr,omitempty"`
+	Priority   int          `xml:"priority,attr,omitempty"`
+	FilterRefs []FilterRef  `xml:"filterref,omitempty"`
+	Rules      []FilterRule `xml:"rule,omitempty"`
+}
+
+// FilterRef includes another filter. Inside a domain <interface> it
+// attaches a filter to the interface, with its variables (IP, MAC ...).
+type FilterRef struct {
+	Filter     string            `xml:"filter,attr"`
+	Parameters []FilterParameter `xml:"parameter,omitempty"`
+}
+
+// FilterParameter sets a filter variable.
+type FilterParameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// FilterRule is a <rule> of a filter. Lower priorities are evaluated first.
+type FilterRule struct {
+	Action    string         `xml:"action,attr"`
+	Direction string         `xml:"direction,attr"`
+	Priority  int            `xml:"priority,attr,omitempty"`
+	Match     *ProtocolMatch `xml:",omitempty"`
+}
+
+// ProtocolMatch is the protocol element of a rule (<tcp>, <udp>, <icmp>,
+// <all> ...). Its XMLName is set to the protocol.
+type ProtocolMatch struct {
+	XMLName      xml.Name
+	SrcIPAddr    string `xml:"srcipaddr,attr,omitempty"`
+	SrcIPMask    int    `xml:"srcipmask,attr,omitempty"`
+	DstIPAddr    string `xml:"dstipaddr,attr,omitempty"`
+	DstIPMask    int    `xml:"dstipmask,attr,omitempty"`
+	DstPortStart int    `xml:"dstportstart,attr,omitempty"`
+	DstPortEnd   int    `xml:"dstportend,attr,omitempty"`
+}
+
+// NewFilter returns an empty filter. An empty chain means root.
+func NewFilter(name, chain string, priority int) *Filter {
+	return &Filter{Name: name, Chain: chain, Priority: priority}
+}
+
+// AddFilterRef includes another filter, e.g. clean-traffic.
+func (f *Filter) AddFilterRef(name string) {
+	f.FilterRefs = append(f.FilterRefs, FilterRef{Filter: name})
+}
+
+// AddRule appends a rule matching protocol.
+func (f *Filter) AddRule(action, direction string, priority int, match ProtocolMatch) {
+	f.Rules = append(f.Rules, FilterRule{
+		Action:    action,
+		Direction: direction,
+		Priority:  priority,
+		Match:     &match,
+	})
+}
+
+// GenerateXML returns the XML representation of the filter.
+func (f *Filter) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(f, "", "  ")
+}
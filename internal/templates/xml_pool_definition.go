@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"encoding/xml"
+)
+
+// StoragePool represents a libvirt storage pool (<pool>). kvmcli only
+// defines directory pools.
+type StoragePool struct {
+	XMLName xml.Name   `xml:"pool"`
+	Type    string     `xml:"type,attr"`
+	Name    string     `xml:"name"`
+	Target  PoolTarget `xml:"target"`
+}
+
+// PoolTarget is the directory a dir pool manages.
+type PoolTarget struct {
+	Path string `xml:"path"`
+}
+
+// NewDirPool returns a directory pool managing path.
+func NewDirPool(name, path string) *StoragePool {
+	return &StoragePool{Type: "dir", Name: name, Target: PoolTarget{Path: path}}
+}
+
+// GenerateXML returns the XML representation of the pool.
+func (p *StoragePool) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(p, "", "  ")
+}
+
+// StorageVolume represents a volume of a storage pool (<volume>).
+type StorageVolume struct {
+	XMLName      xml.Name            `xml:"volume"`
+	Name         string              `xml:"name"`
+	Capacity     VolumeCapacity      `xml:"capacity"`
+	Target       VolumeTarget        `xml:"target"`
+	BackingStore *VolumeBackingStore `xml:"backingStore,omitempty"`
+}
+
+// VolumeCapacity is the virtual size of a volume.
+type VolumeCapacity struct {
+	Unit  string `xml:"unit,attr"`
+	Value int64  `xml:",chardata"`
+}
+
+// VolumeTarget sets the format of a volume.
+type VolumeTarget struct {
+	Format VolumeFormat `xml:"format"`
+}
+
+// VolumeFormat is a volume format, e.g. qcow2.
+type VolumeFormat struct {
+	Type string `xml:"type,attr"`
+}
+
+// VolumeBackingStore is the image a copy-on-write volume reads through.
+type VolumeBackingStore struct {
+	Path   string       `xml:"path"`
+	Format VolumeFormat `xml:"format"`
+}
+
+// NewOverlayVolume returns a qcow2 volume of the given virtual size on
+// top of the qcow2 image at backing.
+func NewOverlayVolume(name, backing string, capacity int64) *StorageVolume {
+	return &StorageVolume{
+		Name:     name,
+		Capacity: VolumeCapacity{Unit: "bytes", Value: capacity},
+		Target:   VolumeTarget{Format: VolumeFormat{Type: "qcow2"}},
+		BackingStore: &VolumeBackingStore{
+			Path:   backing,
+			Format: VolumeFormat{Type: "qcow2"},
+		},
+	}
+}
+
+// GenerateXML returns the XML representation of the volume.
+func (v *StorageVolume) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(v, "", "  ")
+}
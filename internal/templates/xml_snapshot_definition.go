@@ -0,0 +1,43 @@
+package templates
+
+import (
+	"encoding/xml"
+)
+
+// DomainSnapshot represents a libvirt domain snapshot (<domainsnapshot>).
+type DomainSnapshot struct {
+	XMLName     xml.Name       `xml:"domainsnapshot"`
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	Disks       *SnapshotDisks `xml:"disks,omitempty"`
+}
+
+// SnapshotDisks selects how each disk of the domain is snapshotted.
+type SnapshotDisks struct {
+	Disks []SnapshotDisk `xml:"disk"`
+}
+
+// SnapshotDisk sets the snapshot mode of one disk, by target name:
+// internal, external or no.
+type SnapshotDisk struct {
+	Name     string `xml:"name,attr"`
+	Snapshot string `xml:"snapshot,attr"`
+}
+
+// NewDomainSnapshot returns a snapshot covering every disk of the domain.
+func NewDomainSnapshot(name, description string) *DomainSnapshot {
+	return &DomainSnapshot{Name: name, Description: description}
+}
+
+// ExcludeDisk leaves a disk out of the snapshot.
+func (s *DomainSnapshot) ExcludeDisk(target string) {
+	if s.Disks == nil {
+		s.Disks = &SnapshotDisks{}
+	}
+	s.Disks.Disks = append(s.Disks.Disks, SnapshotDisk{Name: target, Snapshot: "no"})
+}
+
+// GenerateXML returns the XML representation of the snapshot.
+func (s *DomainSnapshot) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(s, "", "  ")
+}
@@ -105,8 +105,8 @@ type VMPort struct {
 type Devices struct {
 	Emulator    string       `xml:"emulator"`
 	Controllers []Controller `xml:"controller"`
-	Disk        Disk         `xml:"disk"`
-	Interface   Interface    `xml:"interface"`
+	Disks       []Disk       `xml:"disk"`
+	Interfaces  []Interface  `xml:"interface"`
 	Channel     Channel      `xml:"channel"`
 	Serial      Serial       `xml:"serial"`
 	Console     Console      `xml:"console"`
@@ -133,8 +133,9 @@ type Disk struct {
 
 // DiskDriver represents the disk driver configuration
 type DiskDriver struct {
-	Name string `xml:"name,attr"`
-	Type string `xml:"type,attr"`
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr"`
+	Cache string `xml:"cache,attr,omitempty"`
 }
 
 // DiskSource represents the source file for the disk
@@ -154,6 +155,10 @@ type Interface struct {
 	MAC    MACAddress `xml:"mac"`
 	Source NetSource  `xml:"source"`
 	Model  NetModel   `xml:"model"`
+	// MTU and Bandwidth are omitted if nil.
+	MTU       *MTU       `xml:"mtu,omitempty"`
+	Bandwidth *Bandwidth `xml:"bandwidth,omitempty"`
+	FilterRef *FilterRef `xml:"filterref,omitempty"`
 }
 
 // MACAddress represents the MAC address of the interface
@@ -290,31 +295,35 @@ func NewDomain(
 				{Type: "usb", Index: "0", Model: "qemu-xhci"},
 				// Add additional controllers as needed
 			},
-			Disk: Disk{
-				Type:   DiskTypeFile,
-				Device: DiskDeviceDisk,
-				Driver: DiskDriver{
-					Name: DriverNameQEMU,
-					Type: DiskFormatQCOW2,
-				},
-				Source: DiskSource{
-					File: source,
-				},
-				Target: DiskTarget{
-					Dev: TargetDevVDA,
-					Bus: VirtIO,
+			Disks: []Disk{
+				{
+					Type:   DiskTypeFile,
+					Device: DiskDeviceDisk,
+					Driver: DiskDriver{
+						Name: DriverNameQEMU,
+						Type: DiskFormatQCOW2,
+					},
+					Source: DiskSource{
+						File: source,
+					},
+					Target: DiskTarget{
+						Dev: TargetDevVDA,
+						Bus: VirtIO,
+					},
 				},
 			},
-			Interface: Interface{
-				Type: NetTypeNetwork,
-				MAC: MACAddress{
-					Address: mac_address,
-				},
-				Source: NetSource{
-					Network: network,
-				},
-				Model: NetModel{
-					Type: VirtIO,
+			Interfaces: []Interface{
+				{
+					Type: NetTypeNetwork,
+					MAC: MACAddress{
+						Address: mac_address,
+					},
+					Source: NetSource{
+						Network: network,
+					},
+					Model: NetModel{
+						Type: VirtIO,
+					},
 				},
 			},
 			Channel: Channel{
@@ -357,6 +366,52 @@ func NewDomain(
 	}
 }
 
+// AddDisk attaches an extra file-backed disk to the domain.
+// An empty cache mode leaves the hypervisor default.
+func (d *Domain) AddDisk(source, target, bus, format, cache string) {
+	d.Devices.Disks = append(d.Devices.Disks, Disk{
+		Type:   DiskTypeFile,
+		Device: DiskDeviceDisk,
+		Driver: DiskDriver{
+			Name:  DriverNameQEMU,
+			Type:  format,
+			Cache: cache,
+		},
+		Source: DiskSource{
+			File: source,
+		},
+		Target: DiskTarget{
+			Dev: target,
+			Bus: bus,
+		},
+	})
+}
+
+// NewInterface returns an interface attached to a libvirt network.
+func NewInterface(network, macAddress, model string) Interface {
+	return Interface{
+		Type: NetTypeNetwork,
+		MAC: MACAddress{
+			Address: macAddress,
+		},
+		Source: NetSource{
+			Network: network,
+		},
+		Model: NetModel{
+			Type: model,
+		},
+	}
+}
+
+// GenerateXML returns the XML representation of the interface, as used
+// by device updates.
+func (i Interface) GenerateXML() ([]byte, error) {
+	return xml.MarshalIndent(struct {
+		XMLName xml.Name `xml:"interface"`
+		Interface
+	}{Interface: i}, "", "  ")
+}
+
 // GenerateXML returns the XML representation of the Domain.
 func (d *Domain) GenerateXML() ([]byte, error) {
 	return xml.MarshalIndent(d, "", "  ")
@@ -0,0 +1,90 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// journalEntry is one completed step of an apply that has not been
+// committed yet.
+type journalEntry struct {
+	ID   int64
+	Step string
+	Data string
+}
+
+// ensureJournalTable creates the journal table if it doesn't exist.
+// The journal is owned by the step runner, not by the generic state store:
+// rows only live between the first completed step and the end of the apply.
+func ensureJournalTable(ctx context.Context, db *sql.DB) error {
+	const schema = `
+	CREATE TABLE IF NOT EXISTS journal (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		type       TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		namespace  TEXT NOT NULL DEFAULT '',
+		step       TEXT NOT NULL,
+		data       TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_journal_type_name_ns
+		ON journal(type, name, namespace);
+	`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("ensure journal table: %w", err)
+	}
+	return nil
+}
+
+func (r *Runner) record(step, data string) (int64, error) {
+	const query = `
+	INSERT INTO journal (type, name, namespace, step, data)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := r.db.ExecContext(r.ctx, query, r.typeName, r.name, r.namespace, step, data)
+	if err != nil {
+		return 0, fmt.Errorf("record step %q: %w", step, err)
+	}
+	return result.LastInsertId()
+}
+
+func (r *Runner) forget(id int64) error {
+	const query = `DELETE FROM journal WHERE id = ?`
+	if _, err := r.db.ExecContext(r.ctx, query, id); err != nil {
+		return fmt.Errorf("forget step %d: %w", id, err)
+	}
+	return nil
+}
+
+func (r *Runner) commit() error {
+	const query = `DELETE FROM journal WHERE type = ? AND name = ? AND namespace = ?`
+	if _, err := r.db.ExecContext(r.ctx, query, r.typeName, r.name, r.namespace); err != nil {
+		return fmt.Errorf("commit journal: %w", err)
+	}
+	return nil
+}
+
+// pending returns the steps left behind by an interrupted run, oldest first.
+func (r *Runner) pending() ([]journalEntry, error) {
+	const query = `
+	SELECT id, step, data FROM journal
+	WHERE type = ? AND name = ? AND namespace = ?
+	ORDER BY id
+	`
+	rows, err := r.db.QueryContext(r.ctx, query, r.typeName, r.name, r.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []journalEntry
+	for rows.Next() {
+		var entry journalEntry
+		if err := rows.Scan(&entry.ID, &entry.Step, &entry.Data); err != nil {
+			return nil, fmt.Errorf("scan journal: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
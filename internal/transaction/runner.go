@@ -0,0 +1,136 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/zakariakebairia/kvmcli/internal/logger"
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+// Step is one unit of work of an apply, paired with the compensation
+// that undoes it.
+type Step struct {
+	Name string
+	// Do performs the step. The returned data is persisted with the step
+	// and handed back to Undo, so a later process can undo it too.
+	Do func() (data string, err error)
+	// Undo compensates a completed Do. It may be nil for steps with
+	// nothing to undo.
+	Undo func(data string) error
+}
+
+// Runner executes the steps of one resource in order.
+//
+// The compensation of a step is registered as soon as the step succeeds,
+// so a failure rolls back every completed step, most recent first.
+// Completed steps are journaled in the state DB until the run ends: if the
+// process dies mid-run, the next run of the same resource finds them and
+// cleans them up before starting over.
+type Runner struct {
+	ctx       context.Context
+	db        *sql.DB
+	typeName  string
+	name      string
+	namespace string
+}
+
+// NewRunner returns a runner journaling the steps of object.
+func NewRunner(session registry.Session, object *registry.Object) *Runner {
+	return &Runner{
+		ctx:       session.Ctx,
+		db:        session.DB,
+		typeName:  object.TypeName,
+		name:      object.Name,
+		namespace: object.Namespace,
+	}
+}
+
+// Recover compensates the steps journaled by an interrupted run of the
+// resource. Callers that acquire resources before Run, like addresses
+// reused from an earlier run, call it first so the cleanup can't release
+// what they just acquired. steps only needs the Name and Undo of every
+// step a run may journal.
+func (r *Runner) Recover(steps []Step) error {
+	if err := ensureJournalTable(r.ctx, r.db); err != nil {
+		return err
+	}
+	return r.recover(steps)
+}
+
+// Run cleans up any interrupted run, then executes steps in order.
+// If a step fails, the completed steps are compensated and the step
+// error is returned.
+func (r *Runner) Run(steps []Step) error {
+	if err := r.Recover(steps); err != nil {
+		return err
+	}
+
+	var done []journalEntry
+	for _, step := range steps {
+		data, err := step.Do()
+		if err != nil {
+			r.rollback(steps, done)
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+
+		id, err := r.record(step.Name, data)
+		done = append(done, journalEntry{ID: id, Step: step.Name, Data: data})
+		if err != nil {
+			r.rollback(steps, done)
+			return err
+		}
+	}
+	return r.commit()
+}
+
+// recover compensates the steps journaled by an interrupted run.
+func (r *Runner) recover(steps []Step) error {
+	entries, err := r.pending()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	resource := r.typeName + "/" + r.name
+	logger.Warnf("%s: cleaning up %d step(s) of an interrupted run", resource, len(entries))
+	if errs := r.rollback(steps, entries); len(errs) > 0 {
+		return fmt.Errorf(
+			"%s: clean up interrupted run: %w",
+			resource,
+			errors.Join(errs...),
+		)
+	}
+	return nil
+}
+
+// rollback undoes the given completed steps in reverse order. Entries whose
+// compensation fails stay in the journal so a later run can retry them.
+func (r *Runner) rollback(steps []Step, done []journalEntry) []error {
+	var errs []error
+	for _, entry := range slices.Backward(done) {
+		index := slices.IndexFunc(steps, func(s Step) bool { return s.Name == entry.Step })
+		if index < 0 {
+			errs = append(errs, fmt.Errorf("undo %q: unknown step", entry.Step))
+			continue
+		}
+		if undo := steps[index].Undo; undo != nil {
+			if err := undo(entry.Data); err != nil {
+				logger.Warnf("%s/%s: undo %q: %v", r.typeName, r.name, entry.Step, err)
+				errs = append(errs, fmt.Errorf("undo %q: %w", entry.Step, err))
+				continue
+			}
+		}
+		if entry.ID != 0 {
+			if err := r.forget(entry.ID); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,138 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/zakariakebairia/kvmcli/internal/registry"
+)
+
+func newSession(t *testing.T) registry.Session {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return registry.Session{Ctx: context.Background(), DB: db}
+}
+
+// addresses stands for an allocator the caller reuses from before Run,
+// like IPAM: an address held by the VM is reused, not allocated again.
+type addresses struct {
+	held map[string]bool
+}
+
+// acquire returns the address of the VM and whether it was allocated now.
+func (a *addresses) acquire() (string, bool) {
+	if a.held["10.0.0.2"] {
+		return "10.0.0.2", false
+	}
+	a.held["10.0.0.2"] = true
+	return "10.0.0.2", true
+}
+
+func (a *addresses) steps(ip string, allocated bool, fail func(string) error) []Step {
+	return []Step{
+		{
+			Name: "ip-allocation",
+			Do: func() (string, error) {
+				if !allocated {
+					return "", nil
+				}
+				return ip, nil
+			},
+			Undo: func(ip string) error {
+				if ip != "" {
+					delete(a.held, ip)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "domain",
+			Do:   func() (string, error) { return "vm1", fail("domain") },
+		},
+	}
+}
+
+func TestRunRollsBack(t *testing.T) {
+	session := newSession(t)
+	object := &registry.Object{TypeName: "vm", Name: "vm1"}
+	a := &addresses{held: map[string]bool{}}
+
+	ip, allocated := a.acquire()
+	err := NewRunner(session, object).Run(a.steps(ip, allocated, func(string) error {
+		return errors.New("no domain")
+	}))
+	if err == nil {
+		t.Fatal("Run() succeeded, want the error of the failed step")
+	}
+	if a.held[ip] {
+		t.Errorf("%s still held after a failed run", ip)
+	}
+	entries, err := NewRunner(session, object).pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("journal keeps %d entries after a rollback", len(entries))
+	}
+}
+
+func TestRecoverInterruptedRun(t *testing.T) {
+	session := newSession(t)
+	object := &registry.Object{TypeName: "vm", Name: "vm1"}
+	a := &addresses{held: map[string]bool{}}
+
+	// The first create dies in the middle of the domain step: the
+	// allocation stays journaled and the address stays held.
+	ip, allocated := a.acquire()
+	func() {
+		defer func() { recover() }()
+		NewRunner(session, object).Run(a.steps(ip, allocated, func(string) error {
+			panic("killed")
+		}))
+	}()
+	entries, err := NewRunner(session, object).pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(entries); got != 1 || entries[0].Step != "ip-allocation" {
+		t.Fatalf("journal = %v, want the ip-allocation step", entries)
+	}
+
+	// Re-applying cleans up first, then acquires the address again.
+	runner := NewRunner(session, object)
+	if err := runner.Recover(a.steps("", false, nil)); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if a.held[ip] {
+		t.Fatalf("%s still held after recovering the interrupted run", ip)
+	}
+	ip, allocated = a.acquire()
+	var done []string
+	err = runner.Run(a.steps(ip, allocated, func(step string) error {
+		done = append(done, step)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(done, []string{"domain"}) {
+		t.Errorf("steps run = %v, want [domain]", done)
+	}
+	if !a.held[ip] {
+		t.Errorf("%s released by the re-apply that acquired it", ip)
+	}
+	if entries, _ := runner.pending(); len(entries) != 0 {
+		t.Errorf("journal keeps %d entries after a successful run", len(entries))
+	}
+}